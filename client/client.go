@@ -0,0 +1,235 @@
+// Package client implements a Go client for browsing and downloading files
+// from a GoShare server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Entry describes a file or directory on the server
+type Entry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	IsDir    bool      `json:"is_dir"`
+	MimeType string    `json:"mime_type,omitempty"`
+	URL      string    `json:"url"`
+	SHA256   string    `json:"sha256,omitempty"`
+}
+
+// Listing is the content of a directory on the server. When the listed path
+// is a file, IsDir is false and Entries holds just that file.
+type Listing struct {
+	Path    string  `json:"path"`
+	IsDir   bool    `json:"is_dir"`
+	Entries []Entry `json:"entries"`
+}
+
+// Client talks to a single GoShare server
+type Client struct {
+	// BaseURL is the server address without the key, e.g. http://192.168.1.10:8080
+	BaseURL *url.URL
+	// Key is the secret key printed by the server at startup
+	Key string
+	// HTTPClient is used for all requests; http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// New creates a client from a server URL as printed by the server, e.g.
+// http://192.168.1.10:8080/?key=abc. The key is taken from the URL query.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	key := u.Query().Get("key")
+	if key == "" {
+		return nil, errors.New("server URL does not contain a key parameter")
+	}
+
+	base := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	return &Client{BaseURL: base, Key: key}, nil
+}
+
+// httpClient returns the HTTP client used for requests
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// newRequest builds an authenticated request for the given server path
+func (c *Client) newRequest(ctx context.Context, method, p string, body io.Reader) (*http.Request, error) {
+	u := *c.BaseURL
+	u.Path = p
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Key)
+	return req, nil
+}
+
+// do sends the request and turns non-2xx responses into errors
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		// API errors come in a JSON envelope, other errors as plain text
+		var envelope struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(msg, &envelope) == nil && envelope.Error.Message != "" {
+			msg = []byte(envelope.Error.Message)
+		}
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			msg:        fmt.Sprintf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+	return resp, nil
+}
+
+// statusError is returned for responses outside the 2xx range
+type statusError struct {
+	StatusCode int
+	msg        string
+}
+
+// Error implements error
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// List returns the listing of the given path, e.g. "shared/docs" or "uploads".
+// An empty path lists the top-level folders. When withChecksums is set the
+// server computes the SHA-256 digest of every listed file.
+func (c *Client) List(ctx context.Context, p string, withChecksums bool) (*Listing, error) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	apiPath := "/api/v1/files/" + p
+
+	req, err := c.newRequest(ctx, http.MethodGet, apiPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if withChecksums {
+		req.URL.RawQuery = "checksum=sha256"
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var listing Listing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, fmt.Errorf("decoding listing: %w", err)
+	}
+	return &listing, nil
+}
+
+// Walk calls fn for every file below the given path, descending into
+// directories. Directories themselves are not passed to fn.
+func (c *Client) Walk(ctx context.Context, p string, withChecksums bool, fn func(Entry) error) error {
+	listing, err := c.List(ctx, p, withChecksums)
+	if err != nil {
+		return err
+	}
+	for _, entry := range listing.Entries {
+		if entry.IsDir {
+			if err := c.Walk(ctx, entry.Path, withChecksums, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Limits are the transfer limits of the server; rates are in bytes per second
+// and 0 means unlimited
+type Limits struct {
+	DownloadRate       int64 `json:"download_rate"`
+	UploadRate         int64 `json:"upload_rate"`
+	ClientDownloadRate int64 `json:"client_download_rate"`
+	ClientUploadRate   int64 `json:"client_upload_rate"`
+	MaxTransfers       int   `json:"max_transfers"`
+	// Active and Queued are the transfers running and waiting for a slot
+	Active int `json:"active"`
+	Queued int `json:"queued"`
+}
+
+// LimitsUpdate changes the transfer limits that are not nil, keeping the others
+type LimitsUpdate struct {
+	DownloadRate       *int64 `json:"download_rate,omitempty"`
+	UploadRate         *int64 `json:"upload_rate,omitempty"`
+	ClientDownloadRate *int64 `json:"client_download_rate,omitempty"`
+	ClientUploadRate   *int64 `json:"client_upload_rate,omitempty"`
+	MaxTransfers       *int   `json:"max_transfers,omitempty"`
+}
+
+// Limits returns the transfer limits of the server; this needs the admin key
+func (c *Client) Limits(ctx context.Context) (*Limits, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/limits", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.doLimits(req)
+}
+
+// UpdateLimits changes the transfer limits of the running server and returns
+// the new limits; this needs the admin key
+func (c *Client) UpdateLimits(ctx context.Context, update LimitsUpdate) (*Limits, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPatch, "/api/v1/limits", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.doLimits(req)
+}
+
+// doLimits sends a request to the limits endpoint and decodes the response
+func (c *Client) doLimits(req *http.Request) (*Limits, error) {
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var limits Limits
+	if err := json.NewDecoder(resp.Body).Decode(&limits); err != nil {
+		return nil, fmt.Errorf("decoding limits: %w", err)
+	}
+	return &limits, nil
+}
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer serves a single file at /shared/data.bin together with its listing
+func newTestServer(t *testing.T, content []byte, rangeRequests *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	entry := Entry{Name: "data.bin", Path: "shared/data.bin", Size: int64(len(content)), URL: "/shared/data.bin"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/files/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Listing{Path: "shared/data.bin", Entries: []Entry{entry}})
+	})
+	mux.HandleFunc("/shared/data.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			rangeRequests.Add(1)
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestNew tests parsing the server URL
+func TestNew(t *testing.T) {
+	c, err := New("http://192.168.1.10:8080/?key=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Key != "abc" || c.BaseURL.String() != "http://192.168.1.10:8080" {
+		t.Errorf("Unexpected client: %+v", c)
+	}
+
+	if _, err := New("http://192.168.1.10:8080/"); err == nil {
+		t.Error("Expected an error for a URL without key")
+	}
+}
+
+// TestDownloadResume tests that an existing partial file is continued with a Range request
+func TestDownloadResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	var rangeRequests atomic.Int32
+	srv := newTestServer(t, content, &rangeRequests)
+
+	c, err := New(srv.URL + "/?key=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing, err := c.List(context.Background(), "shared/data.bin", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(dest+partSuffix, content[:300], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Download(context.Background(), listing.Entries[0], dest, DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Downloaded content does not match")
+	}
+	if rangeRequests.Load() != 1 {
+		t.Errorf("Expected 1 range request, got %d", rangeRequests.Load())
+	}
+}
+
+// TestDownloadStalePart tests that a partial file at least as large as the remote file is replaced
+func TestDownloadStalePart(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	for _, part := range [][]byte{bytes.Repeat([]byte("x"), len(content)), bytes.Repeat([]byte("x"), 2*len(content))} {
+		var rangeRequests atomic.Int32
+		srv := newTestServer(t, content, &rangeRequests)
+
+		c, err := New(srv.URL + "/?key=secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry := Entry{Name: "data.bin", Path: "shared/data.bin", Size: int64(len(content)), URL: "/shared/data.bin"}
+		dest := filepath.Join(t.TempDir(), "data.bin")
+		if err := os.WriteFile(dest+partSuffix, part, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Download(context.Background(), entry, dest, DownloadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+			t.Errorf("Expected the download to start over for a %d byte partial file", len(part))
+		}
+	}
+}
+
+// TestDownloadRangeNotSatisfiable tests that a download starts over when the server rejects the resume range
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shared/data.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, err := New(srv.URL + "/?key=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{Name: "data.bin", Path: "shared/data.bin", Size: 5, URL: "/shared/data.bin"}
+	dest := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(dest+partSuffix, []byte("he"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Download(context.Background(), entry, dest, DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "hello" {
+		t.Errorf("Expected hello, got %q", got)
+	}
+}
+
+// TestDownloadParallel tests splitting a download across connections
+func TestDownloadParallel(t *testing.T) {
+	content := []byte(strings.Repeat("abcdefghij", 1000))
+	var rangeRequests atomic.Int32
+	srv := newTestServer(t, content, &rangeRequests)
+
+	c, err := New(srv.URL + "/?key=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := Entry{Name: "data.bin", Path: "shared/data.bin", Size: int64(len(content)), URL: "/shared/data.bin"}
+	dest := filepath.Join(t.TempDir(), "data.bin")
+	opts := DownloadOptions{Connections: 4, ParallelThreshold: 1}
+	if err := c.Download(context.Background(), entry, dest, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Downloaded content does not match")
+	}
+	if rangeRequests.Load() != 4 {
+		t.Errorf("Expected 4 range requests, got %d", rangeRequests.Load())
+	}
+}
+
+// TestDownloadChecksumMismatch tests that corrupted downloads are rejected
+func TestDownloadChecksumMismatch(t *testing.T) {
+	var rangeRequests atomic.Int32
+	srv := newTestServer(t, []byte("content"), &rangeRequests)
+
+	c, err := New(srv.URL + "/?key=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := Entry{Name: "data.bin", Path: "shared/data.bin", Size: 7, URL: "/shared/data.bin", SHA256: strings.Repeat("0", 64)}
+	dest := filepath.Join(t.TempDir(), "data.bin")
+	if err := c.Download(context.Background(), entry, dest, DownloadOptions{}); err == nil {
+		t.Fatal("Expected a checksum mismatch error")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("Expected no file to be stored after a checksum mismatch")
+	}
+}
+
+// TestDownloadEscapedURL tests downloading a file whose name needs escaping in its URL
+func TestDownloadEscapedURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shared/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shared/a#1?.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, err := New(srv.URL + "/?key=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{Name: "a#1?.txt", Path: "shared/a#1?.txt", Size: 5, URL: "/shared/a%231%3F.txt"}
+	dest := filepath.Join(t.TempDir(), "a.txt")
+	if err := c.Download(context.Background(), entry, dest, DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "hello" {
+		t.Errorf("Expected hello, got %q", got)
+	}
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DownloadOptions controls how files are downloaded
+type DownloadOptions struct {
+	// Connections is the number of parallel connections used for large files
+	Connections int
+	// ParallelThreshold is the minimum size of a file downloaded in parallel
+	ParallelThreshold int64
+	// Progress, when set, is called with the number of bytes received
+	Progress func(n int64)
+}
+
+// DefaultParallelThreshold is the file size above which downloads are split across connections
+const DefaultParallelThreshold = 64 << 20
+
+// partSuffix is appended to files while they are being downloaded
+const partSuffix = ".part"
+
+// Download fetches the file described by entry into dest. Data is first written
+// to dest + ".part", so an interrupted download is resumed on the next call with
+// a Range request. When entry carries a SHA-256 digest the result is verified
+// before it is moved into place.
+func (c *Client) Download(ctx context.Context, entry Entry, dest string, opts DownloadOptions) error {
+	if entry.IsDir {
+		return fmt.Errorf("%s is a directory", entry.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
+	partPath := dest + partSuffix
+	threshold := opts.ParallelThreshold
+	if threshold <= 0 {
+		threshold = DefaultParallelThreshold
+	}
+
+	var err error
+	_, statErr := os.Stat(partPath)
+	if opts.Connections > 1 && entry.Size >= threshold && os.IsNotExist(statErr) {
+		err = c.downloadParallel(ctx, entry, partPath, opts)
+	} else {
+		err = c.downloadResumable(ctx, entry, partPath, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if entry.SHA256 != "" {
+		sum, err := sha256File(partPath)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, entry.SHA256) {
+			os.Remove(partPath)
+			return fmt.Errorf("%s: checksum mismatch: expected %s, got %s", entry.Path, entry.SHA256, sum)
+		}
+	}
+
+	return os.Rename(partPath, dest)
+}
+
+// downloadResumable downloads the file over a single connection, continuing
+// from the end of an existing partial file
+func (c *Client) downloadResumable(ctx context.Context, entry Entry, partPath string, opts DownloadOptions) error {
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > 0 && offset >= entry.Size {
+		// Nothing is left to resume, and the remote file may have changed since; start over
+		if err := resetFile(f); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	resp, err := c.fetchFrom(ctx, entry, offset)
+	var statusErr *statusError
+	if offset > 0 && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The partial file does not match the remote file anymore, start over
+		if err := resetFile(f); err != nil {
+			return err
+		}
+		offset = 0
+		resp, err = c.fetchFrom(ctx, entry, offset)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The server ignored the Range header, start over
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		if err := resetFile(f); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(f, progressReader{r: resp.Body, progress: opts.Progress})
+	return err
+}
+
+// fetchFrom requests the file of an entry starting at offset
+func (c *Client) fetchFrom(ctx context.Context, entry Entry, offset int64) (*http.Response, error) {
+	req, err := c.newDownloadRequest(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return c.do(req)
+}
+
+// resetFile empties a partial file so the download starts over
+func resetFile(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// downloadParallel splits the file into ranges fetched over separate connections
+func (c *Client) downloadParallel(ctx context.Context, entry Entry, partPath string, opts DownloadOptions) error {
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(entry.Size); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	chunk := (entry.Size + int64(opts.Connections) - 1) / int64(opts.Connections)
+	for start := int64(0); start < entry.Size; start += chunk {
+		end := min(start+chunk, entry.Size) - 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.downloadRange(ctx, entry, f, start, end, opts); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := firstErr; err != nil {
+		// Partial ranges cannot be resumed, so do not leave them behind
+		f.Close()
+		os.Remove(partPath)
+		return err
+	}
+	return nil
+}
+
+// downloadRange fetches bytes start..end (inclusive) of the file into f
+func (c *Client) downloadRange(ctx context.Context, entry Entry, f *os.File, start, end int64, opts DownloadOptions) error {
+	req, err := c.newDownloadRequest(ctx, entry)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%s: server does not support range requests", entry.Path)
+	}
+
+	w := io.NewOffsetWriter(f, start)
+	n, err := io.Copy(w, progressReader{r: resp.Body, progress: opts.Progress})
+	if err != nil {
+		return err
+	}
+	if n != end-start+1 {
+		return fmt.Errorf("%s: short read for range %d-%d", entry.Path, start, end)
+	}
+	return nil
+}
+
+// progressReader reports the number of bytes read through it
+type progressReader struct {
+	r        io.Reader
+	progress func(n int64)
+}
+
+// Read implements io.Reader
+func (p progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 && p.progress != nil {
+		p.progress(int64(n))
+	}
+	return n, err
+}
+
+// sha256File returns the hex encoded SHA-256 digest of a local file
+func sha256File(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newDownloadRequest builds the request for the file of an entry; its URL is
+// escaped, while newRequest expects a plain path
+func (c *Client) newDownloadRequest(ctx context.Context, entry Entry) (*http.Request, error) {
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL of %s: %w", entry.Path, err)
+	}
+	return c.newRequest(ctx, http.MethodGet, u.Path, nil)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/piotrszyma/goshare/internal/discovery"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// DiscoverTimeout is how long to wait for servers to answer
+	DiscoverTimeout time.Duration
+)
+
+// discoverCmd represents the discover command
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Find GoShare servers on the local network",
+	Long: `Find GoShare servers advertised on the local network over mDNS / DNS-SD.
+
+The servers do not advertise their key, so the printed URLs still need the
+key shown by the server before they can be used.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(cmd.Context(), DiscoverTimeout)
+		defer cancel()
+
+		servers, err := discovery.Browse(ctx)
+		if err != nil {
+			return err
+		}
+		if len(servers) == 0 {
+			fmt.Println("No GoShare servers found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "NAME\tVERSION\tCAPABILITIES\tURL")
+		for _, s := range servers {
+			urls := s.URLs()
+			if len(urls) == 0 {
+				urls = []string{fmt.Sprintf("http://%s:%d/", strings.TrimSuffix(s.Host, "."), s.Port)}
+			}
+			for i, u := range urls {
+				if i == 0 {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, s.TXT["version"], s.TXT["caps"], u)
+				} else {
+					fmt.Fprintf(w, "\t\t\t%s\n", u)
+				}
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(discoverCmd)
+
+	discoverCmd.Flags().DurationVarP(&DiscoverTimeout, "timeout", "t", 3*time.Second, "How long to wait for servers to answer")
+}
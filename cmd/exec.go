@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/piotrszyma/goshare/internal/webserver"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// ExecSharePath is the file or directory shared next to the command output
+	ExecSharePath string
+	// ExecPort is the port number for the web server
+	ExecPort int
+	// ExecBind is the IP address the web server listens on
+	ExecBind string
+	// ExecInterface is the network interface the web server listens on
+	ExecInterface string
+	// ExecTimeout exits after a period without activity
+	ExecTimeout time.Duration
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] -- <command> [args...]",
+	Short: "Run a command and share its output live",
+	Long: `Run a command and share its stdout and stderr as a live stream.
+
+Browsers follow the output as it is written, viewers who connect late see it
+from the start, and curl gets it as plain chunked text:
+
+  goshare exec --share ./dist -- make release
+
+The server keeps running after the command has finished, until Ctrl-C or
+--timeout.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := webserver.Run(webserver.Config{
+			SharePath: ExecSharePath,
+			Bind:      ExecBind,
+			Interface: ExecInterface,
+			Port:      ExecPort,
+			Exec:      args,
+			Timeout:   ExecTimeout,
+			MDNS:      true,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	// Flags after the command name belong to the command
+	execCmd.Flags().SetInterspersed(false)
+
+	execCmd.Flags().StringVar(&ExecSharePath, "share", "", "Path to file or directory to share next to the output")
+	execCmd.Flags().IntVar(&ExecPort, "port", 0, "Port number for the web server (default: random available port)")
+	execCmd.Flags().StringVar(&ExecBind, "bind", "", "IP address to listen on (default: all addresses)")
+	execCmd.Flags().StringVar(&ExecInterface, "interface", "", "Network interface to listen on (default: all interfaces)")
+	execCmd.Flags().DurationVar(&ExecTimeout, "timeout", 0, "Exit with an error after this long without any request, e.g. 10m")
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dayDuration is a duration flag that also accepts days, e.g. 7d or 1d12h
+type dayDuration time.Duration
+
+func (d *dayDuration) String() string {
+	if *d == 0 {
+		return "0"
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *dayDuration) Set(s string) error {
+	var days int64
+	if before, after, ok := strings.Cut(s, "d"); ok {
+		n, err := strconv.ParseInt(before, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number of days %q", before)
+		}
+		days, s = n, after
+	}
+	var rest time.Duration
+	if s != "" {
+		var err error
+		if rest, err = time.ParseDuration(s); err != nil {
+			return err
+		}
+	}
+	*d = dayDuration(time.Duration(days)*24*time.Hour + rest)
+	return nil
+}
+
+func (d *dayDuration) Type() string {
+	return "duration"
+}
+
+// byteSize is a size flag accepting units like 500MB or 20GB; units are powers of 1024
+type byteSize int64
+
+// byteUnits are the units of byteSize, longest suffixes first so that "B" does not match "GB"
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if number, ok := strings.CutSuffix(value, u.suffix); ok {
+			value, unit = strings.TrimSpace(number), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q, expected e.g. 500MB or 20GB", s)
+	}
+	*b = byteSize(n * float64(unit))
+	return nil
+}
+
+func (b *byteSize) Type() string {
+	return "size"
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/piotrszyma/goshare/client"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// GetOutputDir is the directory downloaded files are written to
+	GetOutputDir string
+	// GetRecursive downloads directories recursively
+	GetRecursive bool
+	// GetConnections is the number of parallel connections for large files
+	GetConnections int
+	// GetVerify verifies downloads against server-side SHA-256 checksums
+	GetVerify bool
+)
+
+// getCmd represents the get command
+var getCmd = &cobra.Command{
+	Use:   "get <url> [paths...]",
+	Short: "Download files from a GoShare server",
+	Long: `Download files from a running GoShare server.
+
+Paths are relative to the server root, e.g. shared/report.pdf or uploads.
+Without paths everything the server offers is downloaded. Interrupted
+downloads are resumed when the command is run again.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := client.New(args[0])
+		if err != nil {
+			return err
+		}
+
+		paths := args[1:]
+		if len(paths) == 0 {
+			paths = []string{""}
+			GetRecursive = true
+		}
+
+		for _, p := range paths {
+			if err := getPath(cmd.Context(), c, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// getPath downloads a single file or, with --recursive, a whole directory
+func getPath(ctx context.Context, c *client.Client, p string) error {
+	listing, err := c.List(ctx, p, GetVerify)
+	if err != nil {
+		return err
+	}
+
+	if !listing.IsDir {
+		if len(listing.Entries) != 1 {
+			return fmt.Errorf("%s: expected 1 entry in the listing of a file, got %d", p, len(listing.Entries))
+		}
+		entry := listing.Entries[0]
+		dest, err := localPath(entry.Name)
+		if err != nil {
+			return err
+		}
+		return getEntry(ctx, c, entry, dest)
+	}
+
+	if !GetRecursive {
+		return fmt.Errorf("%s is a directory (use --recursive to download it)", p)
+	}
+
+	// Keep the directory structure below the requested directory
+	base := path.Dir(listing.Path)
+	if base == "." {
+		base = ""
+	}
+	return c.Walk(ctx, p, GetVerify, func(entry client.Entry) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(entry.Path, base), "/")
+		dest, err := localPath(rel)
+		if err != nil {
+			return err
+		}
+		return getEntry(ctx, c, entry, dest)
+	})
+}
+
+// localPath maps a slash-separated path sent by the server onto the output
+// directory, refusing paths that would end up outside of it
+func localPath(rel string) (string, error) {
+	local := filepath.FromSlash(rel)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("refusing to write %q outside of %s", rel, GetOutputDir)
+	}
+	return filepath.Join(GetOutputDir, local), nil
+}
+
+// getEntry downloads one file and reports the result
+func getEntry(ctx context.Context, c *client.Client, entry client.Entry, dest string) error {
+	err := c.Download(ctx, entry, dest, client.DownloadOptions{Connections: GetConnections})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s -> %s (%d bytes)\n", entry.Path, dest, entry.Size)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(getCmd)
+
+	getCmd.Flags().StringVarP(&GetOutputDir, "output", "o", ".", "Directory to store downloaded files")
+	getCmd.Flags().BoolVarP(&GetRecursive, "recursive", "r", false, "Download directories recursively")
+	getCmd.Flags().IntVarP(&GetConnections, "connections", "c", 4, "Parallel connections used for large files")
+	getCmd.Flags().BoolVar(&GetVerify, "verify", false, "Verify downloads against SHA-256 checksums computed by the server")
+}
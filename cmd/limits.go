@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/piotrszyma/goshare/client"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// LimitsDownloadRate is the new download bandwidth of all clients together
+	LimitsDownloadRate byteSize
+	// LimitsUploadRate is the new upload bandwidth of all clients together
+	LimitsUploadRate byteSize
+	// LimitsClientDownloadRate is the new download bandwidth of every client
+	LimitsClientDownloadRate byteSize
+	// LimitsClientUploadRate is the new upload bandwidth of every client
+	LimitsClientUploadRate byteSize
+	// LimitsMaxTransfers is the new number of transfers running at once
+	LimitsMaxTransfers int
+)
+
+// limitsCmd represents the limits command
+var limitsCmd = &cobra.Command{
+	Use:   "limits <url>",
+	Short: "Show or change the transfer limits of a running GoShare server",
+	Long: `Show the bandwidth limits and the number of running and queued transfers
+of a running GoShare server, or change them without restarting it.
+
+The URL is the one printed by the server, including the admin key. Only the
+limits given as flags are changed, 0 removes a limit:
+
+  goshare limits http://192.168.1.10:8080/?key=abc
+  goshare limits http://192.168.1.10:8080/?key=abc --download-rate 2MB --max-transfers 3
+  goshare limits http://192.168.1.10:8080/?key=abc --client-upload-rate 0`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := client.New(args[0])
+		if err != nil {
+			return err
+		}
+
+		// Only send the limits that were given, the server keeps the others
+		var update client.LimitsUpdate
+		changed := false
+		rate := func(flag string, value byteSize, field **int64) {
+			if cmd.Flags().Changed(flag) {
+				n := int64(value)
+				*field, changed = &n, true
+			}
+		}
+		rate("download-rate", LimitsDownloadRate, &update.DownloadRate)
+		rate("upload-rate", LimitsUploadRate, &update.UploadRate)
+		rate("client-download-rate", LimitsClientDownloadRate, &update.ClientDownloadRate)
+		rate("client-upload-rate", LimitsClientUploadRate, &update.ClientUploadRate)
+		if cmd.Flags().Changed("max-transfers") {
+			update.MaxTransfers, changed = &LimitsMaxTransfers, true
+		}
+
+		var limits *client.Limits
+		if changed {
+			limits, err = c.UpdateLimits(cmd.Context(), update)
+		} else {
+			limits, err = c.Limits(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintf(w, "Download rate:\t%s\n", formatRate(limits.DownloadRate))
+		fmt.Fprintf(w, "Upload rate:\t%s\n", formatRate(limits.UploadRate))
+		fmt.Fprintf(w, "Download rate per client:\t%s\n", formatRate(limits.ClientDownloadRate))
+		fmt.Fprintf(w, "Upload rate per client:\t%s\n", formatRate(limits.ClientUploadRate))
+		maxTransfers := "unlimited"
+		if limits.MaxTransfers > 0 {
+			maxTransfers = fmt.Sprint(limits.MaxTransfers)
+		}
+		fmt.Fprintf(w, "Concurrent transfers:\t%s\n", maxTransfers)
+		fmt.Fprintf(w, "Running / queued:\t%d / %d\n", limits.Active, limits.Queued)
+		return nil
+	},
+}
+
+// formatRate formats a rate in bytes per second in the units the flags accept, e.g. 2MB/s
+func formatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	for _, u := range byteUnits {
+		if len(u.suffix) == 2 && rate%u.size == 0 {
+			return fmt.Sprintf("%d%s/s", rate/u.size, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB/s", rate)
+}
+
+func init() {
+	rootCmd.AddCommand(limitsCmd)
+
+	limitsCmd.Flags().Var(&LimitsDownloadRate, "download-rate", "Bandwidth of all downloads together per second, e.g. 10MB, 0 for no limit")
+	limitsCmd.Flags().Var(&LimitsUploadRate, "upload-rate", "Bandwidth of all uploads together per second, e.g. 5MB, 0 for no limit")
+	limitsCmd.Flags().Var(&LimitsClientDownloadRate, "client-download-rate", "Download bandwidth of every client per second, e.g. 1MB, 0 for no limit")
+	limitsCmd.Flags().Var(&LimitsClientUploadRate, "client-upload-rate", "Upload bandwidth of every client per second, e.g. 1MB, 0 for no limit")
+	limitsCmd.Flags().IntVar(&LimitsMaxTransfers, "max-transfers", 0, "Number of downloads and uploads running at once, 0 for no limit")
+}
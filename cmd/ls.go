@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/piotrszyma/goshare/client"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// LsRecursive lists directories recursively
+	LsRecursive bool
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls <url> [path]",
+	Short: "List files on a GoShare server",
+	Long: `List shared and uploaded files on a running GoShare server.
+
+The URL is the one printed by the server, including the key parameter.
+Without a path the top-level folders (shared, uploads) are listed.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := client.New(args[0])
+		if err != nil {
+			return err
+		}
+
+		p := ""
+		if len(args) > 1 {
+			p = args[1]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer w.Flush()
+
+		if LsRecursive {
+			return c.Walk(cmd.Context(), p, false, func(e client.Entry) error {
+				fmt.Fprintf(w, "%d\t%s\t%s\n", e.Size, formatModTime(e.ModTime), e.Path)
+				return nil
+			})
+		}
+
+		listing, err := c.List(cmd.Context(), p, false)
+		if err != nil {
+			return err
+		}
+		for _, e := range listing.Entries {
+			name := e.Path
+			if e.IsDir {
+				name += "/"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", e.Size, formatModTime(e.ModTime), name)
+		}
+		return nil
+	},
+}
+
+// formatModTime formats a modification time for listings
+func formatModTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+
+	lsCmd.Flags().BoolVarP(&LsRecursive, "recursive", "r", false, "List directories recursively")
+}
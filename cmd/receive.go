@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/piotrszyma/goshare/internal/webserver"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// ReceiveStdout writes the received file to stdout
+	ReceiveStdout bool
+	// ReceiveUploadsDir is the directory the received file is stored in
+	ReceiveUploadsDir string
+	// ReceivePort is the port number for the receiving server
+	ReceivePort int
+	// ReceiveBind is the IP address the receiving server listens on
+	ReceiveBind string
+	// ReceiveInterface is the network interface the receiving server listens on
+	ReceiveInterface string
+	// ReceiveTimeout exits after a period without activity
+	ReceiveTimeout time.Duration
+)
+
+// receiveCmd represents the receive command
+var receiveCmd = &cobra.Command{
+	Use:   "receive",
+	Short: "Receive a single file and exit",
+	Long: `Start a server that accepts a single upload and exits once it has finished.
+
+With --stdout the upload is not stored but written to stdout, so it can be
+piped into other tools:
+
+  goshare receive --stdout | tar x`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := webserver.Run(webserver.Config{
+			UploadsDir:    ReceiveUploadsDir,
+			Bind:          ReceiveBind,
+			Interface:     ReceiveInterface,
+			Port:          ReceivePort,
+			ReceiveOnce:   true,
+			ReceiveStdout: ReceiveStdout,
+			Timeout:       ReceiveTimeout,
+			MDNS:          true,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(receiveCmd)
+
+	receiveCmd.Flags().BoolVar(&ReceiveStdout, "stdout", false, "Write the received file to stdout instead of storing it")
+	receiveCmd.Flags().StringVar(&ReceiveUploadsDir, "uploads-dir", "", "Directory to store the received file (default: uploads/)")
+	receiveCmd.Flags().IntVar(&ReceivePort, "port", 0, "Port number for the web server (default: random available port)")
+	receiveCmd.Flags().StringVar(&ReceiveBind, "bind", "", "IP address to listen on (default: all addresses)")
+	receiveCmd.Flags().StringVar(&ReceiveInterface, "interface", "", "Network interface to listen on (default: all interfaces)")
+	receiveCmd.Flags().DurationVar(&ReceiveTimeout, "timeout", 0, "Exit with an error after this long without any request, e.g. 10m")
+}
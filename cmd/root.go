@@ -3,7 +3,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/piotrszyma/goshare/internal/version"
 	"github.com/piotrszyma/goshare/internal/webserver"
 
 	"github.com/spf13/cobra"
@@ -13,21 +15,137 @@ var (
 	cfgFile string
 	// SharePath is the path to the file or directory to share
 	SharePath string
+	// ShareName is the download name of a stream shared from stdin
+	ShareName string
+	// Follow is a growing file served live
+	Follow string
 	// UploadsDir is the directory to store uploaded files
 	UploadsDir string
+	// Bind is the IP address the web server listens on
+	Bind string
+	// Interface is the network interface the web server listens on
+	Interface string
 	// Port is the port number for the web server
 	Port int
+	// PortRange is the range of ports the web server may listen on
+	PortRange string
+	// UnixSocket is the Unix domain socket the web server listens on
+	UnixSocket string
+	// ShutdownTimeout is how long a shutdown waits for running transfers
+	ShutdownTimeout time.Duration
+	// Once exits after the shared files have been downloaded once
+	Once bool
+	// ReceiveOnce exits after the first upload
+	ReceiveOnce bool
+	// Timeout exits after a period without activity
+	Timeout time.Duration
+	// ManageShare allows changing files in the shared directory
+	ManageShare bool
+	// TrashRetention is how long deleted items are kept in the trash
+	TrashRetention time.Duration
+	// MaxUploadAge removes uploads this long after they were stored
+	MaxUploadAge dayDuration
+	// MaxUploadsSize evicts the oldest uploads while all uploads together are larger
+	MaxUploadsSize byteSize
+	// ExpireAfterDownload removes uploads this long after their first download
+	ExpireAfterDownload dayDuration
+	// QuotaSize limits the size of all uploads together
+	QuotaSize byteSize
+	// QuotaFiles limits the number of uploaded files
+	QuotaFiles int
+	// SessionQuotaSize limits the size of the uploads of every session
+	SessionQuotaSize byteSize
+	// SessionQuotaFiles limits the number of files every session may upload
+	SessionQuotaFiles int
+	// Dedup stores identical uploads once
+	Dedup bool
+	// DownloadRate limits the download bandwidth of all clients together
+	DownloadRate byteSize
+	// UploadRate limits the upload bandwidth of all clients together
+	UploadRate byteSize
+	// ClientDownloadRate limits the download bandwidth of every client
+	ClientDownloadRate byteSize
+	// ClientUploadRate limits the upload bandwidth of every client
+	ClientUploadRate byteSize
+	// MaxTransfers limits the number of transfers running at once
+	MaxTransfers int
+	// SearchContent enables full-text search
+	SearchContent bool
+	// WebDAV enables the WebDAV endpoint
+	WebDAV bool
+	// SFTPAddr is the address of the SFTP listener
+	SFTPAddr string
+	// SFTPAuthorizedKeys is the authorized_keys file for SFTP public key logins
+	SFTPAuthorizedKeys string
+	// SFTPHostKey is the private key file used as SFTP host key
+	SFTPHostKey string
+	// S3Addr is the address of the S3-compatible API listener
+	S3Addr string
+	// MDNS advertises the server on the local network
+	MDNS bool
+	// MDNSName is the advertised instance name
+	MDNSName string
 )
 
 var rootCmd = &cobra.Command{
 	Use:     "goshare",
-	Version: "0.1.0",
+	Version: version.Version,
 	Short:   "A brief description of your application",
 	Long: `A longer description that spans multiple lines and likely contains
 examples and usage of using your application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Starting goshare web server...")
-		webserver.Run(SharePath, UploadsDir, Port)
+		err := webserver.Run(webserver.Config{
+			SharePath:  SharePath,
+			ShareName:  ShareName,
+			Follow:     Follow,
+			UploadsDir: UploadsDir,
+			Bind:       Bind,
+			Interface:  Interface,
+			Port:       Port,
+			PortRange:  PortRange,
+			UnixSocket: UnixSocket,
+			WebDAV:     WebDAV,
+
+			SearchContent:  SearchContent,
+			TrashRetention: TrashRetention,
+			ManageShare:    ManageShare,
+
+			MaxUploadAge:        time.Duration(MaxUploadAge),
+			MaxUploadsSize:      int64(MaxUploadsSize),
+			ExpireAfterDownload: time.Duration(ExpireAfterDownload),
+
+			Dedup: Dedup,
+
+			QuotaBytes:        int64(QuotaSize),
+			QuotaFiles:        QuotaFiles,
+			SessionQuotaBytes: int64(SessionQuotaSize),
+			SessionQuotaFiles: SessionQuotaFiles,
+
+			DownloadRate:       int64(DownloadRate),
+			UploadRate:         int64(UploadRate),
+			ClientDownloadRate: int64(ClientDownloadRate),
+			ClientUploadRate:   int64(ClientUploadRate),
+			MaxTransfers:       MaxTransfers,
+
+			ShutdownTimeout: ShutdownTimeout,
+			Once:            Once,
+			ReceiveOnce:     ReceiveOnce,
+			Timeout:         Timeout,
+
+			SFTPAddr:           SFTPAddr,
+			SFTPAuthorizedKeys: SFTPAuthorizedKeys,
+			SFTPHostKey:        SFTPHostKey,
+
+			S3Addr: S3Addr,
+
+			MDNS:     MDNS,
+			MDNSName: MDNSName,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -45,9 +163,43 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.goshare.yaml)")
 
 	// Add flags for share path and uploads directory
-	rootCmd.Flags().StringVar(&SharePath, "share", "", "Path to file or directory to share")
+	rootCmd.Flags().StringVar(&SharePath, "share", "", "Path to file or directory to share, or - to stream stdin as a single download")
+	rootCmd.Flags().StringVar(&ShareName, "name", "", "Download name of the stream shared with --share - (default: stdin)")
+	rootCmd.Flags().StringVar(&Follow, "follow", "", "Growing file to serve live like tail -f, e.g. build.log")
 	rootCmd.Flags().StringVar(&UploadsDir, "uploads-dir", "", "Directory to store uploaded files (default: uploads/)")
 	rootCmd.Flags().IntVar(&Port, "port", 0, "Port number for the web server (default: random available port)")
+	rootCmd.Flags().StringVar(&Bind, "bind", "", "IP address to listen on, e.g. 192.168.1.10 or :: (default: all addresses)")
+	rootCmd.Flags().StringVar(&Interface, "interface", "", "Network interface to listen on, e.g. wlan0 (default: all interfaces)")
+	rootCmd.Flags().StringVar(&PortRange, "port-range", "", "Range of ports to pick the first free one from, e.g. 8000-8100")
+	rootCmd.Flags().StringVar(&UnixSocket, "unix", "", "Listen on a Unix domain socket instead of TCP, e.g. for a reverse proxy")
+	rootCmd.Flags().DurationVar(&ShutdownTimeout, "shutdown-timeout", webserver.DefaultShutdownTimeout, "How long to wait for running transfers on Ctrl-C before aborting them")
+	rootCmd.Flags().BoolVar(&Once, "once", false, "Exit after the shared file or all shared files have been downloaded once")
+	rootCmd.Flags().BoolVar(&ReceiveOnce, "receive-once", false, "Exit after the first upload has finished")
+	rootCmd.Flags().DurationVar(&Timeout, "timeout", 0, "Exit with an error after this long without any request, e.g. 10m")
+	rootCmd.Flags().BoolVar(&ManageShare, "manage-share", false, "Let admin and uploader sessions delete, rename and move files in the shared directory")
+	rootCmd.Flags().DurationVar(&TrashRetention, "trash-retention", webserver.DefaultTrashRetention, "How long deleted files are kept in the trash before they are purged, 0 keeps them")
+	rootCmd.Flags().Var(&MaxUploadAge, "max-age", "Delete uploads this long after they were stored, e.g. 7d or 12h (default: keep them)")
+	rootCmd.Flags().Var(&MaxUploadsSize, "max-uploads-size", "Delete the oldest uploads while all uploads together are larger, e.g. 20GB (default: no limit)")
+	rootCmd.Flags().Var(&ExpireAfterDownload, "expire-after-download", "Delete uploads this long after their first download, e.g. 24h (default: keep them)")
+	rootCmd.Flags().Var(&QuotaSize, "quota-size", "Refuse uploads once all uploads together take this much space, e.g. 50GB (default: no limit)")
+	rootCmd.Flags().IntVar(&QuotaFiles, "quota-files", 0, "Refuse uploads once the uploads directory holds this many files (default: no limit)")
+	rootCmd.Flags().Var(&SessionQuotaSize, "session-quota-size", "Space the uploads of every session or device may take, e.g. 2GB (default: no limit)")
+	rootCmd.Flags().IntVar(&SessionQuotaFiles, "session-quota-files", 0, "Number of files every session or device may upload (default: no limit)")
+	rootCmd.Flags().BoolVar(&Dedup, "dedup", false, "Store uploads with the same content once and skip re-uploads of files that are already there")
+	rootCmd.Flags().Var(&DownloadRate, "download-rate", "Bandwidth of all downloads together per second, e.g. 10MB (default: no limit)")
+	rootCmd.Flags().Var(&UploadRate, "upload-rate", "Bandwidth of all uploads together per second, e.g. 5MB (default: no limit)")
+	rootCmd.Flags().Var(&ClientDownloadRate, "client-download-rate", "Download bandwidth of every client per second, e.g. 1MB (default: no limit)")
+	rootCmd.Flags().Var(&ClientUploadRate, "client-upload-rate", "Upload bandwidth of every client per second, e.g. 1MB (default: no limit)")
+	rootCmd.Flags().IntVar(&MaxTransfers, "max-transfers", 0, "Number of downloads and uploads running at once, more wait in a queue (default: no limit)")
+	rootCmd.Flags().BoolVar(&SearchContent, "search-content", false, "Index text files like notes, CSV and source code for full-text search")
+	rootCmd.Flags().BoolVar(&WebDAV, "webdav", false, "Serve shared files and uploads over WebDAV under /dav/")
+	rootCmd.Flags().StringVar(&SFTPAddr, "sftp", "", "Address of an SFTP listener serving shared files and uploads, e.g. :2022")
+	rootCmd.Flags().StringVar(&SFTPAuthorizedKeys, "sftp-authorized-keys", "", "authorized_keys file with public keys allowed to log in over SFTP")
+	rootCmd.Flags().StringVar(&SFTPHostKey, "sftp-host-key", "", "Private key file used as SFTP host key (default: temporary key)")
+	rootCmd.Flags().StringVar(&S3Addr, "s3", "", "Address of an S3-compatible API listener serving shared files and uploads, e.g. :9000")
+
+	rootCmd.Flags().BoolVar(&MDNS, "mdns", true, "Advertise the server on the local network over mDNS / DNS-SD")
+	rootCmd.Flags().StringVar(&MDNSName, "mdns-name", "", "Name the server is advertised under (default: based on the hostname)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
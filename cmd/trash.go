@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/piotrszyma/goshare/internal/webserver"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// TrashUploadsDir is the uploads directory whose trash is managed
+	TrashUploadsDir string
+)
+
+// trashCmd represents the trash command
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List, restore and empty deleted uploads",
+	Long: `Files deleted from the web interface are kept in a hidden trash next to
+the uploads directory until they are purged after --trash-retention.
+
+  goshare trash list
+  goshare trash restore <id>
+  goshare trash empty`,
+}
+
+// trashListCmd represents the trash list command
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the items in the trash",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		items, err := webserver.ListTrash(TrashUploadsDir)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			fmt.Println("The trash is empty")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer w.Flush()
+		fmt.Fprintln(w, "ID\tDELETED\tBY\tSIZE\tPATH")
+		for _, item := range items {
+			name := item.Path
+			if item.IsDir {
+				name += "/"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", item.ID, formatModTime(item.DeletedAt), item.DeletedBy, item.Size, name)
+		}
+		return nil
+	},
+}
+
+// trashRestoreCmd represents the trash restore command
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore <id>...",
+	Short: "Move items from the trash back to where they were deleted from",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, id := range args {
+			item, err := webserver.RestoreTrash(TrashUploadsDir, id)
+			if err != nil {
+				return fmt.Errorf("restoring %s: %w", id, err)
+			}
+			fmt.Printf("Restored %s\n", item.HostPath)
+		}
+		return nil
+	},
+}
+
+// trashEmptyCmd represents the trash empty command
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Delete all items in the trash for good",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		purged, err := webserver.EmptyTrash(TrashUploadsDir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d items for good\n", purged)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trashCmd)
+	trashCmd.AddCommand(trashListCmd, trashRestoreCmd, trashEmptyCmd)
+
+	trashCmd.PersistentFlags().StringVar(&TrashUploadsDir, "uploads-dir", "uploads", "Uploads directory whose trash is managed")
+}
@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/piotrszyma/goshare/internal/version"
+
 	"github.com/spf13/cobra"
 )
 
@@ -12,7 +14,7 @@ var versionCmd = &cobra.Command{
 	Short: "Print the version number of GoShare",
 	Long:  `All software has versions. This is GoShare's`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("GoShare v0.1.0 -- HEAD")
+		fmt.Printf("GoShare v%s -- HEAD\n", version.Version)
 	},
 }
 
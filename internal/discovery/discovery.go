@@ -0,0 +1,118 @@
+// Package discovery advertises GoShare servers on the local network with
+// DNS-SD over multicast DNS and finds them again.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// ServiceType is the DNS-SD service type of GoShare servers
+const ServiceType = "_goshare._tcp"
+
+// domain is the multicast DNS domain
+const domain = "local."
+
+// Server is a GoShare server found on the network
+type Server struct {
+	// Name is the advertised instance name
+	Name string
+	// Host is the advertised host name
+	Host string
+	// Port is the HTTP port of the server
+	Port int
+	// Addrs are the IP addresses of the server
+	Addrs []net.IP
+	// TXT holds the key=value pairs of the TXT record
+	TXT map[string]string
+}
+
+// URLs returns an HTTP URL for every address of the server
+func (s Server) URLs() []string {
+	urls := make([]string, 0, len(s.Addrs))
+	for _, addr := range s.Addrs {
+		urls = append(urls, fmt.Sprintf("http://%s/", net.JoinHostPort(addr.String(), fmt.Sprint(s.Port))))
+	}
+	return urls
+}
+
+// Advertise announces a server on the given interfaces, or all of them when
+// none are given, until the returned function is called. The TXT record must
+// never contain secrets, it is readable by everyone on the network.
+func Advertise(name string, port int, txt map[string]string, ifaces []net.Interface) (func(), error) {
+	keys := make([]string, 0, len(txt))
+	for key := range txt {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]string, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, key+"="+txt[key])
+	}
+
+	server, err := zeroconf.Register(name, ServiceType, domain, port, records, ifaces)
+	if err != nil {
+		return nil, err
+	}
+	return server.Shutdown, nil
+}
+
+// Browse collects the servers answering on the network until ctx is done
+func Browse(ctx context.Context) ([]Server, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		servers []Server
+		seen    = map[string]bool{}
+		done    = make(chan struct{})
+		entries = make(chan *zeroconf.ServiceEntry)
+	)
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			mu.Lock()
+			if !seen[entry.Instance] {
+				seen[entry.Instance] = true
+				servers = append(servers, newServer(entry))
+			}
+			mu.Unlock()
+		}
+	}()
+
+	if err := resolver.Browse(ctx, ServiceType, domain, entries); err != nil {
+		return nil, err
+	}
+	<-ctx.Done()
+	<-done
+
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers, nil
+}
+
+// newServer converts a resolved service entry
+func newServer(entry *zeroconf.ServiceEntry) Server {
+	server := Server{
+		Name: entry.Instance,
+		Host: entry.HostName,
+		Port: entry.Port,
+		TXT:  map[string]string{},
+	}
+	server.Addrs = append(server.Addrs, entry.AddrIPv4...)
+	server.Addrs = append(server.Addrs, entry.AddrIPv6...)
+	for _, record := range entry.Text {
+		key, value, _ := strings.Cut(record, "=")
+		server.TXT[key] = value
+	}
+	return server
+}
@@ -0,0 +1,5 @@
+// Package version holds the GoShare release version.
+package version
+
+// Version is the current GoShare version
+const Version = "0.1.0"
@@ -0,0 +1,406 @@
+package webserver
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/piotrszyma/goshare/internal/version"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// apiError is the body of every error returned by the JSON API
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+// apiErrorDetail describes what went wrong
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// mountInfo describes a mount in the server info response
+type mountInfo struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Writable bool   `json:"writable"`
+}
+
+// serverInfo is the response body of GET /api/v1/info
+type serverInfo struct {
+	Version string      `json:"version"`
+	Mounts  []mountInfo `json:"mounts"`
+	// Role is the role of the session making the request
+	Role string `json:"role"`
+	// Quota is how much the session may still upload
+	Quota quotaStatus `json:"quota"`
+}
+
+// moveRequest is the request body of PATCH /api/v1/files/<path>
+type moveRequest struct {
+	// Path is the new virtual path of the item
+	Path string `json:"path"`
+}
+
+// uploadResult is the response body of a successful upload
+type uploadResult struct {
+	Files []listEntry `json:"files"`
+	// Duplicates are the paths of files that were already uploaded with the same content and not stored again
+	Duplicates []string `json:"duplicates,omitempty"`
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes an error in the JSON error envelope
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// requireAPIKey is middleware that checks the key like requireKey
+// but reports failures in the JSON error envelope
+func requireAPIKey(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validateRequestKey(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="goshare"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing key")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// apiHandler serves the versioned JSON API under /api/v1/
+func apiHandler(sharePath, uploadsDir string, search *searcher, fm *fileManager) http.HandlerFunc {
+	mounts := getMounts(sharePath, uploadsDir)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := strings.TrimPrefix(r.URL.Path, "/api/v1")
+		role, _ := requestRole(r)
+
+		switch {
+		case route == "/info":
+			if r.Method != http.MethodGet {
+				writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+				return
+			}
+			handleAPIInfo(w, mounts, role, uploadQuota.status(sessionName(r)))
+
+		case route == "/search":
+			if r.Method != http.MethodGet {
+				writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+				return
+			}
+			handleAPISearch(w, r, search)
+
+		case route == "/files" || strings.HasPrefix(route, "/files/"):
+			p := cleanVirtualPath(strings.TrimPrefix(route, "/files"))
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				handleAPIList(w, r, mounts, p)
+			case http.MethodPost:
+				if !canUpload(role) {
+					writeAPIError(w, http.StatusForbidden, "forbidden", "the "+role+" role cannot upload files")
+					return
+				}
+				handleAPIUpload(w, r, mounts, p)
+			case http.MethodDelete:
+				if checkAPIManage(w, r, fm, role) {
+					handleAPIDelete(w, r, fm, p)
+				}
+			case http.MethodPatch:
+				if checkAPIManage(w, r, fm, role) {
+					handleAPIMove(w, r, fm, p)
+				}
+			default:
+				writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			}
+
+		case route == "/limits":
+			handleAPILimits(w, r, role)
+
+		case strings.HasPrefix(route, "/folders/"):
+			if r.Method != http.MethodPost {
+				writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+				return
+			}
+			if checkAPIManage(w, r, fm, role) {
+				handleAPIMkdir(w, r, fm, cleanVirtualPath(strings.TrimPrefix(route, "/folders")))
+			}
+
+		default:
+			writeAPIError(w, http.StatusNotFound, "not_found", "no such endpoint")
+		}
+	}
+}
+
+// openAPIHandler serves the OpenAPI description of the JSON API
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// handleAPIInfo reports the server version and its mounts
+func handleAPIInfo(w http.ResponseWriter, mounts []mount, role string, quota quotaStatus) {
+	info := serverInfo{Version: version.Version, Mounts: []mountInfo{}, Role: role, Quota: quota}
+	for _, m := range mounts {
+		info.Mounts = append(info.Mounts, mountInfo{Name: m.Name, URL: "/" + m.Name + "/", Writable: m.Writable})
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleAPIList returns the listing of a directory or a single file
+func handleAPIList(w http.ResponseWriter, r *http.Request, mounts []mount, p string) {
+	query := r.URL.Query()
+	opts := listOptions{
+		Checksums: query.Get("checksum") == "sha256",
+		Filter:    query.Get("filter"),
+	}
+	for name, value := range map[string]*int{"offset": &opts.Offset, "limit": &opts.Limit} {
+		if raw := query.Get(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				writeAPIError(w, http.StatusBadRequest, "bad_request", name+" must be a non-negative integer")
+				return
+			}
+			*value = n
+		}
+	}
+
+	result, err := listFiles(mounts, p, opts)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeAPIError(w, http.StatusNotFound, "not_found", "no such file or directory")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// resolveWritable maps a virtual path onto a writable mount
+func resolveWritable(mounts []mount, p string) (mount, string, error) {
+	name, rel := splitVirtualPath(p)
+	m, ok := findMount(mounts, name)
+	if !ok {
+		return mount{}, "", os.ErrNotExist
+	}
+	if !m.Writable {
+		return mount{}, "", os.ErrPermission
+	}
+	hostPath, err := m.resolve(rel)
+	if err != nil {
+		return mount{}, "", os.ErrNotExist
+	}
+	return m, hostPath, nil
+}
+
+// handleAPIUpload stores the files of a multipart form in the directory p
+func handleAPIUpload(w http.ResponseWriter, r *http.Request, mounts []mount, p string) {
+	_, dir, err := resolveWritable(mounts, p)
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		writeAPIError(w, http.StatusConflict, "not_a_directory", "uploads must target a directory")
+		return
+	}
+
+	// Refuse uploads that cannot fit before receiving them
+	if err := uploadQuota.check(sessionName(r), r.ContentLength); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	// Parse multipart form with max memory of 32MB
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", `missing "file" form field`)
+		return
+	}
+
+	expected, err := expectedChecksums(r, len(headers))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	result := uploadResult{Files: []listEntry{}}
+	for i, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		stored, err := saveVerifiedUpload(dir, header.Filename, sessionName(r), expected[i], file)
+		file.Close()
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		info, err := os.Stat(filepath.Join(dir, stored.Name))
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		entry := newListEntry(path.Join(p, stored.Name), info)
+		entry.SHA256 = stored.SHA256
+		result.Files = append(result.Files, entry)
+		if stored.Duplicate {
+			result.Duplicates = append(result.Duplicates, entry.Path)
+		}
+	}
+
+	// Nothing was created if every file was there already
+	status := http.StatusCreated
+	if len(result.Duplicates) == len(result.Files) {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, result)
+}
+
+// checkAPIManage checks that the session may change files and that the request
+// was not forged by another site, reporting an error if not
+func checkAPIManage(w http.ResponseWriter, r *http.Request, fm *fileManager, role string) bool {
+	switch {
+	case fm == nil:
+		writeAPIError(w, http.StatusForbidden, "read_only", "the path is read-only")
+	case !canManage(role):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "the "+role+" role cannot change files")
+	case !validCSRF(r):
+		writeAPIError(w, http.StatusForbidden, "csrf", "missing or invalid X-CSRF-Token header")
+	default:
+		return true
+	}
+	return false
+}
+
+// handleAPIDelete moves a file or directory from a managed mount to the trash
+func handleAPIDelete(w http.ResponseWriter, r *http.Request, fm *fileManager, p string) {
+	if _, err := fm.remove(sessionName(r), p); err != nil {
+		writePathError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIMove renames or moves a file or directory to the path in the request body
+func handleAPIMove(w http.ResponseWriter, r *http.Request, fm *fileManager, p string) {
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", `expected a JSON body like {"path": "uploads/new-name.txt"}`)
+		return
+	}
+
+	op := "move"
+	if path.Dir(cleanVirtualPath(req.Path)) == path.Dir(p) {
+		op = "rename"
+	}
+	entry, err := fm.move(sessionName(r), op, p, req.Path)
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// handleAPIMkdir creates a folder in a managed mount
+func handleAPIMkdir(w http.ResponseWriter, r *http.Request, fm *fileManager, p string) {
+	entry, err := fm.mkdir(sessionName(r), p)
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+// handleAPILimits shows and changes the transfer limits; only admins may use it
+func handleAPILimits(w http.ResponseWriter, r *http.Request, role string) {
+	if role != roleAdmin {
+		writeAPIError(w, http.StatusForbidden, "forbidden", "the "+role+" role cannot manage transfer limits")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, transfers.get())
+	case http.MethodPatch:
+		if !validCSRF(r) {
+			writeAPIError(w, http.StatusForbidden, "csrf", "missing or invalid X-CSRF-Token header")
+			return
+		}
+		// Decoding onto the current limits keeps the ones missing from the body
+		limits := transfers.get().transferLimits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", `expected a JSON body like {"download_rate": 1048576}`)
+			return
+		}
+		if limits.DownloadRate < 0 || limits.UploadRate < 0 || limits.ClientDownloadRate < 0 || limits.ClientUploadRate < 0 || limits.MaxTransfers < 0 {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "limits cannot be negative, use 0 for unlimited")
+			return
+		}
+		transfers.set(limits)
+		log.Printf("Transfer limits changed by %s: %s", sessionName(r), limits)
+		writeJSON(w, http.StatusOK, transfers.get())
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+	}
+}
+
+// writeUploadError reports an error storing an upload, telling quota and disk space problems apart
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch status := uploadErrorStatus(err); status {
+	case http.StatusRequestEntityTooLarge:
+		writeAPIError(w, status, "quota_exceeded", err.Error())
+	case http.StatusInsufficientStorage:
+		writeAPIError(w, status, "insufficient_storage", err.Error())
+	case http.StatusBadRequest:
+		writeAPIError(w, status, "checksum_mismatch", err.Error())
+	default:
+		writeAPIError(w, status, "internal", err.Error())
+	}
+}
+
+// writePathError maps filesystem errors onto API errors
+func writePathError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		writeAPIError(w, http.StatusNotFound, "not_found", "no such file or directory")
+	case errors.Is(err, os.ErrPermission):
+		writeAPIError(w, http.StatusForbidden, "read_only", "the path is read-only")
+	case errors.Is(err, os.ErrExist):
+		writeAPIError(w, http.StatusConflict, "exists", "a file or folder with that name already exists")
+	case errors.Is(err, errBusy):
+		writeAPIError(w, http.StatusConflict, "busy", err.Error())
+	case errors.Is(err, errMountRoot), errors.Is(err, errOutsideMount):
+		writeAPIError(w, http.StatusForbidden, "forbidden", err.Error())
+	case errors.Is(err, errInvalidName):
+		writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
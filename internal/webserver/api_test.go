@@ -0,0 +1,133 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serveAPI sends the request through a new mux with the key in a bearer token
+func serveAPI(mux *http.ServeMux, req *http.Request) *httptest.ResponseRecorder {
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestAPIRequiresKey tests that the API rejects requests without a key using the error envelope
+func TestAPIRequiresKey(t *testing.T) {
+	mux := newMux(Config{UploadsDir: t.TempDir()})
+
+	req := httptest.NewRequest("GET", "/api/v1/files/", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	var body apiError
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != "unauthorized" {
+		t.Errorf("Expected error code unauthorized, got %q", body.Error.Code)
+	}
+
+	// The OpenAPI document is public
+	req = httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !json.Valid(rr.Body.Bytes()) {
+		t.Errorf("Expected a valid OpenAPI document, got status code %d", rr.Code)
+	}
+}
+
+// TestAPIInfo tests the server info endpoint
+func TestAPIInfo(t *testing.T) {
+	mux := newMux(Config{SharePath: t.TempDir(), UploadsDir: t.TempDir()})
+
+	rr := serveAPI(mux, httptest.NewRequest("GET", "/api/v1/info", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var info serverInfo
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Mounts) != 2 || info.Mounts[0].Writable || !info.Mounts[1].Writable {
+		t.Errorf("Unexpected mounts: %+v", info.Mounts)
+	}
+}
+
+// TestAPIUploadListDelete tests uploading, listing and deleting a file
+func TestAPIUploadListDelete(t *testing.T) {
+	uploadsDir := t.TempDir()
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	// Upload a file
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("some notes"))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/files/uploads", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := serveAPI(mux, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	var uploaded uploadResult
+	if err := json.NewDecoder(rr.Body).Decode(&uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded.Files) != 1 || uploaded.Files[0].Path != "uploads/notes.txt" || uploaded.Files[0].MimeType != "text/plain; charset=utf-8" {
+		t.Fatalf("Unexpected upload result: %+v", uploaded)
+	}
+
+	// List the uploads directory
+	rr = serveAPI(mux, httptest.NewRequest("GET", "/api/v1/files/uploads", nil))
+	var result listing
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Size != 10 {
+		t.Errorf("Unexpected listing: %+v", result)
+	}
+
+	// Delete the file
+	rr = serveAPI(mux, httptest.NewRequest("DELETE", "/api/v1/files/uploads/notes.txt", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "notes.txt")); !os.IsNotExist(err) {
+		t.Error("Expected the file to be deleted")
+	}
+}
+
+// TestAPIDeleteReadOnly tests that shared files cannot be deleted
+func TestAPIDeleteReadOnly(t *testing.T) {
+	sharedDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sharedDir, "keep.txt"), []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mux := newMux(Config{SharePath: sharedDir, UploadsDir: t.TempDir()})
+
+	rr := serveAPI(mux, httptest.NewRequest("DELETE", "/api/v1/files/shared/keep.txt", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(sharedDir, "keep.txt")); err != nil {
+		t.Error("Expected the shared file to be kept")
+	}
+}
@@ -0,0 +1,59 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditEntry records a change made to the files by a session
+type auditEntry struct {
+	Time time.Time `json:"time"`
+	// Session is the role and address of the client, see sessionName
+	Session string `json:"session"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	To      string `json:"to,omitempty"`
+	// TrashID is set for deleted items
+	TrashID string `json:"trash_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// auditLog appends audit entries as JSON lines to a file
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// newAuditLog returns the audit log of an uploads directory
+func newAuditLog(uploadsDir string) *auditLog {
+	return &auditLog{path: filepath.Join(stateDir(uploadsDir), "audit.log")}
+}
+
+// record writes an entry to the audit log and the server log
+func (a *auditLog) record(entry auditEntry) {
+	entry.Time = time.Now().UTC()
+	if entry.Error != "" {
+		log.Printf("audit: %s %s %s %s failed: %s", entry.Session, entry.Op, entry.Path, entry.To, entry.Error)
+	} else {
+		log.Printf("audit: %s %s %s %s", entry.Session, entry.Op, entry.Path, entry.To)
+	}
+
+	line, _ := json.Marshal(entry)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o700); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Error writing audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
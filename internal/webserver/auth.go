@@ -1,14 +1,33 @@
 package webserver
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 )
 
 var secretKey string
 
+// Roles of sessions. The server key grants the admin role; the keys of the
+// other roles are derived from it and printed at startup.
+const (
+	// roleAdmin may do everything, including managing files
+	roleAdmin = "admin"
+	// roleUploader may upload and manage files
+	roleUploader = "uploader"
+	// roleViewer may only list and download files
+	roleViewer = "viewer"
+)
+
+// roles lists all roles from the most to the least powerful
+var roles = []string{roleAdmin, roleUploader, roleViewer}
+
 func init() {
 	// Generate a random secret key at startup
 	bytes := make([]byte, 16)
@@ -18,6 +37,36 @@ func init() {
 	secretKey = hex.EncodeToString(bytes)
 }
 
+// roleKey returns the key granting a role
+func roleKey(role string) string {
+	if role == roleAdmin {
+		return secretKey
+	}
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte("role:" + role))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// keyRole returns the role granted by a key
+func keyRole(key string) (string, bool) {
+	for _, role := range roles {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(roleKey(role))) == 1 {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+// canUpload reports whether a role may store files
+func canUpload(role string) bool {
+	return role == roleAdmin || role == roleUploader
+}
+
+// canManage reports whether a role may delete, rename and move files and create folders
+func canManage(role string) bool {
+	return role == roleAdmin || role == roleUploader
+}
+
 // validateKey checks if the request has a valid key parameter
 func validateKey(r *http.Request) bool {
 	keys, ok := r.URL.Query()["key"]
@@ -27,26 +76,118 @@ func validateKey(r *http.Request) bool {
 	if !ok || len(keys) == 0 {
 		return false
 	}
-	return keys[0] == secretKey
+	_, valid := keyRole(keys[0])
+	return valid
 }
 
 // validateKeyCookie checks if the request has a valid key cookie
 func validateKeyCookie(r *http.Request) bool {
+	_, ok := cookieRole(r)
+	return ok
+}
+
+// cookieRole returns the role granted by the key cookie
+func cookieRole(r *http.Request) (string, bool) {
 	cookie, err := r.Cookie("key")
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	log.Printf("request with key cookie = %s", cookie.Value)
 
-	return cookie.Value == secretKey
+	return keyRole(cookie.Value)
+}
+
+// credentialsRole returns the role granted by a key in an Authorization: Bearer
+// header or as the HTTP Basic auth password. The Basic auth user name is
+// ignored, so both "curl -u :key" and "curl -u goshare:key" work.
+func credentialsRole(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return keyRole(strings.TrimSpace(token))
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return keyRole(password)
+	}
+	return "", false
+}
+
+// requestRole returns the role of the session making the request, taken from
+// its key cookie, bearer token or HTTP Basic auth credentials
+func requestRole(r *http.Request) (string, bool) {
+	if role, ok := cookieRole(r); ok {
+		return role, true
+	}
+	return credentialsRole(r)
+}
+
+// validateRequestKey checks if the request carries a key in a cookie,
+// a bearer token or HTTP Basic auth credentials
+func validateRequestKey(r *http.Request) bool {
+	_, ok := requestRole(r)
+	return ok
+}
+
+// sessionName identifies the session making a request in logs, e.g. "uploader@192.168.1.20"
+func sessionName(r *http.Request) string {
+	role, _ := requestRole(r)
+	return role + "@" + clientHost(r)
+}
+
+// clientHost returns the address of the client without the port
+func clientHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// csrfToken returns the token forms must send back to change files. It is
+// derived from the session key, which other sites cannot read.
+func csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie("key")
+	if err != nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(cookie.Value))
+	mac.Write([]byte("csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRF checks that a request changing files was not forged by another
+// site. Requests authenticated with a bearer token or Basic auth cannot be
+// forged; cookie sessions must send the token in the csrf form field or the
+// X-CSRF-Token header.
+func validCSRF(r *http.Request) bool {
+	if _, ok := credentialsRole(r); ok {
+		return true
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.PostFormValue("csrf")
+	}
+	expected := csrfToken(r)
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// requireRole is middleware that only lets sessions whose role is allowed through.
+// It must run after requireKey.
+func requireRole(allowed func(string) bool, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := requestRole(r); !allowed(role) {
+			http.Error(w, "Forbidden: the "+role+" role is not allowed to do this", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
 }
 
-// requireKey is middleware that checks for a valid key cookie
+// requireKey is middleware that checks for a valid key cookie, bearer token or Basic auth password
 func requireKey(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !validateKeyCookie(r) {
-			http.Error(w, "Unauthorized: invalid or missing key cookie", http.StatusUnauthorized)
+		if !validateRequestKey(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goshare"`)
+			http.Error(w, "Unauthorized: invalid or missing key", http.StatusUnauthorized)
 			return
 		}
 		handler(w, r)
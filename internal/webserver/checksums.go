@@ -0,0 +1,291 @@
+package webserver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sumsFileName is the name of the generated checksum file of every directory
+	sumsFileName = "SHA256SUMS"
+	// checksumHeader carries the hex encoded SHA-256 digest of uploads and downloads
+	checksumHeader = "X-Checksum-Sha256"
+	// checksumSaveDelay collects checksums computed in a row into a single write
+	checksumSaveDelay = time.Second
+)
+
+// errChecksumMismatch is returned for uploads that do not match the checksum the client expected
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// checksums remembers the SHA-256 digests of files, so they are only computed once
+var checksums = newChecksumStore("")
+
+// checksumEntry is the digest of a file with the size and modification time it was computed for
+type checksumEntry struct {
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// checksumStore caches the digests of files by their absolute host path. A
+// digest is only used while the file keeps its size and modification time.
+type checksumStore struct {
+	// path is the file the digests are kept in, empty to keep them in memory only
+	path string
+
+	mu      sync.Mutex
+	entries map[string]checksumEntry
+	// pending are the files being hashed in the background
+	pending map[string]bool
+	saving  bool
+}
+
+// newChecksumStore returns a store kept in the file at p, loading the digests saved there
+func newChecksumStore(p string) *checksumStore {
+	s := &checksumStore{path: p, entries: map[string]checksumEntry{}, pending: map[string]bool{}}
+	if p == "" {
+		return s
+	}
+	if data, err := os.ReadFile(p); err == nil {
+		if err := json.Unmarshal(data, &s.entries); err != nil {
+			log.Printf("Error reading %s: %v", p, err)
+		}
+	}
+	return s
+}
+
+// checksumKey returns the key of the file at hostPath in the store
+func checksumKey(hostPath string) string {
+	abs, err := filepath.Abs(hostPath)
+	if err != nil {
+		return hostPath
+	}
+	return abs
+}
+
+// lookup returns the known digest of the file at hostPath, or "" if it has to be computed
+func (s *checksumStore) lookup(hostPath string, info os.FileInfo) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[checksumKey(hostPath)]
+	if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return ""
+	}
+	return entry.SHA256
+}
+
+// record remembers sum as the digest of the file at hostPath in its current state
+func (s *checksumStore) record(hostPath, sum string) {
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[checksumKey(hostPath)] = checksumEntry{SHA256: sum, Size: info.Size(), ModTime: info.ModTime()}
+	if s.path != "" && !s.saving {
+		s.saving = true
+		time.AfterFunc(checksumSaveDelay, s.save)
+	}
+}
+
+// sum returns the digest of the file at hostPath, computing it if it is not known
+func (s *checksumStore) sum(hostPath string) (string, error) {
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return "", err
+	}
+	if sum := s.lookup(hostPath, info); sum != "" {
+		return sum, nil
+	}
+	sum, err := fileSHA256(hostPath)
+	if err != nil {
+		return "", err
+	}
+	s.record(hostPath, sum)
+	return sum, nil
+}
+
+// warm computes the digest of the file at hostPath in the background, so it is
+// known for the next request without holding up the current one
+func (s *checksumStore) warm(hostPath string) {
+	key := checksumKey(hostPath)
+	s.mu.Lock()
+	if s.pending[key] {
+		s.mu.Unlock()
+		return
+	}
+	s.pending[key] = true
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.pending, key)
+			s.mu.Unlock()
+		}()
+		// The file may have been removed in the meantime
+		if _, err := s.sum(hostPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error computing checksum of %s: %v", hostPath, err)
+		}
+	}()
+}
+
+// save writes the digests of existing files to the store file, forgetting removed files
+func (s *checksumStore) save() {
+	s.mu.Lock()
+	s.saving = false
+	for key := range s.entries {
+		if _, err := os.Stat(key); os.IsNotExist(err) {
+			delete(s.entries, key)
+		}
+	}
+	data, err := json.Marshal(s.entries)
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Error saving checksums: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		log.Printf("Error saving checksums: %v", err)
+		return
+	}
+	if err := os.WriteFile(s.path, data, 0o600); err != nil {
+		log.Printf("Error saving checksums: %v", err)
+	}
+}
+
+// flush saves the checksums that are waiting for the delayed save right away, e.g. before the server exits
+func (s *checksumStore) flush() {
+	s.mu.Lock()
+	pending := s.saving
+	s.mu.Unlock()
+	if pending {
+		s.save()
+	}
+}
+
+// parseChecksum normalizes a hex encoded SHA-256 digest sent by a client; an empty string is no checksum
+func parseChecksum(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "", nil
+	}
+	if b, err := hex.DecodeString(s); err != nil || len(b) != 32 {
+		return "", fmt.Errorf("invalid SHA-256 checksum %q, expected 64 hex digits", s)
+	}
+	return s, nil
+}
+
+// setChecksumHeaders announces the digest of a download
+func setChecksumHeaders(h http.Header, sum string) {
+	h.Set(checksumHeader, sum)
+	// Repr-Digest (RFC 9530) is the standard form, base64 between colons
+	if b, err := hex.DecodeString(sum); err == nil {
+		h.Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(b)+":")
+	}
+}
+
+// checksumHandler adds the checksum headers to downloads of files in the mounts
+// and serves a generated SHA256SUMS file in every directory
+func checksumHandler(mounts []mount, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		p := cleanVirtualPath(r.URL.Path)
+		hostPath, err := resolveVirtualFile(mounts, p)
+		var info os.FileInfo
+		if err == nil {
+			info, err = os.Stat(hostPath)
+		}
+		switch {
+		case err == nil && info.Mode().IsRegular() && !isPartialFile(hostPath):
+			if sum := checksums.lookup(hostPath, info); sum != "" {
+				setChecksumHeaders(w.Header(), sum)
+			} else {
+				// Hashing a large file first would hold up the download
+				checksums.warm(hostPath)
+			}
+		case err != nil && path.Base(p) == sumsFileName:
+			// A real file of that name wins over the generated one
+			serveSHA256SUMS(w, r, mounts, path.Dir(p))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// serveSHA256SUMS serves the digests of the files in the directory p in the
+// format of sha256sum, so downloads can be checked with sha256sum -c
+func serveSHA256SUMS(w http.ResponseWriter, r *http.Request, mounts []mount, p string) {
+	result, err := listAll(mounts, p)
+	if err != nil || !result.IsDir {
+		http.NotFound(w, r)
+		return
+	}
+
+	var sums strings.Builder
+	for _, entry := range result.Entries {
+		if entry.IsDir {
+			continue
+		}
+		hostPath, err := resolveVirtualFile(mounts, entry.Path)
+		if err != nil || isPartialFile(hostPath) {
+			continue
+		}
+		sum, err := checksums.sum(hostPath)
+		if err != nil {
+			http.Error(w, "Error computing checksums: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, entry.Name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(sums.Len()))
+	if r.Method == http.MethodGet {
+		fmt.Fprint(w, sums.String())
+	}
+}
+
+// expectedChecksums returns the checksums the client expects for the n files of
+// an upload, "" where it sent none. A single file may use the X-Checksum-Sha256
+// header, multipart forms send a sha256 field for every file field, in order.
+func expectedChecksums(r *http.Request, n int) ([]string, error) {
+	expected := make([]string, n)
+	var values []string
+	if header := r.Header.Get(checksumHeader); header != "" {
+		if n != 1 {
+			return nil, fmt.Errorf("the %s header only works for a single file, send a sha256 field per file instead", checksumHeader)
+		}
+		values = []string{header}
+	} else if r.MultipartForm != nil {
+		values = r.MultipartForm.Value["sha256"]
+		if len(values) > 0 && len(values) != n {
+			return nil, fmt.Errorf("got %d sha256 fields for %s", len(values), countFiles(n))
+		}
+	}
+	for i, value := range values {
+		sum, err := parseChecksum(value)
+		if err != nil {
+			return nil, err
+		}
+		expected[i] = sum
+	}
+	return expected, nil
+}
@@ -0,0 +1,145 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helloSHA256 is the SHA-256 digest of "hello"
+const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+// TestPutUploadChecksum tests that uploads not matching the expected checksum are rejected
+func TestPutUploadChecksum(t *testing.T) {
+	uploadsDir := t.TempDir()
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	req := httptest.NewRequest("PUT", "/uploads/hello.txt", strings.NewReader("hellO"))
+	req.Header.Set(checksumHeader, helloSHA256)
+	rr := serveAPI(mux, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "checksum mismatch") {
+		t.Errorf("Expected status code %d with a mismatch, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "hello.txt")); !os.IsNotExist(err) {
+		t.Error("Expected the corrupted upload to be removed")
+	}
+
+	req = httptest.NewRequest("PUT", "/uploads/hello.txt", strings.NewReader("hello"))
+	req.Header.Set(checksumHeader, strings.ToUpper(helloSHA256))
+	if rr := serveAPI(mux, req); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// The checksum of the upload is listed without computing it again
+	rr = serveAPI(mux, httptest.NewRequest("GET", "/api/v1/files/uploads", nil))
+	var result listing
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].SHA256 != helloSHA256 {
+		t.Errorf("Expected the checksum in the listing, got %+v", result.Entries)
+	}
+
+	// Downloads announce it in a header
+	rr = serveAPI(mux, httptest.NewRequest("GET", "/uploads/hello.txt", nil))
+	if got := rr.Header().Get(checksumHeader); got != helloSHA256 {
+		t.Errorf("Expected checksum header %s, got %q", helloSHA256, got)
+	}
+	if got := rr.Header().Get("Repr-Digest"); got != "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:" {
+		t.Errorf("Unexpected Repr-Digest header %q", got)
+	}
+}
+
+// TestAPIUploadChecksumFields tests that every file of a multipart upload is checked against its sha256 field
+func TestAPIUploadChecksumFields(t *testing.T) {
+	mux := newMux(Config{UploadsDir: t.TempDir()})
+
+	upload := func(sums ...string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, sum := range sums {
+			mw.WriteField("sha256", sum)
+		}
+		for _, name := range []string{"a.txt", "b.txt"} {
+			part, _ := mw.CreateFormFile("file", name)
+			part.Write([]byte("hello"))
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/v1/files/uploads", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return serveAPI(mux, req)
+	}
+
+	if rr := upload(helloSHA256); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a missing checksum, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := upload(helloSHA256, "not-a-checksum"); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "64 hex digits") {
+		t.Errorf("Expected status code %d for an invalid checksum, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	if rr := upload(helloSHA256, strings.Repeat("0", 64)); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "checksum_mismatch") {
+		t.Errorf("Expected status code %d with checksum_mismatch, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	if rr := upload(helloSHA256, helloSHA256); rr.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+}
+
+// TestSHA256SUMS tests the generated checksum file of a directory
+func TestSHA256SUMS(t *testing.T) {
+	sharedDir := t.TempDir()
+	os.WriteFile(filepath.Join(sharedDir, "hello.txt"), []byte("hello"), 0o644)
+	os.WriteFile(filepath.Join(sharedDir, "empty.txt"), nil, 0o644)
+	os.Mkdir(filepath.Join(sharedDir, "docs"), 0o755)
+	mux := newMux(Config{SharePath: sharedDir, UploadsDir: t.TempDir()})
+
+	rr := serveAPI(mux, httptest.NewRequest("GET", "/shared/SHA256SUMS", nil))
+	expected := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  empty.txt\n" + helloSHA256 + "  hello.txt\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != expected {
+		t.Errorf("Expected status code %d with\n%s, got %d:\n%s", http.StatusOK, expected, rr.Code, rr.Body.String())
+	}
+
+	// Empty directories have an empty checksum file
+	if rr := serveAPI(mux, httptest.NewRequest("GET", "/shared/docs/SHA256SUMS", nil)); rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Errorf("Expected an empty checksum file, got %d: %q", rr.Code, rr.Body.String())
+	}
+
+	// A real file of that name is served as it is
+	os.WriteFile(filepath.Join(sharedDir, "docs", "SHA256SUMS"), []byte("mine"), 0o644)
+	if rr := serveAPI(mux, httptest.NewRequest("GET", "/shared/docs/SHA256SUMS", nil)); rr.Body.String() != "mine" {
+		t.Errorf("Expected the real SHA256SUMS file, got %q", rr.Body.String())
+	}
+}
+
+// TestChecksumStore tests that checksums survive restarts and are forgotten once the file changes
+func TestChecksumStore(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "hello.txt")
+	os.WriteFile(file, []byte("hello"), 0o644)
+	storePath := filepath.Join(dir, "state", "checksums.json")
+
+	s := newChecksumStore(storePath)
+	if sum, err := s.sum(file); err != nil || sum != helloSHA256 {
+		t.Fatalf("Expected %s, got %s (%v)", helloSHA256, sum, err)
+	}
+	s.save()
+
+	restarted := newChecksumStore(storePath)
+	info, _ := os.Stat(file)
+	if sum := restarted.lookup(file, info); sum != helloSHA256 {
+		t.Errorf("Expected the checksum after a restart, got %q", sum)
+	}
+
+	os.WriteFile(file, []byte("changed"), 0o644)
+	os.Chtimes(file, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	info, _ = os.Stat(file)
+	if sum := restarted.lookup(file, info); sum != "" {
+		t.Errorf("Expected no checksum for a changed file, got %q", sum)
+	}
+}
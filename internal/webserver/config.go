@@ -0,0 +1,79 @@
+package webserver
+
+import "time"
+
+// Config holds the settings of the file sharing server
+type Config struct {
+	// SharePath is the file or directory shared read-only, if any; StdinSharePath streams stdin
+	SharePath string
+	// ShareName is the download name of a stream shared from stdin
+	ShareName string
+	// Follow is a growing file served live under /live/ like tail -f
+	Follow string
+	// Exec is a command that is run and whose output is served live under /live/
+	Exec []string
+	// ReceiveStdout writes the next upload to stdout instead of the uploads directory and exits
+	ReceiveStdout bool
+	// UploadsDir is the directory uploaded files are stored in
+	UploadsDir string
+	// Bind is the IP address to listen on; empty listens on all addresses
+	Bind string
+	// Interface restricts the server to the addresses of one network interface
+	Interface string
+	// Port is the TCP port to listen on; 0 picks a random available port
+	Port int
+	// PortRange is a range of ports like "8000-8100"; the first free one is used
+	PortRange string
+	// UnixSocket is the path of a Unix domain socket to listen on instead of TCP
+	UnixSocket string
+	// ShutdownTimeout is how long a shutdown waits for running transfers; 0 uses DefaultShutdownTimeout
+	ShutdownTimeout time.Duration
+	// Once stops the server after every shared file has been downloaded once
+	Once bool
+	// ReceiveOnce stops the server after the first finished upload
+	ReceiveOnce bool
+	// Timeout stops the server with ErrInactivityTimeout after this long without requests; 0 disables it
+	Timeout time.Duration
+	// ManageShare lets admin and uploader sessions delete, rename and move files in the shared directory
+	ManageShare bool
+	// TrashRetention is how long deleted items are kept in the trash before they are purged; 0 keeps them until the trash is emptied
+	TrashRetention time.Duration
+	// MaxUploadAge removes uploads this long after they were stored; 0 keeps them
+	MaxUploadAge time.Duration
+	// MaxUploadsSize evicts the oldest uploads while all uploads together take more bytes; 0 disables it
+	MaxUploadsSize int64
+	// ExpireAfterDownload removes uploads this long after their first download; 0 disables it
+	ExpireAfterDownload time.Duration
+	// QuotaBytes and QuotaFiles limit all uploads together; 0 means unlimited
+	QuotaBytes int64
+	QuotaFiles int
+	// SessionQuotaBytes and SessionQuotaFiles limit the uploads of every session; 0 means unlimited
+	SessionQuotaBytes int64
+	SessionQuotaFiles int
+	// Dedup stores uploads with the same content once, linking them into the uploads directory
+	Dedup bool
+	// DownloadRate and UploadRate limit all transfers together in bytes per second; 0 means unlimited
+	DownloadRate int64
+	UploadRate   int64
+	// ClientDownloadRate and ClientUploadRate limit the transfers of every client in bytes per second; 0 means unlimited
+	ClientDownloadRate int64
+	ClientUploadRate   int64
+	// MaxTransfers is the number of downloads and uploads running at once, more wait in a queue; 0 means unlimited
+	MaxTransfers int
+	// SearchContent indexes text files for full-text search in addition to searching names
+	SearchContent bool
+	// WebDAV enables the WebDAV endpoint under /dav/
+	WebDAV bool
+	// SFTPAddr is the address of the SFTP listener, e.g. ":2022"; empty disables SFTP
+	SFTPAddr string
+	// SFTPAuthorizedKeys is an authorized_keys file with public keys allowed to log in over SFTP
+	SFTPAuthorizedKeys string
+	// SFTPHostKey is a private key file used as SSH host key; a temporary key is generated when empty
+	SFTPHostKey string
+	// S3Addr is the address of the S3-compatible API listener, e.g. ":9000"; empty disables it
+	S3Addr string
+	// MDNS advertises the server on the local network over mDNS / DNS-SD
+	MDNS bool
+	// MDNSName is the advertised instance name; defaults to one based on the hostname
+	MDNSName string
+}
@@ -0,0 +1,329 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// blobCollectInterval is how often blobs no longer linked from the uploads directory are removed
+const blobCollectInterval = time.Hour
+
+// uploadBlobs stores uploads by their content when deduplication is enabled, nil otherwise
+var uploadBlobs *blobStore
+
+// blobStore keeps every distinct upload once, named after its SHA-256 digest.
+// The files in the uploads directory are hard links to the blobs, so everything
+// else keeps working on plain files, and a blob is removed once its last link
+// in the uploads directory and the trash is gone.
+type blobStore struct {
+	dir        string
+	uploadsDir string
+	// mu keeps the collector from removing a blob that is about to be linked
+	mu sync.Mutex
+	// stored maps the virtual paths of uploads to when they were stored. All
+	// links of a blob share its modification time, which is when the content
+	// was uploaded first, so retention needs this for later uploads.
+	stored     map[string]time.Time
+	storedPath string
+}
+
+// newBlobStore opens the blob store of the uploads directory, making sure
+// files can be hard linked from there into the uploads directory
+func newBlobStore(uploadsDir string) (*blobStore, error) {
+	b := &blobStore{
+		dir:        filepath.Join(stateDir(uploadsDir), "blobs"),
+		uploadsDir: uploadsDir,
+		stored:     map[string]time.Time{},
+		storedPath: filepath.Join(stateDir(uploadsDir), "stored.json"),
+	}
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(uploadsDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	probe, err := os.CreateTemp(b.dir, "probe-*")
+	if err != nil {
+		return nil, err
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+	link := filepath.Join(uploadsDir, filepath.Base(probe.Name()))
+	if err := os.Link(probe.Name(), link); err != nil {
+		return nil, fmt.Errorf("uploads cannot be hard linked from %s, it must be on the same filesystem as the uploads directory: %w", b.dir, err)
+	}
+	defer os.Remove(link)
+
+	// Without link counts shared files cannot be told apart, so they would be
+	// changed in place together and unused blobs would never be removed
+	info, err := os.Stat(link)
+	if err != nil {
+		return nil, err
+	}
+	if links, ok := linkCount(info); !ok || links != 2 {
+		return nil, fmt.Errorf("the number of hard links of a file cannot be read on this platform")
+	}
+
+	if data, err := os.ReadFile(b.storedPath); err == nil {
+		if err := json.Unmarshal(data, &b.stored); err != nil {
+			log.Printf("Error reading %s: %v", b.storedPath, err)
+		}
+	}
+	return b, nil
+}
+
+// virtualPath returns the virtual path of a file in the uploads directory
+func (b *blobStore) virtualPath(hostPath string) string {
+	root, _ := filepath.Abs(b.uploadsDir)
+	abs, _ := filepath.Abs(hostPath)
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return ""
+	}
+	return path.Join("uploads", filepath.ToSlash(rel))
+}
+
+// storedAt returns when the upload at the virtual path p was stored, if it is known
+func (b *blobStore) storedAt(p string) (time.Time, bool) {
+	if b == nil {
+		return time.Time{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.stored[p]
+	return t, ok
+}
+
+// moved keeps the storage times of the uploads at or below the virtual path
+// from, which was renamed to to
+func (b *blobStore) moved(from, to string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	changed := false
+	for p, t := range b.stored {
+		if rest, ok := strings.CutPrefix(p, from); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+			delete(b.stored, p)
+			b.stored[to+rest] = t
+			changed = true
+		}
+	}
+	if changed {
+		b.saveLocked()
+	}
+}
+
+// saveLocked writes the storage times to the state directory; b.mu must be held
+func (b *blobStore) saveLocked() {
+	data, _ := json.Marshal(b.stored)
+	if err := os.WriteFile(b.storedPath, data, 0o600); err != nil {
+		log.Printf("Error saving upload times: %v", err)
+	}
+}
+
+// blobPath returns where the content with the given digest is stored
+func (b *blobStore) blobPath(sum string) string {
+	return filepath.Join(b.dir, sum[:2], sum)
+}
+
+// create returns a new file in the store to write an upload into before its digest is known
+func (b *blobStore) create() (*os.File, error) {
+	return os.CreateTemp(b.dir, "upload-*")
+}
+
+// add stores the upload written to tmpPath as the blob of sum, unless the same
+// content is stored already, and links it into dir under a unique variant of
+// filename. If dir already holds the same content nothing is linked, and the
+// name of the existing file is returned with duplicate set.
+func (b *blobStore) add(tmpPath, sum, dir, filename string) (name string, duplicate bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	blobPath := b.blobPath(sum)
+	if _, err := os.Stat(blobPath); err == nil {
+		// Stored before, the new copy is not needed
+		os.Remove(tmpPath)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(blobPath), 0o700); err != nil {
+			return "", false, err
+		}
+		if err := os.Rename(tmpPath, blobPath); err != nil {
+			return "", false, err
+		}
+		// Temporary files are private, uploads are not
+		os.Chmod(blobPath, 0o644)
+	}
+	blob, err := os.Stat(blobPath)
+	if err != nil {
+		return "", false, err
+	}
+
+	// A re-upload counts as stored again, so it is kept as long as a new one
+	defer func() {
+		if err == nil {
+			b.stored[b.virtualPath(filepath.Join(dir, name))] = time.Now().UTC()
+			b.saveLocked()
+		}
+	}()
+	if name, ok := findLink(dir, blob); ok {
+		return name, true, nil
+	}
+	for {
+		name = getUniqueFilename(dir, filename)
+		err = os.Link(blobPath, filepath.Join(dir, name))
+		// Another upload may have taken the name in the meantime
+		if !os.IsExist(err) {
+			return name, false, err
+		}
+	}
+}
+
+// findLink returns the name of a file in dir that is a link to blob
+func findLink(dir string, blob os.FileInfo) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err == nil && info.Size() == blob.Size() && os.SameFile(info, blob) {
+			return entry.Name(), true
+		}
+	}
+	return "", false
+}
+
+// collect removes the blobs that are not linked from anywhere else anymore
+// and returns how many it removed
+func (b *blobStore) collect() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Forget the storage times of uploads that are gone
+	pruned := false
+	for p := range b.stored {
+		rel, _ := strings.CutPrefix(p, "uploads/")
+		if _, err := os.Lstat(filepath.Join(b.uploadsDir, filepath.FromSlash(rel))); os.IsNotExist(err) {
+			delete(b.stored, p)
+			pruned = true
+		}
+	}
+	if pruned {
+		b.saveLocked()
+	}
+
+	removed := 0
+	filepath.WalkDir(b.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		// Leftovers of interrupted uploads are only removed once they are surely abandoned
+		if strings.HasPrefix(d.Name(), "upload-") || strings.HasPrefix(d.Name(), "probe-") {
+			if time.Since(info.ModTime()) > 24*time.Hour && !isPartialFile(p) {
+				os.Remove(p)
+			}
+			return nil
+		}
+		if links, ok := linkCount(info); ok && links <= 1 {
+			if err := os.Remove(p); err == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed
+}
+
+// stats returns the number of stored blobs and the bytes saved by linking them more than once
+func (b *blobStore) stats() (blobs int, saved int64) {
+	filepath.WalkDir(b.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || len(d.Name()) != 64 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		blobs++
+		// One link is the blob itself, one the first upload
+		if links, ok := linkCount(info); ok && links > 2 {
+			saved += info.Size() * int64(links-2)
+		}
+		return nil
+	})
+	return blobs, saved
+}
+
+// runBlobCollector removes unlinked blobs periodically until stop is closed
+func runBlobCollector(b *blobStore, stop <-chan struct{}) {
+	ticker := time.NewTicker(blobCollectInterval)
+	defer ticker.Stop()
+	for {
+		if removed := b.collect(); removed > 0 {
+			log.Printf("Deduplication: removed %d unused blob(s)", removed)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// unshare gives the deduplicated file at hostPath its own copy of the content
+// before it is changed in place, so its twins keep their content
+func (b *blobStore) unshare(hostPath string, truncate bool) error {
+	if b == nil {
+		return nil
+	}
+	info, err := os.Stat(hostPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	if links, ok := linkCount(info); !ok || links <= 1 {
+		return nil
+	}
+	if truncate {
+		// The content is replaced anyway
+		return os.Remove(hostPath)
+	}
+
+	src, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(hostPath), ".goshare-copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), info.Mode().Perm())
+	return os.Rename(tmp.Name(), hostPath)
+}
@@ -0,0 +1,187 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestDedupReupload tests that identical uploads are stored once and re-uploads are detected
+func TestDedupReupload(t *testing.T) {
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	blobs, err := newBlobStore(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadBlobs = blobs
+	defer func() { uploadBlobs = nil }()
+
+	if _, err := saveUpload(uploadsDir, "IMG_1234.jpg", "admin@test", strings.NewReader("photo")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same photo from another phone is reported instead of stored as IMG_1234.0.jpg
+	stored, err := saveUpload(uploadsDir, "IMG_1234.jpg", "admin@test", strings.NewReader("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Duplicate || stored.Name != "IMG_1234.jpg" {
+		t.Errorf("Expected a duplicate of IMG_1234.jpg, got %+v", stored)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "IMG_1234.0.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected no second copy of the re-upload")
+	}
+
+	// Another folder gets its own name for the same content, without another copy
+	albumDir := filepath.Join(uploadsDir, "album")
+	if stored, err := saveUpload(albumDir, "holiday.jpg", "admin@test", strings.NewReader("photo")); err != nil || stored.Duplicate {
+		t.Fatalf("Expected a new link in another folder, got %+v (%v)", stored, err)
+	}
+	first, _ := os.Stat(filepath.Join(uploadsDir, "IMG_1234.jpg"))
+	second, _ := os.Stat(filepath.Join(albumDir, "holiday.jpg"))
+	if !os.SameFile(first, second) {
+		t.Error("Expected both names to share the stored content")
+	}
+
+	// Different content under the same name still gets a unique name
+	if stored, err := saveUpload(uploadsDir, "IMG_1234.jpg", "admin@test", strings.NewReader("another photo")); err != nil || stored.Name != "IMG_1234.0.jpg" {
+		t.Errorf("Expected IMG_1234.0.jpg, got %+v (%v)", stored, err)
+	}
+	if count, _ := blobs.stats(); count != 2 {
+		t.Errorf("Expected 2 stored blobs, got %d", count)
+	}
+}
+
+// TestDedupCollect tests that blobs are removed once no upload links to them anymore
+func TestDedupCollect(t *testing.T) {
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	blobs, err := newBlobStore(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadBlobs = blobs
+	defer func() { uploadBlobs = nil }()
+
+	saveUpload(uploadsDir, "a.txt", "admin@test", strings.NewReader("a"))
+	saveUpload(uploadsDir, "b.txt", "admin@test", strings.NewReader("b"))
+	info, _ := os.Stat(filepath.Join(uploadsDir, "a.txt"))
+	if _, ok := linkCount(info); !ok {
+		t.Skip("hard link counts are not known on this platform")
+	}
+
+	os.Remove(filepath.Join(uploadsDir, "a.txt"))
+	if removed := blobs.collect(); removed != 1 {
+		t.Errorf("Expected 1 removed blob, got %d", removed)
+	}
+	if count, _ := blobs.stats(); count != 1 {
+		t.Errorf("Expected the blob of b.txt to be kept, got %d blobs", count)
+	}
+}
+
+// TestDedupPutReportsDuplicate tests that raw uploads tell the client about re-uploads
+func TestDedupPutReportsDuplicate(t *testing.T) {
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	blobs, err := newBlobStore(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadBlobs = blobs
+	defer func() { uploadBlobs = nil }()
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	put := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/uploads/notes.txt", strings.NewReader("notes"))
+		req.Header.Set("Accept", "application/json")
+		return serveAPI(mux, req)
+	}
+	if rr := put(); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	rr := put()
+	var result putUploadResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || !result.Duplicate || result.Name != "notes.txt" {
+		t.Errorf("Expected status code %d with a duplicate of notes.txt, got %d: %+v", http.StatusOK, rr.Code, result)
+	}
+}
+
+// TestDedupUnshare tests that changing a deduplicated file in place leaves its twins alone
+func TestDedupUnshare(t *testing.T) {
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	blobs, err := newBlobStore(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadBlobs = blobs
+	defer func() { uploadBlobs = nil }()
+
+	saveUpload(uploadsDir, "a.txt", "admin@test", strings.NewReader("same"))
+	saveUpload(filepath.Join(uploadsDir, "docs"), "b.txt", "admin@test", strings.NewReader("same"))
+	target := filepath.Join(uploadsDir, "docs", "b.txt")
+
+	if err := blobs.unshare(target, false); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(" but changed")
+	f.Close()
+
+	if data, _ := os.ReadFile(filepath.Join(uploadsDir, "a.txt")); string(data) != "same" {
+		t.Errorf("Expected the twin to keep its content, got %q", data)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "same but changed" {
+		t.Errorf("Expected the copy to be changed, got %q", data)
+	}
+}
+
+// TestDedupOtherProtocols tests that overwriting a deduplicated file over WebDAV or S3 leaves its twins alone
+func TestDedupOtherProtocols(t *testing.T) {
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	blobs, err := newBlobStore(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadBlobs = blobs
+	defer func() { uploadBlobs = nil }()
+
+	for _, dir := range []string{"", "dav", "s3"} {
+		if _, err := saveUpload(filepath.Join(uploadsDir, dir), "a.txt", "admin@test", strings.NewReader("same")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dav := newDAVTestServer(t, "", uploadsDir, secretKey)
+	if err := dav.Write("/uploads/dav/a.txt", []byte("changed over WebDAV"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	accessKeyID, secretAccessKey := s3Credentials()
+	client := newS3TestClient(t, Config{UploadsDir: uploadsDir}, accessKeyID, secretAccessKey)
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("uploads"),
+		Key:    aws.String("s3/a.txt"),
+		Body:   bytes.NewReader([]byte("changed over S3")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"a.txt": "same", "dav/a.txt": "changed over WebDAV", "s3/a.txt": "changed over S3"} {
+		if data, _ := os.ReadFile(filepath.Join(uploadsDir, filepath.FromSlash(name))); string(data) != expected {
+			t.Errorf("Expected %s to hold %q, got %q", name, expected, data)
+		}
+	}
+}
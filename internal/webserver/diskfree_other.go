@@ -0,0 +1,8 @@
+//go:build !unix
+
+package webserver
+
+// diskFree reports that the free space is unknown on this platform
+func diskFree(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package webserver
+
+import "syscall"
+
+// diskFree returns the bytes available to unprivileged users on the filesystem of dir
+func diskFree(dir string) (int64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, false
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), true
+}
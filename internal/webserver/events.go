@@ -0,0 +1,248 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// eventPollInterval is how often the mounts are scanned for changes while someone listens
+const eventPollInterval = 2 * time.Second
+
+// eventKeepAlive is how often an idle event stream sends a comment to keep proxies from closing it
+const eventKeepAlive = 30 * time.Second
+
+// Types of file events
+const (
+	fileAdded   = "added"
+	fileRemoved = "removed"
+	fileChanged = "changed"
+)
+
+// fileEvent is published on /events when a file appears, disappears or changes
+type fileEvent struct {
+	Type  string     `json:"type"`
+	Path  string     `json:"path"`
+	Entry *listEntry `json:"entry,omitempty"`
+}
+
+// eventHub fans out file events to the connected /events clients
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan fileEvent]struct{}
+	// onSubscribe is called when a client connects
+	onSubscribe func()
+	// stop is closed when the server shuts down
+	stop <-chan struct{}
+}
+
+// newEventHub creates a hub without subscribers
+func newEventHub(stop <-chan struct{}) *eventHub {
+	return &eventHub{subscribers: map[chan fileEvent]struct{}{}, stop: stop}
+}
+
+// subscribe registers a new client
+func (h *eventHub) subscribe() chan fileEvent {
+	ch := make(chan fileEvent, 64)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes a client
+func (h *eventHub) unsubscribe(ch chan fileEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// listening reports whether any client is connected
+func (h *eventHub) listening() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers) > 0
+}
+
+// publish sends an event to all clients. A client that cannot keep up is
+// disconnected; the browser reconnects and reloads the lists.
+func (h *eventHub) publish(event fileEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// register adds the event stream to the mux
+func (h *eventHub) register(mux *http.ServeMux) {
+	mux.HandleFunc("/events", loggingMiddleware(requireKey(h.ServeHTTP)))
+}
+
+// ServeHTTP streams the events to a client as Server-Sent Events
+func (h *eventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events := h.subscribe()
+	defer h.unsubscribe(events)
+	if h.onSubscribe != nil {
+		h.onSubscribe()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	out := newFlushWriter(w)
+	fmt.Fprint(out, ": connected\n\n")
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(out, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(out, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// fileSnapshot maps the virtual paths of all files and directories to their entries
+type fileSnapshot map[string]listEntry
+
+// snapshotMounts records the current state of all mounts
+func snapshotMounts(mounts []mount) fileSnapshot {
+	snapshot := fileSnapshot{}
+	for _, m := range mounts {
+		info, err := os.Stat(m.Path)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			p := m.Name + "/" + info.Name()
+			snapshot[p] = newFileEntry(p, m.Path, info)
+			continue
+		}
+
+		filepath.WalkDir(m.Path, func(hostPath string, d fs.DirEntry, err error) error {
+			if err != nil || hostPath == m.Path {
+				return nil
+			}
+			// Files still being written show up once they are complete
+			if isPartialFile(hostPath) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(m.Path, hostPath)
+			p := path.Join(m.Name, filepath.ToSlash(rel))
+			snapshot[p] = newFileEntry(p, hostPath, info)
+			return nil
+		})
+	}
+	return snapshot
+}
+
+// diffSnapshots returns the events that turn old into current, ordered by path
+func diffSnapshots(old, current fileSnapshot) []fileEvent {
+	var events []fileEvent
+	for p, entry := range current {
+		before, ok := old[p]
+		switch {
+		case !ok:
+			events = append(events, fileEvent{Type: fileAdded, Path: p, Entry: &entry})
+		case !entry.IsDir && (before.Size != entry.Size || !before.ModTime.Equal(entry.ModTime)):
+			events = append(events, fileEvent{Type: fileChanged, Path: p, Entry: &entry})
+		}
+	}
+	for p := range old {
+		if _, ok := current[p]; !ok {
+			events = append(events, fileEvent{Type: fileRemoved, Path: p})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
+
+// fileWatcher polls the mounts for changes while clients listen for events
+type fileWatcher struct {
+	mounts []mount
+	hub    *eventHub
+	poke   chan struct{}
+}
+
+// newFileWatcher creates a watcher publishing to hub
+func newFileWatcher(mounts []mount, hub *eventHub) *fileWatcher {
+	w := &fileWatcher{mounts: mounts, hub: hub, poke: make(chan struct{}, 1)}
+	hub.onSubscribe = w.rescan
+	return w
+}
+
+// rescan asks for a scan right away, e.g. after an upload finished
+func (w *fileWatcher) rescan() {
+	select {
+	case w.poke <- struct{}{}:
+	default:
+	}
+}
+
+// run scans the mounts until stop is closed. Nothing is scanned while no
+// client listens; a new client starts from a fresh snapshot.
+func (w *fileWatcher) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+
+	var snapshot fileSnapshot
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.poke:
+		case <-stop:
+			return
+		}
+
+		if !w.hub.listening() {
+			snapshot = nil
+			continue
+		}
+		current := snapshotMounts(w.mounts)
+		if snapshot != nil {
+			for _, event := range diffSnapshots(snapshot, current) {
+				w.hub.publish(event)
+			}
+		}
+		snapshot = current
+	}
+}
@@ -0,0 +1,127 @@
+package webserver
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDiffSnapshots checks that added, changed and removed files are detected
+func TestDiffSnapshots(t *testing.T) {
+	uploadsDir := t.TempDir()
+	mounts := getMounts("", uploadsDir)
+	os.WriteFile(filepath.Join(uploadsDir, "keep.txt"), []byte("keep"), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, "change.txt"), []byte("old"), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, "remove.txt"), []byte("remove"), 0o644)
+	before := snapshotMounts(mounts)
+
+	os.WriteFile(filepath.Join(uploadsDir, "change.txt"), []byte("new content"), 0o644)
+	os.Remove(filepath.Join(uploadsDir, "remove.txt"))
+	os.MkdirAll(filepath.Join(uploadsDir, "docs"), os.ModePerm)
+	os.WriteFile(filepath.Join(uploadsDir, "docs", "add.txt"), []byte("add"), 0o644)
+
+	events := diffSnapshots(before, snapshotMounts(mounts))
+	expected := []string{
+		"changed uploads/change.txt",
+		"added uploads/docs",
+		"added uploads/docs/add.txt",
+		"removed uploads/remove.txt",
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %v", len(expected), events)
+	}
+	for i, event := range events {
+		if got := event.Type + " " + event.Path; got != expected[i] {
+			t.Errorf("Expected event %q, got %q", expected[i], got)
+		}
+	}
+	if events[0].Entry == nil || events[0].Entry.Size != 11 {
+		t.Errorf("Expected the changed entry with its new size, got %+v", events[0].Entry)
+	}
+}
+
+// TestSnapshotSkipsPartialFiles checks that files still being written are not announced
+func TestSnapshotSkipsPartialFiles(t *testing.T) {
+	uploadsDir := t.TempDir()
+	partial := filepath.Join(uploadsDir, "big.iso")
+	os.WriteFile(partial, []byte("half"), 0o644)
+
+	done := trackPartialFile(partial)
+	if _, ok := snapshotMounts(getMounts("", uploadsDir))["uploads/big.iso"]; ok {
+		t.Error("Expected the partial file to be skipped")
+	}
+	done()
+	if _, ok := snapshotMounts(getMounts("", uploadsDir))["uploads/big.iso"]; !ok {
+		t.Error("Expected the finished file to be listed")
+	}
+}
+
+// TestEventHub checks that published events reach a connected client
+func TestEventHub(t *testing.T) {
+	hub := newEventHub(make(chan struct{}))
+	subscribed := make(chan struct{}, 1)
+	hub.onSubscribe = func() { subscribed <- struct{}{} }
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	select {
+	case <-subscribed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the client to subscribe")
+	}
+	hub.publish(fileEvent{Type: fileRemoved, Path: "uploads/a.txt"})
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), ":") || scanner.Text() == "" {
+			continue
+		}
+		lines = append(lines, scanner.Text())
+		if len(lines) == 2 {
+			break
+		}
+	}
+	expected := []string{"event: removed", `data: {"type":"removed","path":"uploads/a.txt"}`}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+}
+
+// TestEventStreamRoute checks that the registered route sends events right away
+// instead of holding them back in the logging middleware
+func TestEventStreamRoute(t *testing.T) {
+	hub := newEventHub(make(chan struct{}))
+	mux := http.NewServeMux()
+	hub.register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected the stream to start right away, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != ": connected\n" {
+		t.Errorf("Expected the connected comment, got %q (%v)", line, err)
+	}
+}
@@ -1,8 +1,15 @@
 package webserver
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 // fileInfo represents information about a file for display in the UI
@@ -10,12 +17,36 @@ type fileInfo struct {
 	Name string
 	Size int64
 	URL  string
+	// ExpiresAt is when the retention rules remove the upload, if ever
+	ExpiresAt time.Time
+	// SHA256 is the checksum of the file, if it is known
+	SHA256 string
+}
+
+// Lifetime returns how long the file is kept, or an empty string if it is kept forever
+func (f fileInfo) Lifetime() string {
+	return formatLifetime(f.ExpiresAt)
 }
 
 // FormatSize returns a human-readable string representation of the file size
 func (f fileInfo) FormatSize() string {
-	size := f.Size
+	return formatSize(f.Size)
+}
+
+// PreviewURL returns the URL of the preview page of the file, or its own URL
+// for streams, which can only be watched or downloaded once
+func (f fileInfo) PreviewURL() string {
+	if f.Size < 0 {
+		return f.URL
+	}
+	return "/preview" + f.URL
+}
+
+// formatSize returns a human-readable string representation of a size in bytes
+func formatSize(size int64) string {
 	switch {
+	case size < 0: // Streamed, the size is not known in advance
+		return "streamed"
 	case size >= 1<<30: // GB
 		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
 	case size >= 1<<20: // MB
@@ -40,35 +71,31 @@ func getSharedFiles(sharePath string) ([]fileInfo, error) {
 	// If it's a file, add just that file
 	if !info.IsDir() {
 		files = append(files, fileInfo{
-			Name: info.Name(),
-			Size: info.Size(),
-			URL:  "/shared/" + info.Name(),
+			Name:   info.Name(),
+			Size:   info.Size(),
+			URL:    "/shared/" + url.PathEscape(info.Name()),
+			SHA256: checksums.lookup(sharePath, info),
 		})
 		return files, nil
 	}
 
 	// If it's a directory, add all files in the directory (not subdirectories)
-	entries, err := os.ReadDir(sharePath)
+	entries, err := dirCache.readDir(sharePath)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, entry := range entries {
+	for _, fileInfoStat := range entries {
 		// Skip subdirectories
-		if entry.IsDir() {
-			continue
-		}
-
-		// Get file info
-		fileInfoStat, err := entry.Info()
-		if err != nil {
+		if fileInfoStat.IsDir() {
 			continue
 		}
 
 		files = append(files, fileInfo{
-			Name: fileInfoStat.Name(),
-			Size: fileInfoStat.Size(),
-			URL:  "/shared/" + fileInfoStat.Name(),
+			Name:   fileInfoStat.Name(),
+			Size:   fileInfoStat.Size(),
+			URL:    "/shared/" + url.PathEscape(fileInfoStat.Name()),
+			SHA256: checksums.lookup(filepath.Join(sharePath, fileInfoStat.Name()), fileInfoStat),
 		})
 	}
 
@@ -92,29 +119,159 @@ func getUploadsFiles(uploadsDir string) ([]fileInfo, error) {
 	}
 
 	// Read files in the directory
-	entries, err := os.ReadDir(uploadsDir)
+	entries, err := dirCache.readDir(uploadsDir)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, entry := range entries {
+	for _, fileInfoStat := range entries {
 		// Skip subdirectories
-		if entry.IsDir() {
-			continue
-		}
-
-		// Get file info
-		fileInfoStat, err := entry.Info()
-		if err != nil {
+		if fileInfoStat.IsDir() {
 			continue
 		}
 
 		files = append(files, fileInfo{
-			Name: fileInfoStat.Name(),
-			Size: fileInfoStat.Size(),
-			URL:  "/uploads/" + fileInfoStat.Name(),
+			Name:      fileInfoStat.Name(),
+			Size:      fileInfoStat.Size(),
+			URL:       "/uploads/" + url.PathEscape(fileInfoStat.Name()),
+			ExpiresAt: uploadRetention.expiresAt("uploads/"+fileInfoStat.Name(), fileInfoStat),
+			SHA256:    checksums.lookup(filepath.Join(uploadsDir, fileInfoStat.Name()), fileInfoStat),
 		})
 	}
 
 	return files, nil
 }
+
+// storedUpload describes a file stored by saveUpload
+type storedUpload struct {
+	Name   string
+	Size   int64
+	SHA256 string
+	// Duplicate is set when the same content was already uploaded as Name and nothing was stored
+	Duplicate bool
+}
+
+// saveUpload stores the content of src uploaded by session in dir under a unique variant
+// of filename and returns the name the file was stored under together with its SHA-256 digest
+func saveUpload(dir, filename, session string, src io.Reader) (storedUpload, error) {
+	return saveVerifiedUpload(dir, filename, session, "", src)
+}
+
+// saveVerifiedUpload works like saveUpload, but removes the file again if its
+// SHA-256 digest does not match expected; an empty expected checksum accepts any content
+func saveVerifiedUpload(dir, filename, session, expected string, src io.Reader) (storedUpload, error) {
+	// Create uploads directory if it doesn't exist
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return storedUpload{}, fmt.Errorf("creating uploads directory: %w", err)
+	}
+
+	// The quotas are checked while the file is written, so it stops as soon as it gets too large
+	quota, err := uploadQuota.start(session)
+	if err != nil {
+		return storedUpload{}, err
+	}
+	storedPath := ""
+	defer func() { quota.finish(storedPath) }()
+
+	// Create destination file with a unique name. With deduplication the upload
+	// goes to the blob store first and is linked into dir once its digest is known.
+	var dst *os.File
+	if uploadBlobs != nil {
+		dst, err = uploadBlobs.create()
+	} else {
+		dst, err = createUniqueFile(dir, filename, os.O_RDWR, 0o666)
+	}
+	if err != nil {
+		return storedUpload{}, fmt.Errorf("creating file: %w", err)
+	}
+	dstPath := dst.Name()
+	defer dst.Close()
+	defer trackPartialFile(dstPath)()
+
+	// Copy uploaded file to destination, hashing it on the way; an interrupted
+	// upload must not leave a truncated file behind
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(quota.writer(), dst, h), src)
+	if err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return storedUpload{}, fmt.Errorf("saving file: %w", err)
+	}
+
+	// A corrupted upload is worse than none at all
+	sum := hex.EncodeToString(h.Sum(nil))
+	if expected != "" && sum != expected {
+		dst.Close()
+		os.Remove(dstPath)
+		return storedUpload{}, fmt.Errorf("%w: expected %s, got %s", errChecksumMismatch, expected, sum)
+	}
+
+	if uploadBlobs != nil {
+		dst.Close()
+		name, duplicate, err := uploadBlobs.add(dstPath, sum, dir, filename)
+		if err != nil {
+			os.Remove(dstPath)
+			return storedUpload{}, fmt.Errorf("storing file: %w", err)
+		}
+		dstPath = filepath.Join(dir, name)
+		// Re-uploads of a file that is already there do not create another copy
+		if duplicate {
+			log.Printf("Upload of %s is identical to %s, not stored again", filename, dstPath)
+			notifyUploadFinished(dstPath)
+			return storedUpload{Name: name, Size: n, SHA256: sum, Duplicate: true}, nil
+		}
+	}
+
+	storedPath = dstPath
+	checksums.record(dstPath, sum)
+	notifyUploadFinished(dstPath)
+	return storedUpload{Name: filepath.Base(dstPath), Size: n, SHA256: sum}, nil
+}
+
+// openUploadFile opens the file at hostPath in the uploads directory for
+// writing by session, as WebDAV does, counting what is written against the
+// upload quotas and the free disk space. A deduplicated file gets its own
+// copy first, so writing to it leaves its twins alone.
+func openUploadFile(hostPath string, flag int, perm os.FileMode, session string) (*partialWriteFile, error) {
+	if err := uploadBlobs.unshare(hostPath, flag&os.O_TRUNC != 0); err != nil {
+		return nil, err
+	}
+	quota, err := uploadQuota.startFile(session, hostPath)
+	if err != nil {
+		return nil, err
+	}
+	_, statErr := os.Stat(hostPath)
+	f, err := os.OpenFile(hostPath, flag, perm)
+	if err != nil {
+		quota.finish("")
+		return nil, err
+	}
+	return &partialWriteFile{
+		File:      f,
+		done:      trackPartialFile(hostPath),
+		quota:     quota,
+		appending: flag&os.O_APPEND != 0,
+		created:   os.IsNotExist(statErr),
+	}, nil
+}
+
+// createUploadFile creates a new file in dir under a unique variant of
+// filename for writing by session, as SFTP does; like openUploadFile it
+// counts what is written against the upload quotas
+func createUploadFile(dir, filename, session string) (*partialWriteFile, error) {
+	quota, err := uploadQuota.start(session)
+	if err != nil {
+		return nil, err
+	}
+	f, err := createUniqueFile(dir, filename, os.O_WRONLY, 0o644)
+	if err != nil {
+		quota.finish("")
+		return nil, err
+	}
+	return &partialWriteFile{
+		File:    f,
+		done:    trackPartialFile(f.Name()),
+		quota:   quota,
+		created: true,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package webserver
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+//go:embed templates/gallery.html
+var galleryHTML string
+
+// galleryTemplate renders the gallery view of a directory
+var galleryTemplate = template.Must(template.New("gallery.html").Parse(galleryHTML))
+
+// galleryData holds the data for the gallery template
+type galleryData struct {
+	Path string
+	// Parent links to the gallery of the parent directory, empty at the root
+	Parent string
+	Dirs   []listEntry
+	Images []listEntry
+	// Others is the number of files that are not images
+	Others int
+}
+
+// galleryHandler serves the gallery view of the directories in the mounts under /gallery/
+func galleryHandler(mounts []mount) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		p := cleanVirtualPath(strings.TrimPrefix(r.URL.Path, "/gallery"))
+		result, err := listAll(mounts, p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// A file is shown in the gallery of its directory
+		if !result.IsDir {
+			http.Redirect(w, r, "/gallery/"+path.Dir(p)+"/", http.StatusSeeOther)
+			return
+		}
+
+		data := galleryData{Path: p}
+		if p != "" {
+			data.Parent = "/gallery/" + strings.TrimPrefix(path.Dir(p)+"/", "./")
+		}
+		for _, entry := range result.Entries {
+			switch {
+			case entry.IsDir:
+				data.Dirs = append(data.Dirs, entry)
+			case isImageFile(entry.Name):
+				data.Images = append(data.Images, entry)
+			default:
+				data.Others++
+			}
+		}
+
+		if err := galleryTemplate.Execute(w, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package webserver
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexMaxAge bounds how long a directory listing is reused. Adding, removing
+// or renaming files changes the directory and invalidates it right away;
+// files changed in place show up after at most this long.
+const indexMaxAge = 5 * time.Second
+
+// indexMaxDirs is the number of directories kept in the index before it starts over
+const indexMaxDirs = 1024
+
+// dirCache is the index of the directories listed by the server
+var dirCache = newDirIndex(indexMaxAge)
+
+func init() {
+	// Finished uploads must show up immediately
+	observeUploads(func(hostPath string) {
+		dirCache.invalidate(filepath.Dir(hostPath))
+	})
+}
+
+// indexedDir is a directory listing kept in memory
+type indexedDir struct {
+	modTime time.Time
+	loaded  time.Time
+	entries []fs.FileInfo
+}
+
+// dirIndex keeps directory listings in memory, so large directories are not
+// read from disk on every request. A listing is read again when the
+// directory's modification time changes, when it is older than maxAge or
+// when it is invalidated explicitly.
+type dirIndex struct {
+	mu     sync.Mutex
+	maxAge time.Duration
+	dirs   map[string]*indexedDir
+}
+
+// newDirIndex creates an empty index
+func newDirIndex(maxAge time.Duration) *dirIndex {
+	return &dirIndex{maxAge: maxAge, dirs: map[string]*indexedDir{}}
+}
+
+// readDir returns the entries of a directory sorted by name. The returned
+// slice is shared and must not be modified.
+func (x *dirIndex) readDir(dir string) ([]fs.FileInfo, error) {
+	dir = filepath.Clean(dir)
+	info, err := os.Stat(dir)
+	if err != nil {
+		x.invalidate(dir)
+		return nil, err
+	}
+
+	x.mu.Lock()
+	cached, ok := x.dirs[dir]
+	x.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && time.Since(cached.loaded) < x.maxAge {
+		return cached.entries, nil
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.FileInfo, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		entryInfo, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entryInfo)
+	}
+
+	x.mu.Lock()
+	if len(x.dirs) >= indexMaxDirs {
+		x.dirs = map[string]*indexedDir{}
+	}
+	x.dirs[dir] = &indexedDir{modTime: info.ModTime(), loaded: time.Now(), entries: entries}
+	x.mu.Unlock()
+	return entries, nil
+}
+
+// invalidate drops the listing of a directory
+func (x *dirIndex) invalidate(dir string) {
+	x.mu.Lock()
+	delete(x.dirs, filepath.Clean(dir))
+	x.mu.Unlock()
+}
+
+// matchName reports whether a file name matches a filter. Filters with glob
+// characters like *.pdf are matched as patterns, others as substrings. Both
+// ignore case, and an empty filter matches everything.
+func matchName(filter, name string) bool {
+	if filter == "" {
+		return true
+	}
+	filter, name = strings.ToLower(filter), strings.ToLower(name)
+	if strings.ContainsAny(filter, "*?[") {
+		matched, err := path.Match(filter, name)
+		return err == nil && matched
+	}
+	return strings.Contains(name, filter)
+}
+
+// pageBounds returns the slice bounds of a page of size limit starting at offset;
+// a limit of 0 or less means no limit
+func pageBounds(total, offset, limit int) (int, int) {
+	start := min(max(offset, 0), total)
+	if limit <= 0 {
+		return start, total
+	}
+	return start, min(start+limit, total)
+}
@@ -0,0 +1,226 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMatchName tests substring and glob filters
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		filter string
+		name   string
+		want   bool
+	}{
+		{"", "anything.txt", true},
+		{"voice", "Invoice-2024.pdf", true},
+		{"VOICE", "invoice.pdf", true},
+		{"receipt", "invoice.pdf", false},
+		{"*.pdf", "Invoice.PDF", true},
+		{"*.pdf", "invoice.pdf.txt", false},
+		{"report-?.txt", "report-1.txt", true},
+		{"[", "a[b", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchName(tt.filter, tt.name); got != tt.want {
+			t.Errorf("matchName(%q, %q): expected %v, got %v", tt.filter, tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestPageBounds tests clamping of offsets and limits
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		total, offset, limit int
+		start, end           int
+	}{
+		{10, 0, 0, 0, 10},
+		{10, 0, 3, 0, 3},
+		{10, 9, 3, 9, 10},
+		{10, 20, 3, 10, 10},
+		{10, -5, 3, 0, 3},
+	}
+
+	for _, tt := range tests {
+		start, end := pageBounds(tt.total, tt.offset, tt.limit)
+		if start != tt.start || end != tt.end {
+			t.Errorf("pageBounds(%d, %d, %d): expected %d:%d, got %d:%d", tt.total, tt.offset, tt.limit, tt.start, tt.end, start, end)
+		}
+	}
+}
+
+// TestDirIndex tests that listings are reused until the directory changes
+func TestDirIndex(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644)
+	index := newDirIndex(time.Hour)
+
+	entries, err := index.readDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	// Adding a file changes the directory's modification time
+	os.WriteFile(filepath.Join(dir, "b.txt"), nil, 0o644)
+	os.Chtimes(dir, time.Time{}, time.Now().Add(time.Minute))
+	if entries, _ = index.readDir(dir); len(entries) != 2 {
+		t.Errorf("Expected 2 entries after adding a file, got %d", len(entries))
+	}
+
+	// Without a change of the modification time, the cached listing is used
+	// until it is invalidated
+	info, _ := os.Stat(dir)
+	os.WriteFile(filepath.Join(dir, "c.txt"), nil, 0o644)
+	os.Chtimes(dir, time.Time{}, info.ModTime())
+	if entries, _ = index.readDir(dir); len(entries) != 2 {
+		t.Errorf("Expected the cached 2 entries, got %d", len(entries))
+	}
+	index.invalidate(dir)
+	if entries, _ = index.readDir(dir); len(entries) != 3 {
+		t.Errorf("Expected 3 entries after invalidating, got %d", len(entries))
+	}
+
+	// Removed directories are reported and dropped
+	os.RemoveAll(dir)
+	if _, err := index.readDir(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+}
+
+// TestListFilesPagination tests filtering and paging a directory listing
+func TestListFilesPagination(t *testing.T) {
+	uploadsDir := t.TempDir()
+	for i := range 5 {
+		os.WriteFile(filepath.Join(uploadsDir, fmt.Sprintf("report-%d.txt", i)), []byte("x"), 0o644)
+	}
+	os.WriteFile(filepath.Join(uploadsDir, "photo.jpg"), []byte("x"), 0o644)
+	mounts := getMounts("", uploadsDir)
+
+	page, err := listFiles(mounts, "uploads", listOptions{Filter: "report", Offset: 3, Limit: 10, Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 5 || len(page.Entries) != 2 {
+		t.Fatalf("Expected 2 of 5 entries, got %d of %d", len(page.Entries), page.Total)
+	}
+	if page.Entries[0].Name != "report-3.txt" || page.Entries[1].SHA256 == "" {
+		t.Errorf("Unexpected entries: %+v", page.Entries)
+	}
+
+	// The API exposes the same options as query parameters
+	mux := newMux(Config{UploadsDir: uploadsDir})
+	rr := serveAPI(mux, httptest.NewRequest("GET", "/api/v1/files/uploads?filter=*.txt&limit=2", nil))
+	var result listing
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 5 || len(result.Entries) != 2 || result.Entries[0].Name != "report-0.txt" {
+		t.Errorf("Unexpected listing: %+v", result)
+	}
+
+	rr = serveAPI(mux, httptest.NewRequest("GET", "/api/v1/files/uploads?limit=-1", nil))
+	if rr.Code != 400 {
+		t.Errorf("Expected status code 400 for a negative limit, got %d", rr.Code)
+	}
+}
+
+// TestRenderIndexTemplatePagination tests that large directories are split into pages
+func TestRenderIndexTemplatePagination(t *testing.T) {
+	uploadsDir := t.TempDir()
+	for i := range indexPageSize + 10 {
+		os.WriteFile(filepath.Join(uploadsDir, fmt.Sprintf("file-%04d.txt", i)), nil, 0o644)
+	}
+
+	rr := httptest.NewRecorder()
+	if err := renderIndexTemplate(rr, httptest.NewRequest("GET", "/?uploads_page=2", nil), uploadsDir, ""); err != nil {
+		t.Fatal(err)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Page 2 of 2 (110 files)") {
+		t.Error("Expected the pager to show the second page")
+	}
+	if strings.Contains(body, "file-0000.txt") || !strings.Contains(body, "file-0109.txt") {
+		t.Error("Expected only the files of the second page")
+	}
+	if !strings.Contains(body, `href="/?uploads_page=1"`) {
+		t.Error("Expected a link to the previous page")
+	}
+
+	// Filtering narrows the list down to a single page
+	rr = httptest.NewRecorder()
+	if err := renderIndexTemplate(rr, httptest.NewRequest("GET", "/?filter=file-000*", nil), uploadsDir, ""); err != nil {
+		t.Fatal(err)
+	}
+	body = rr.Body.String()
+	if strings.Contains(body, "Page 1 of") || !strings.Contains(body, "file-0009.txt") || strings.Contains(body, "file-0010.txt") {
+		t.Error("Expected the filtered files without a pager")
+	}
+}
+
+// createBenchmarkFiles creates a directory with many small files
+func createBenchmarkFiles(b *testing.B, n int) string {
+	dir := b.TempDir()
+	for i := range n {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%05d.txt", i)), []byte("x"), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// BenchmarkRenderIndex renders the index page of a directory with 10,000
+// files, reading the directory from disk on every request and from the index
+func BenchmarkRenderIndex(b *testing.B) {
+	dir := createBenchmarkFiles(b, 10000)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	b.Run("uncached", func(b *testing.B) {
+		for range b.N {
+			dirCache.invalidate(dir)
+			if err := renderIndexTemplate(httptest.NewRecorder(), req, dir, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for range b.N {
+			if err := renderIndexTemplate(httptest.NewRecorder(), req, dir, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkListFiles lists one page of a directory with 10,000 files through
+// the API code path, with and without the index
+func BenchmarkListFiles(b *testing.B) {
+	dir := createBenchmarkFiles(b, 10000)
+	mounts := getMounts("", dir)
+	opts := listOptions{Limit: 100}
+
+	b.Run("uncached", func(b *testing.B) {
+		for range b.N {
+			dirCache.invalidate(dir)
+			if _, err := listFiles(mounts, "uploads", opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for range b.N {
+			if _, err := listFiles(mounts, "uploads", opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package webserver
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// virtualInterfacePrefixes are name prefixes of container bridges, VPN
+// tunnels and hypervisor networks that are rarely reachable by other devices
+var virtualInterfacePrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vboxnet", "vmnet", "cni", "flannel",
+	"podman", "lxc", "lxd", "tun", "tap", "utun", "wg", "tailscale", "zt",
+}
+
+// interfaceAddr is an IP address of a network interface the server can be reached on
+type interfaceAddr struct {
+	// Interface is the name of the network interface
+	Interface string
+	// IP is the address of the interface
+	IP net.IP
+}
+
+// isVirtualInterface reports whether the interface name belongs to a virtual network
+func isVirtualInterface(name string) bool {
+	for _, prefix := range virtualInterfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrRank orders addresses by how likely other devices can reach them;
+// lower is better and a negative rank means the address is not usable
+func addrRank(addr interfaceAddr) int {
+	ip := addr.IP
+	switch {
+	case ip.IsLoopback(), ip.IsUnspecified(), ip.IsMulticast():
+		return -1
+	case ip.IsLinkLocalUnicast():
+		// IPv6 link-local addresses need a zone most browsers do not accept
+		return -1
+	case ip.To4() != nil && ip.IsPrivate():
+		return 0
+	case ip.To4() != nil:
+		return 1
+	case ip.IsPrivate():
+		return 2
+	default:
+		return 3
+	}
+}
+
+// rankInterfaceAddrs drops unusable addresses and sorts the rest so the best
+// URL comes first. Virtual interfaces are skipped unless only is set, in
+// which case just the addresses of that interface are kept.
+func rankInterfaceAddrs(addrs []interfaceAddr, only string) []interfaceAddr {
+	var usable []interfaceAddr
+	for _, addr := range addrs {
+		if only != "" && addr.Interface != only {
+			continue
+		}
+		if only == "" && isVirtualInterface(addr.Interface) {
+			continue
+		}
+		if addrRank(addr) < 0 {
+			continue
+		}
+		usable = append(usable, addr)
+	}
+
+	sort.SliceStable(usable, func(i, j int) bool {
+		return addrRank(usable[i]) < addrRank(usable[j])
+	})
+	return usable
+}
+
+// localInterfaceAddrs returns the addresses of all interfaces that are up
+func localInterfaceAddrs() ([]interfaceAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []interfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				result = append(result, interfaceAddr{Interface: iface.Name, IP: ipnet.IP})
+			}
+		}
+	}
+	return result, nil
+}
+
+// serverAddrs returns the ranked addresses the server is reachable on for the
+// configured interface and bind address
+func serverAddrs(cfg Config) ([]interfaceAddr, error) {
+	addrs, err := localInterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Interface != "" {
+		if _, err := net.InterfaceByName(cfg.Interface); err != nil {
+			return nil, fmt.Errorf("unknown network interface %q", cfg.Interface)
+		}
+	}
+
+	// A specific bind address is only reachable through that address
+	if ip := net.ParseIP(cfg.Bind); ip != nil && !ip.IsUnspecified() {
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return []interfaceAddr{addr}, nil
+			}
+		}
+		return []interfaceAddr{{IP: ip}}, nil
+	}
+
+	ranked := rankInterfaceAddrs(addrs, cfg.Interface)
+	if ip := net.ParseIP(cfg.Bind); ip != nil && ip.To4() != nil {
+		// 0.0.0.0 does not accept IPv6 connections
+		var ipv4 []interfaceAddr
+		for _, addr := range ranked {
+			if addr.IP.To4() != nil {
+				ipv4 = append(ipv4, addr)
+			}
+		}
+		ranked = ipv4
+	}
+	return ranked, nil
+}
+
+// listenHosts returns the hosts the HTTP server listens on. Without a bind
+// address or interface it listens on all addresses, IPv4 and IPv6.
+func listenHosts(cfg Config, addrs []interfaceAddr) []string {
+	if cfg.Bind != "" {
+		return []string{cfg.Bind}
+	}
+	if cfg.Interface == "" {
+		return []string{""}
+	}
+	hosts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		hosts = append(hosts, addr.IP.String())
+	}
+	return hosts
+}
+
+// addrURL returns the server URL for an address and port
+func addrURL(ip net.IP, port int) string {
+	host := "localhost"
+	if ip != nil && !ip.IsLoopback() {
+		host = ip.String()
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, fmt.Sprint(port)))
+}
@@ -0,0 +1,50 @@
+package webserver
+
+import (
+	"net"
+	"testing"
+)
+
+// TestRankInterfaceAddrs checks that LAN addresses come first and virtual interfaces are dropped
+func TestRankInterfaceAddrs(t *testing.T) {
+	addrs := []interfaceAddr{
+		{Interface: "docker0", IP: net.ParseIP("172.17.0.1")},
+		{Interface: "veth1a2b", IP: net.ParseIP("fe80::1")},
+		{Interface: "tun0", IP: net.ParseIP("10.8.0.2")},
+		{Interface: "wlan0", IP: net.ParseIP("2001:db8::10")},
+		{Interface: "wlan0", IP: net.ParseIP("fe80::abcd")},
+		{Interface: "eth0", IP: net.ParseIP("203.0.113.5")},
+		{Interface: "wlan0", IP: net.ParseIP("192.168.1.10")},
+	}
+
+	ranked := rankInterfaceAddrs(addrs, "")
+	expected := []string{"192.168.1.10", "203.0.113.5", "2001:db8::10"}
+	if len(ranked) != len(expected) {
+		t.Fatalf("Expected %d addresses, got %v", len(expected), ranked)
+	}
+	for i, ip := range expected {
+		if ranked[i].IP.String() != ip {
+			t.Errorf("Expected address %d to be %s, got %s", i, ip, ranked[i].IP)
+		}
+	}
+
+	// An explicitly chosen interface is used even when it looks virtual
+	ranked = rankInterfaceAddrs(addrs, "tun0")
+	if len(ranked) != 1 || ranked[0].IP.String() != "10.8.0.2" {
+		t.Errorf("Expected only the tun0 address, got %v", ranked)
+	}
+}
+
+// TestAddrURL checks URLs for IPv4, IPv6 and loopback addresses
+func TestAddrURL(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.10": "http://192.168.1.10:8080",
+		"2001:db8::10": "http://[2001:db8::10]:8080",
+		"127.0.0.1":    "http://localhost:8080",
+	}
+	for ip, expected := range tests {
+		if got := addrURL(net.ParseIP(ip), 8080); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+	}
+}
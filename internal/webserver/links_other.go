@@ -0,0 +1,10 @@
+//go:build !unix
+
+package webserver
+
+import "os"
+
+// linkCount reports that the number of hard links is unknown on this platform
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package webserver
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkCount returns the number of hard links of the file described by info
+func linkCount(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
@@ -0,0 +1,234 @@
+package webserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// listEntry describes a single file or directory in a machine-readable listing
+type listEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	IsDir    bool      `json:"is_dir"`
+	MimeType string    `json:"mime_type,omitempty"`
+	URL      string    `json:"url"`
+	SHA256   string    `json:"sha256,omitempty"`
+	// ExpiresAt is when the retention rules remove the upload, if ever
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// listing is the response body of the listing endpoint
+type listing struct {
+	Path    string      `json:"path"`
+	IsDir   bool        `json:"is_dir"`
+	Entries []listEntry `json:"entries"`
+	// Total is the number of entries matching the filter, across all pages
+	Total int `json:"total"`
+}
+
+// listOptions select the entries of a directory listing
+type listOptions struct {
+	// Checksums adds the SHA-256 digest of every listed file
+	Checksums bool
+	// Filter keeps only entries whose names match, see matchName
+	Filter string
+	// Offset is the number of matching entries to skip
+	Offset int
+	// Limit is the maximum number of entries; 0 lists all of them
+	Limit int
+}
+
+// escapePath escapes every segment of a slash-separated path for use in a URL
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// newListEntry builds a listing entry for a file stat'ed at the given virtual path
+func newListEntry(virtualPath string, info os.FileInfo) listEntry {
+	entry := listEntry{
+		Name:    info.Name(),
+		Path:    virtualPath,
+		ModTime: info.ModTime().UTC(),
+		IsDir:   info.IsDir(),
+		URL:     "/" + escapePath(virtualPath),
+	}
+	if info.IsDir() {
+		entry.URL += "/"
+	} else {
+		entry.Size = info.Size()
+		entry.MimeType = mimeTypeOf(info.Name())
+		entry.ExpiresAt = uploadRetention.expiresAt(virtualPath, info)
+	}
+	return entry
+}
+
+// newFileEntry builds a listing entry like newListEntry, adding the checksum of the file at hostPath if it is known
+func newFileEntry(virtualPath, hostPath string, info os.FileInfo) listEntry {
+	entry := newListEntry(virtualPath, info)
+	if !info.IsDir() {
+		entry.SHA256 = checksums.lookup(hostPath, info)
+	}
+	return entry
+}
+
+// mimeTypeOf guesses the MIME type of a file from its extension
+func mimeTypeOf(name string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// fileSHA256 returns the hex encoded SHA-256 digest of the file at the given path
+func fileSHA256(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildListing lists the virtual path p across the given mounts.
+// An empty path lists the mounts themselves.
+func buildListing(mounts []mount, p string, withChecksums bool) (*listing, error) {
+	return listFiles(mounts, p, listOptions{Checksums: withChecksums})
+}
+
+// listFiles lists one page of the virtual path p across the given mounts.
+// Checksums are only computed for the entries on the page.
+func listFiles(mounts []mount, p string, opts listOptions) (*listing, error) {
+	result, err := listAll(mounts, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter and paginate the directory before computing any checksums
+	if result.IsDir {
+		var matching []listEntry
+		for _, entry := range result.Entries {
+			if matchName(opts.Filter, entry.Name) {
+				matching = append(matching, entry)
+			}
+		}
+		start, end := pageBounds(len(matching), opts.Offset, opts.Limit)
+		result.Entries = append([]listEntry{}, matching[start:end]...)
+		result.Total = len(matching)
+	} else {
+		result.Total = len(result.Entries)
+	}
+
+	if opts.Checksums {
+		for i, entry := range result.Entries {
+			// Known checksums were already added by listAll
+			if entry.IsDir || entry.SHA256 != "" {
+				continue
+			}
+			hostPath, err := resolveVirtualFile(mounts, entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			if result.Entries[i].SHA256, err = checksums.sum(hostPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// resolveVirtualFile maps the virtual path of a file onto the host filesystem
+func resolveVirtualFile(mounts []mount, p string) (string, error) {
+	name, rel := splitVirtualPath(p)
+	m, ok := findMount(mounts, name)
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return m.resolve(rel)
+}
+
+// listAll lists every entry of the virtual path p, sorted by name
+func listAll(mounts []mount, p string) (*listing, error) {
+	p = cleanVirtualPath(p)
+	result := &listing{Path: p, IsDir: true, Entries: []listEntry{}}
+
+	// The root of the tree contains one directory per mount
+	if p == "" {
+		for _, m := range mounts {
+			entry := listEntry{Name: m.Name, Path: m.Name, IsDir: true, URL: "/" + url.PathEscape(m.Name) + "/"}
+			if info, err := os.Stat(m.Path); err == nil {
+				entry.ModTime = info.ModTime().UTC()
+			} else if !m.Writable {
+				continue
+			}
+			result.Entries = append(result.Entries, entry)
+		}
+		return result, nil
+	}
+
+	name, rel := splitVirtualPath(p)
+	m, ok := findMount(mounts, name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	// A single shared file is presented as a directory holding just that file
+	if m.isFileMount() && rel == "" {
+		info, err := os.Stat(m.Path)
+		if err != nil {
+			return nil, err
+		}
+		result.Entries = append(result.Entries, newFileEntry(path.Join(m.Name, info.Name()), m.Path, info))
+		return result, nil
+	}
+
+	hostPath, err := m.resolve(rel)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		// The uploads directory is only created by the first upload
+		if os.IsNotExist(err) && m.Writable && rel == "" {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	// Listing a file returns just that file
+	if !info.IsDir() {
+		result.IsDir = false
+		result.Entries = append(result.Entries, newFileEntry(p, hostPath, info))
+		return result, nil
+	}
+
+	// Directory entries come sorted by name from the index
+	entries, err := dirCache.readDir(hostPath)
+	if err != nil {
+		return nil, err
+	}
+	result.Entries = make([]listEntry, 0, len(entries))
+	for _, entryInfo := range entries {
+		result.Entries = append(result.Entries, newFileEntry(path.Join(p, entryInfo.Name()), filepath.Join(hostPath, entryInfo.Name()), entryInfo))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package webserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBuildListing tests listing the root, a directory and a file of the virtual tree
+func TestBuildListing(t *testing.T) {
+	sharedDir := t.TempDir()
+	uploadsDir := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(sharedDir, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, "docs", "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts := getMounts(sharedDir, uploadsDir)
+
+	// The root lists one directory per mount
+	root, err := buildListing(mounts, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Entries) != 2 || root.Entries[0].Name != "shared" || root.Entries[1].Name != "uploads" {
+		t.Errorf("Unexpected root entries: %+v", root.Entries)
+	}
+
+	// A directory lists its children
+	dir, err := buildListing(mounts, "shared/docs", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dir.IsDir || len(dir.Entries) != 1 {
+		t.Fatalf("Unexpected directory listing: %+v", dir)
+	}
+	entry := dir.Entries[0]
+	if entry.Path != "shared/docs/a.txt" || entry.URL != "/shared/docs/a.txt" || entry.Size != 5 {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if entry.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Unexpected checksum: %s", entry.SHA256)
+	}
+
+	// A file lists just itself, with the checksum computed above
+	file, err := buildListing(mounts, "shared/docs/a.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.IsDir || len(file.Entries) != 1 || file.Entries[0].SHA256 != entry.SHA256 {
+		t.Errorf("Unexpected file listing: %+v", file)
+	}
+
+	// Paths cannot escape the mount
+	if _, err := buildListing(mounts, "shared/../../etc", false); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+}
+
+// TestBuildListingSingleFile tests listing a share that is a single file
+func TestBuildListingSingleFile(t *testing.T) {
+	sharedFile := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(sharedFile, []byte("pdf"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := buildListing(getMounts(sharedFile, ""), "shared", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].URL != "/shared/report.pdf" {
+		t.Errorf("Unexpected listing: %+v", result)
+	}
+}
+
+// TestBuildListingEscapesURLs tests that names with URL delimiters get a working URL
+func TestBuildListingEscapesURLs(t *testing.T) {
+	sharedDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(sharedDir, "50% off"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, "50% off", "a#1?.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	listing, err := buildListing(getMounts(sharedDir, t.TempDir()), "shared/50% off", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Entries) != 1 || listing.Entries[0].URL != "/shared/50%25%20off/a%231%3F.txt" {
+		t.Errorf("Unexpected entries: %+v", listing.Entries)
+	}
+}
@@ -0,0 +1,128 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// parsePortRange parses a port range like "8000-8100" or a single port like "8000"
+func parsePortRange(s string) (int, int, error) {
+	first, last, found := strings.Cut(s, "-")
+	low, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	high := low
+	if found {
+		high, err = strconv.Atoi(strings.TrimSpace(last))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	if low < 1 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q: ports must be between 1 and 65535", s)
+	}
+	return low, high, nil
+}
+
+// listenTCP opens a listener on the same port for every host. With port 0 the
+// system picks the port, otherwise the ports from low to high are tried until
+// one is free on all hosts. The listeners are returned open, so no other
+// process can take the port before the server uses it.
+func listenTCP(hosts []string, low, high int) ([]net.Listener, int, error) {
+	var lastErr error
+	for port := low; port <= high; port++ {
+		listeners, err := listenTCPPort(hosts, port)
+		if err == nil {
+			return listeners, listeners[0].Addr().(*net.TCPAddr).Port, nil
+		}
+		lastErr = err
+		if !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EACCES) {
+			break
+		}
+	}
+	if low == high {
+		return nil, 0, lastErr
+	}
+	return nil, 0, fmt.Errorf("no free port between %d and %d: %w", low, high, lastErr)
+}
+
+// listenTCPPort opens a listener on port for every host, closing them all if one fails
+func listenTCPPort(hosts []string, port int) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, host := range hosts {
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+
+		// The remaining hosts must use the port picked for the first one
+		port = listener.Addr().(*net.TCPAddr).Port
+	}
+	return listeners, nil
+}
+
+// listenUnix opens a Unix domain socket, replacing a stale socket file left
+// behind by a previous run
+func listenUnix(socketPath string) (net.Listener, error) {
+	if info, err := os.Stat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is already in use", socketPath)
+		}
+		os.Remove(socketPath)
+	}
+	return net.Listen("unix", socketPath)
+}
+
+// closeListeners closes all listeners
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// openListeners opens the HTTP listeners for the configuration. It returns the
+// TCP port the server is reachable on, or 0 when it only listens on Unix sockets.
+func openListeners(cfg Config, hosts []string) ([]net.Listener, int, error) {
+	// Sockets passed by systemd take precedence over the flags
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(listeners) > 0 {
+		port := 0
+		for _, listener := range listeners {
+			if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+				port = addr.Port
+				break
+			}
+		}
+		return listeners, port, nil
+	}
+
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []net.Listener{listener}, 0, nil
+	}
+
+	low, high := cfg.Port, cfg.Port
+	if cfg.PortRange != "" {
+		if low, high, err = parsePortRange(cfg.PortRange); err != nil {
+			return nil, 0, err
+		}
+	} else if cfg.Port < 0 || cfg.Port > 65535 {
+		return nil, 0, fmt.Errorf("invalid port number: %d. Port must be between 1 and 65535", cfg.Port)
+	}
+	return listenTCP(hosts, low, high)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package webserver
+
+import "net"
+
+// systemdListeners reports no sockets, systemd socket activation only exists on Unix
+func systemdListeners() ([]net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,75 @@
+package webserver
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// TestParsePortRange tests parsing of port ranges
+func TestParsePortRange(t *testing.T) {
+	low, high, err := parsePortRange("8000-8100")
+	if err != nil || low != 8000 || high != 8100 {
+		t.Errorf("Expected 8000-8100, got %d-%d (%v)", low, high, err)
+	}
+
+	low, high, err = parsePortRange("9000")
+	if err != nil || low != 9000 || high != 9000 {
+		t.Errorf("Expected 9000-9000, got %d-%d (%v)", low, high, err)
+	}
+
+	for _, invalid := range []string{"", "abc", "9000-8000", "0-10", "1-70000"} {
+		if _, _, err := parsePortRange(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+// TestListenTCPKeepsListener checks that the picked port is served by the returned listener
+func TestListenTCPKeepsListener(t *testing.T) {
+	listeners, port, err := listenTCP([]string{"127.0.0.1"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeListeners(listeners)
+
+	if port == 0 || listeners[0].Addr().(*net.TCPAddr).Port != port {
+		t.Errorf("Expected the listener to be on port %d, got %s", port, listeners[0].Addr())
+	}
+
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Expected the listener to accept connections, got %v", err)
+	}
+	conn.Close()
+
+	// The port is taken now, so a range holding only that port fails
+	if _, _, err := listenTCP([]string{"127.0.0.1"}, port, port); err == nil {
+		t.Error("Expected an error for a port range without free ports")
+	}
+}
+
+// TestListenUnixReplacesStaleSocket checks that a leftover socket file does not block startup
+func TestListenUnixReplacesStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "goshare.sock")
+
+	listener, err := listenUnix(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A socket in use is not replaced
+	if _, err := listenUnix(socketPath); err == nil {
+		t.Error("Expected an error for a socket in use")
+	}
+
+	// Leave the socket file behind like a crashed server would
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	listener, err = listenUnix(socketPath)
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced, got %v", err)
+	}
+	listener.Close()
+}
@@ -0,0 +1,309 @@
+package webserver
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed templates/live.html
+var liveHTML string
+
+// liveTemplate renders the browser view of a live stream
+var liveTemplate = template.Must(template.New("live.html").Parse(liveHTML))
+
+// followPollInterval is how often a followed file is checked for new data
+const followPollInterval = 250 * time.Millisecond
+
+// errSourceTruncated is returned by a live source whose data was replaced and has to be read from the start
+var errSourceTruncated = errors.New("source was truncated")
+
+// liveSource is output that keeps growing while clients watch it
+type liveSource interface {
+	// ReadAt reads the data at offset into p. It blocks until data is
+	// available, returns io.EOF once the source has ended, errSourceTruncated
+	// when offset is past the end of the replaced data and ctx.Err() when ctx
+	// is done.
+	ReadAt(ctx context.Context, p []byte, offset int64) (int, error)
+	// Status describes how the source ended, or is empty while it is still running
+	Status() string
+}
+
+// liveBuffer keeps all output written to it, so late viewers see it from the start
+type liveBuffer struct {
+	mu      sync.Mutex
+	data    []byte
+	closed  bool
+	status  string
+	changed chan struct{}
+}
+
+// newLiveBuffer creates an empty, open buffer
+func newLiveBuffer() *liveBuffer {
+	return &liveBuffer{changed: make(chan struct{})}
+}
+
+// Write appends p and wakes up the waiting readers
+func (b *liveBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, os.ErrClosed
+	}
+	b.data = append(b.data, p...)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// Close ends the output with the given status
+func (b *liveBuffer) Close(status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.status = status
+	close(b.changed)
+}
+
+// ReadAt implements liveSource
+func (b *liveBuffer) ReadAt(ctx context.Context, p []byte, offset int64) (int, error) {
+	for {
+		b.mu.Lock()
+		if offset < int64(len(b.data)) {
+			n := copy(p, b.data[offset:])
+			b.mu.Unlock()
+			return n, nil
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Status implements liveSource
+func (b *liveBuffer) Status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+// followedFile is a growing file served like tail -f
+type followedFile struct {
+	path string
+}
+
+// ReadAt implements liveSource. A file that shrinks was truncated or
+// rotated and is read again from the start.
+func (f followedFile) ReadAt(ctx context.Context, p []byte, offset int64) (int, error) {
+	for {
+		file, err := os.Open(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		if err == nil {
+			if info, err := file.Stat(); err == nil && info.Size() < offset {
+				file.Close()
+				return 0, errSourceTruncated
+			}
+			n, err := file.ReadAt(p, offset)
+			file.Close()
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+		}
+
+		select {
+		case <-time.After(followPollInterval):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Status implements liveSource; a followed file never ends
+func (f followedFile) Status() string {
+	return ""
+}
+
+// liveStream serves a live source as plain chunked text, as Server-Sent
+// Events or, for browsers, as a page following the events
+type liveStream struct {
+	name string
+	src  liveSource
+	// stop is closed when the server shuts down
+	stop <-chan struct{}
+}
+
+// url returns the URL the stream is served under
+func (l *liveStream) url() string {
+	return "/live/" + url.PathEscape(l.name)
+}
+
+// register adds the stream to the mux
+func (l *liveStream) register(mux *http.ServeMux) {
+	mux.HandleFunc(l.url(), loggingMiddleware(requireKey(l.ServeHTTP)))
+}
+
+// ServeHTTP implements http.Handler
+func (l *liveStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Stop streaming when the client leaves or the server shuts down
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-l.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "text/event-stream"):
+		l.serveEvents(ctx, w, r)
+	case strings.Contains(accept, "text/html") && r.URL.Query().Get("raw") == "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := liveTemplate.Execute(w, struct{ Name, URL string }{l.name, l.url()}); err != nil {
+			log.Printf("Error rendering live view: %v", err)
+		}
+	default:
+		l.serveText(ctx, w)
+	}
+}
+
+// serveText streams the output with chunked transfer encoding
+func (l *liveStream) serveText(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	out := newFlushWriter(w)
+	l.copyFrom(ctx, 0, func(p []byte, _ int64) error {
+		_, err := out.Write(p)
+		return err
+	})
+}
+
+// serveEvents streams the output as Server-Sent Events. The event ID is the
+// offset of the data, so a reconnecting EventSource continues where it left off.
+func (l *liveStream) serveEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var offset int64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		fmt.Sscan(lastID, &offset)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	out := newFlushWriter(w)
+	err := l.copyFrom(ctx, offset, func(p []byte, end int64) error {
+		_, err := fmt.Fprintf(out, "id: %d\n%s\n", end, sseData(string(p)))
+		return err
+	})
+	if errors.Is(err, io.EOF) {
+		fmt.Fprintf(out, "event: end\n%s\n", sseData(l.src.Status()))
+	}
+}
+
+// copyFrom passes the output from offset on to write, together with the offset
+// after it, until the source ends, ctx is done or write fails
+func (l *liveStream) copyFrom(ctx context.Context, offset int64, write func(p []byte, end int64) error) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := l.src.ReadAt(ctx, buf, offset)
+		if n > 0 {
+			offset += int64(n)
+			if err := write(buf[:n], offset); err != nil {
+				return err
+			}
+		}
+		// A truncated or rotated file starts over, like tail -f does
+		if errors.Is(err, errSourceTruncated) {
+			offset = 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sseData encodes text as the data lines of a Server-Sent Event
+func sseData(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString("data: ")
+		// A carriage return would end the line for the event parser
+		b.WriteString(strings.ReplaceAll(line, "\r", ""))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// followStreamName returns the stream name of a followed file
+func followStreamName(cfg Config) string {
+	return sanitizeFilename(filepath.Base(cfg.Follow))
+}
+
+// execStreamName returns the stream name of the output of a command
+func execStreamName(cfg Config) string {
+	return sanitizeFilename(filepath.Base(cfg.Exec[0])) + ".log"
+}
+
+// startCommand runs a command, writing its stdout and stderr to the console
+// and to out, and closes out with the exit status once it has finished
+func startCommand(args []string, out *liveBuffer) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = nil
+	cmd.Stdout = io.MultiWriter(console, out)
+	cmd.Stderr = io.MultiWriter(os.Stderr, out)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		err := cmd.Wait()
+		status := "exit status 0"
+		if err != nil {
+			status = err.Error()
+		}
+		log.Printf("Command %s finished: %s", strings.Join(args, " "), status)
+		fmt.Fprintf(out, "\n[%s]\n", status)
+		out.Close(status)
+	}()
+	return nil
+}
@@ -0,0 +1,151 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLiveBufferLateReader checks that a reader joining late sees the output from the start
+func TestLiveBufferLateReader(t *testing.T) {
+	buf := newLiveBuffer()
+	buf.Write([]byte("first\n"))
+
+	stream := &liveStream{name: "build.log", src: buf}
+	srv := httptest.NewServer(stream)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Output written while the client is connected arrives as well
+	buf.Write([]byte("second\n"))
+	buf.Close("exit status 0")
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "first\nsecond\n" {
+		t.Errorf("Expected the whole output, got %q", body)
+	}
+}
+
+// TestLiveStreamEvents checks the Server-Sent Events encoding and the end event
+func TestLiveStreamEvents(t *testing.T) {
+	buf := newLiveBuffer()
+	buf.Write([]byte("a\nb"))
+	buf.Close("exit status 1")
+
+	req := httptest.NewRequest(http.MethodGet, "/live/build.log", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	rr := httptest.NewRecorder()
+	(&liveStream{name: "build.log", src: buf}).ServeHTTP(rr, req)
+
+	expected := "id: 3\ndata: a\ndata: b\n\nevent: end\ndata: exit status 1\n\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, rr.Body.String())
+	}
+
+	// A reconnecting client continues after the last event it has seen
+	req.Header.Set("Last-Event-ID", "3")
+	rr = httptest.NewRecorder()
+	(&liveStream{name: "build.log", src: buf}).ServeHTTP(rr, req)
+	if rr.Body.String() != "event: end\ndata: exit status 1\n\n" {
+		t.Errorf("Expected only the end event, got %q", rr.Body.String())
+	}
+}
+
+// TestFollowedFile checks that data appended to a followed file is picked up
+func TestFollowedFile(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(logPath, []byte("one\n"), 0o644)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0o644)
+		f.WriteString("two\n")
+		f.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var got strings.Builder
+	stream := &liveStream{name: "app.log", src: followedFile{path: logPath}}
+	stream.copyFrom(ctx, 0, func(p []byte, _ int64) error {
+		got.Write(p)
+		if got.String() == "one\ntwo\n" {
+			cancel()
+		}
+		return nil
+	})
+	if got.String() != "one\ntwo\n" {
+		t.Errorf("Expected the appended line, got %q", got.String())
+	}
+}
+
+// TestFollowedFileTruncated checks that a truncated or rotated file is followed from the start again
+func TestFollowedFileTruncated(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(logPath, []byte("a long first line\n"), 0o644)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var got strings.Builder
+	var ends []int64
+	stream := &liveStream{name: "app.log", src: followedFile{path: logPath}}
+	stream.copyFrom(ctx, 0, func(p []byte, end int64) error {
+		got.Write(p)
+		ends = append(ends, end)
+		if len(ends) == 1 {
+			// Truncate the file and write less than was read before
+			os.WriteFile(logPath, []byte("new\n"), 0o644)
+		} else {
+			cancel()
+		}
+		return nil
+	})
+	if got.String() != "a long first line\nnew\n" {
+		t.Errorf("Expected the new content after the truncation, got %q", got.String())
+	}
+	if len(ends) != 2 || ends[1] != 4 {
+		t.Errorf("Expected the offset to start over, got %v", ends)
+	}
+}
+
+// TestLiveStreamRoute checks that output reaches clients of the registered route while the command still runs
+func TestLiveStreamRoute(t *testing.T) {
+	buf := newLiveBuffer()
+	defer buf.Close("exit status 0")
+	buf.Write([]byte("first\n"))
+
+	mux := http.NewServeMux()
+	(&liveStream{name: "build.log", src: buf}).register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, accept := range []string{"text/plain", "text/event-stream"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/live/build.log", nil)
+		req.Header.Set("Authorization", "Bearer "+secretKey)
+		req.Header.Set("Accept", accept)
+		client := &http.Client{Timeout: 2 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected the %s stream to start right away, got %v", accept, err)
+		}
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		resp.Body.Close()
+		if err != nil || !strings.HasSuffix(line, "\n") {
+			t.Errorf("Expected the %s output so far, got %q (%v)", accept, line, err)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package webserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manageMaxFolders bounds the number of folders offered as move destinations
+const manageMaxFolders = 1000
+
+var (
+	// errMountRoot is returned when an operation targets the root of a mount
+	errMountRoot = errors.New("the root of a mount cannot be changed")
+	// errBusy is returned for files that are still being uploaded
+	errBusy = errors.New("the file is still being uploaded")
+	// errInvalidName is returned for names that are empty or contain path separators
+	errInvalidName = errors.New("invalid name")
+)
+
+// fileManager deletes, renames and moves files and creates folders in the
+// uploads directory and, if enabled, the shared directory. Every operation
+// is recorded in the audit log and deleted items go to the trash.
+type fileManager struct {
+	// mounts are all mounts of the server, managed or not
+	mounts []mount
+	// managed are the mounts files may be changed in
+	managed []mount
+	trash   *trash
+	audit   *auditLog
+}
+
+// newFileManager creates the file manager of a server, or returns nil if
+// there is nothing to manage
+func newFileManager(cfg Config) *fileManager {
+	if cfg.UploadsDir == "" {
+		return nil
+	}
+	fm := &fileManager{
+		mounts: getMounts(cfg.SharePath, cfg.UploadsDir),
+		trash:  newTrash(cfg.UploadsDir),
+		audit:  newAuditLog(cfg.UploadsDir),
+	}
+	for _, m := range fm.mounts {
+		if m.Writable || (cfg.ManageShare && m.Name == "shared" && !m.isFileMount()) {
+			m.Writable = true
+			fm.managed = append(fm.managed, m)
+		}
+	}
+	return fm
+}
+
+// validName reports whether name can be used as the name of a file or folder
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`) && strings.TrimSpace(name) == name
+}
+
+// manages reports whether files below the virtual path p may be changed
+func (fm *fileManager) manages(p string) bool {
+	if fm == nil {
+		return false
+	}
+	name, _ := splitVirtualPath(p)
+	_, ok := findMount(fm.managed, name)
+	return ok
+}
+
+// confine maps the virtual path of an item onto the host filesystem, making
+// sure it lies inside a managed mount even when symbolic links are followed
+func (fm *fileManager) confine(p string) (string, error) {
+	name, rel := splitVirtualPath(p)
+	m, ok := findMount(fm.managed, name)
+	if !ok {
+		if _, exists := findMount(fm.mounts, name); exists {
+			return "", os.ErrPermission
+		}
+		return "", os.ErrNotExist
+	}
+	if rel == "" {
+		return "", errMountRoot
+	}
+	hostPath, err := m.resolve(rel)
+	if err != nil {
+		return "", os.ErrNotExist
+	}
+
+	root, err := filepath.EvalSymlinks(m.Path)
+	if err != nil {
+		return "", err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(hostPath))
+	if err != nil {
+		return "", err
+	}
+	if parent != root && !strings.HasPrefix(parent, root+string(filepath.Separator)) {
+		return "", errOutsideMount
+	}
+	return hostPath, nil
+}
+
+// errString returns the message of err, or an empty string if there is none
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// remove moves the item at the virtual path p to the trash
+func (fm *fileManager) remove(session, p string) (TrashItem, error) {
+	p = cleanVirtualPath(p)
+	hostPath, err := fm.confine(p)
+	var item TrashItem
+	if err == nil {
+		if isPartialFile(hostPath) {
+			err = errBusy
+		} else {
+			item, err = fm.trash.add(hostPath, p, session)
+		}
+	}
+	fm.audit.record(auditEntry{Session: session, Op: "delete", Path: p, TrashID: item.ID, Error: errString(err)})
+	if err != nil {
+		return TrashItem{}, err
+	}
+	dirCache.invalidate(filepath.Dir(hostPath))
+	uploadQuota.changed()
+	return item, nil
+}
+
+// restore moves the trash item with the given ID back to where it was deleted from
+func (fm *fileManager) restore(session, id string) (TrashItem, error) {
+	item, err := fm.trash.get(id)
+	if err == nil && !fm.manages(item.Path) {
+		err = os.ErrPermission
+	}
+	if err == nil {
+		item, err = fm.trash.restore(id)
+	}
+	fm.audit.record(auditEntry{Session: session, Op: "restore", Path: item.Path, TrashID: id, Error: errString(err)})
+	if err != nil {
+		return TrashItem{}, err
+	}
+	dirCache.invalidate(filepath.Dir(item.HostPath))
+	uploadQuota.changed()
+	return item, nil
+}
+
+// move renames or moves the item at the virtual path p to the virtual path to;
+// op is "rename" or "move" and only used in the audit log
+func (fm *fileManager) move(session, op, p, to string) (listEntry, error) {
+	p, to = cleanVirtualPath(p), cleanVirtualPath(to)
+	entry, err := fm.doMove(p, to)
+	fm.audit.record(auditEntry{Session: session, Op: op, Path: p, To: to, Error: errString(err)})
+	return entry, err
+}
+
+// doMove renames the item at p to the path to
+func (fm *fileManager) doMove(p, to string) (listEntry, error) {
+	hostPath, err := fm.confine(p)
+	if err != nil {
+		return listEntry{}, err
+	}
+	target, err := fm.confine(to)
+	if err != nil {
+		return listEntry{}, err
+	}
+	if isPartialFile(hostPath) {
+		return listEntry{}, errBusy
+	}
+	if _, err := os.Lstat(hostPath); err != nil {
+		return listEntry{}, err
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return listEntry{}, os.ErrExist
+	}
+	// A folder cannot be moved into itself
+	if strings.HasPrefix(target, hostPath+string(filepath.Separator)) {
+		return listEntry{}, errInvalidName
+	}
+
+	if err := os.Rename(hostPath, target); err != nil {
+		return listEntry{}, err
+	}
+	uploadBlobs.moved(p, to)
+	dirCache.invalidate(filepath.Dir(hostPath))
+	dirCache.invalidate(filepath.Dir(target))
+	uploadQuota.changed()
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return listEntry{}, err
+	}
+	return newListEntry(to, info), nil
+}
+
+// mkdir creates a folder at the virtual path p
+func (fm *fileManager) mkdir(session, p string) (listEntry, error) {
+	p = cleanVirtualPath(p)
+	entry, err := fm.doMkdir(p)
+	fm.audit.record(auditEntry{Session: session, Op: "mkdir", Path: p, Error: errString(err)})
+	return entry, err
+}
+
+// doMkdir creates the folder at p
+func (fm *fileManager) doMkdir(p string) (listEntry, error) {
+	hostPath, err := fm.confine(p)
+	if err != nil {
+		return listEntry{}, err
+	}
+	if err := os.Mkdir(hostPath, 0o755); err != nil {
+		return listEntry{}, err
+	}
+	dirCache.invalidate(filepath.Dir(hostPath))
+
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return listEntry{}, err
+	}
+	return newListEntry(p, info), nil
+}
+
+// folders returns the virtual paths of the folders files can be moved to
+func (fm *fileManager) folders() []string {
+	var folders []string
+	for _, m := range fm.managed {
+		folders = append(folders, m.Name)
+		filepath.WalkDir(m.Path, func(hostPath string, d fs.DirEntry, err error) error {
+			if err != nil || len(folders) >= manageMaxFolders {
+				return filepath.SkipAll
+			}
+			if d.IsDir() && hostPath != m.Path {
+				rel, _ := filepath.Rel(m.Path, hostPath)
+				folders = append(folders, path.Join(m.Name, filepath.ToSlash(rel)))
+			}
+			return nil
+		})
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+// operationError returns a message for an error of a file operation
+func operationError(err error) string {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "no such file or folder"
+	case errors.Is(err, os.ErrExist):
+		return "a file or folder with that name already exists"
+	case errors.Is(err, os.ErrPermission):
+		return "the path is read-only"
+	default:
+		return err.Error()
+	}
+}
+
+// safeRedirect returns target if it is a path on this server, or / otherwise
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, `/\`) {
+		return "/"
+	}
+	return target
+}
+
+// ServeHTTP handles the file operations of the forms on the web pages and
+// redirects back to the page in the back field with a message
+func (fm *fileManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "Forbidden: invalid CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	session := sessionName(r)
+	p := cleanVirtualPath(r.PostFormValue("path"))
+	back, _, _ := strings.Cut(safeRedirect(r.PostFormValue("back")), "?")
+	// The page of an item that is deleted or moved goes away with it
+	onItemPage := back == "/preview/"+p || back == "/preview/"+p+"/"
+
+	var message, undo string
+	var err error
+	switch op := r.PostFormValue("op"); op {
+	case "delete":
+		var item TrashItem
+		if item, err = fm.remove(session, p); err == nil {
+			message = "Moved " + path.Base(p) + " to the trash"
+			undo = item.ID
+			if onItemPage {
+				back = "/preview/" + path.Dir(p) + "/"
+			}
+		}
+	case "restore":
+		var item TrashItem
+		if item, err = fm.restore(session, r.PostFormValue("id")); err == nil {
+			message = "Restored " + item.Path
+		}
+	case "rename", "move":
+		var to string
+		if op == "rename" {
+			name := r.PostFormValue("name")
+			if !validName(name) {
+				err = errInvalidName
+				break
+			}
+			to = path.Join(path.Dir(p), name)
+		} else {
+			to = path.Join(cleanVirtualPath(r.PostFormValue("to")), path.Base(p))
+		}
+		var entry listEntry
+		if entry, err = fm.move(session, op, p, to); err == nil {
+			message = "Moved " + path.Base(p) + " to " + to
+			if onItemPage {
+				back = "/preview/" + entry.Path
+				if entry.IsDir {
+					back += "/"
+				}
+			}
+		}
+	case "mkdir":
+		name := r.PostFormValue("name")
+		if !validName(name) {
+			err = errInvalidName
+			break
+		}
+		if _, err = fm.mkdir(session, path.Join(p, name)); err == nil {
+			message = "Created folder " + name
+		}
+	default:
+		http.Error(w, "Unknown operation", http.StatusBadRequest)
+		return
+	}
+
+	query := url.Values{"message": {message}, "type": {"success"}}
+	if err != nil {
+		query = url.Values{"message": {"Error: " + operationError(err)}, "type": {"error"}}
+	}
+	// The page offers to undo the deletion
+	if undo != "" {
+		query.Set("undo", undo)
+	}
+	http.Redirect(w, r, back+"?"+query.Encode(), http.StatusSeeOther)
+}
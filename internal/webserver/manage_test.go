@@ -0,0 +1,190 @@
+package webserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRoleKeys tests that every role has its own key
+func TestRoleKeys(t *testing.T) {
+	for _, role := range roles {
+		if got, ok := keyRole(roleKey(role)); !ok || got != role {
+			t.Errorf("Expected the key of %s to map back to it, got %q", role, got)
+		}
+	}
+	if roleKey(roleAdmin) != secretKey {
+		t.Error("Expected the admin key to be the secret key")
+	}
+	if _, ok := keyRole("wrong"); ok {
+		t.Error("Expected an unknown key to have no role")
+	}
+}
+
+// serveAs sends the request through mux with the key of role in a bearer token
+func serveAs(mux *http.ServeMux, role string, req *http.Request) *httptest.ResponseRecorder {
+	req.Header.Set("Authorization", "Bearer "+roleKey(role))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestViewerCannotChangeFiles tests that viewers can only read files
+func TestViewerCannotChangeFiles(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "notes.txt"), []byte("notes"), 0o644)
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	if rr := serveAs(mux, roleViewer, httptest.NewRequest("GET", "/api/v1/files/uploads", nil)); rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := serveAs(mux, roleViewer, httptest.NewRequest("DELETE", "/api/v1/files/uploads/notes.txt", nil)); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := serveAs(mux, roleViewer, httptest.NewRequest("PUT", "/uploads/new.txt", strings.NewReader("new"))); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "notes.txt")); err != nil {
+		t.Error("Expected the file to be kept")
+	}
+}
+
+// TestAPIDeleteMovesToTrash tests that deleted items are kept in the trash
+func TestAPIDeleteMovesToTrash(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.MkdirAll(filepath.Join(uploadsDir, "photos"), 0o755)
+	os.WriteFile(filepath.Join(uploadsDir, "photos", "cat.jpg"), []byte("cat"), 0o644)
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	rr := serveAs(mux, roleUploader, httptest.NewRequest("DELETE", "/api/v1/files/uploads/photos", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	metadata, _ := filepath.Glob(filepath.Join(stateDir(uploadsDir), "trash", "*.json"))
+	if len(metadata) != 1 {
+		t.Fatalf("Expected one item in the trash, got %d", len(metadata))
+	}
+	data, _ := os.ReadFile(metadata[0])
+	var item TrashItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Path != "uploads/photos" || !item.IsDir || !strings.HasPrefix(item.DeletedBy, roleUploader+"@") {
+		t.Errorf("Unexpected trash item: %+v", item)
+	}
+	if _, err := os.Stat(filepath.Join(strings.TrimSuffix(metadata[0], ".json"), "photos", "cat.jpg")); err != nil {
+		t.Errorf("Expected the folder to be kept in the trash: %v", err)
+	}
+
+	// The deletion is in the audit log
+	f, err := os.Open(filepath.Join(stateDir(uploadsDir), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var entry auditEntry
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &entry) != nil {
+		t.Fatal("Expected an entry in the audit log")
+	}
+	if entry.Op != "delete" || entry.Path != "uploads/photos" || entry.TrashID != item.ID {
+		t.Errorf("Unexpected audit entry: %+v", entry)
+	}
+}
+
+// TestAPIMoveAndMkdir tests renaming and moving files and creating folders
+func TestAPIMoveAndMkdir(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, "b.txt"), []byte("b"), 0o644)
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	rr := serveAPI(mux, httptest.NewRequest("POST", "/api/v1/folders/uploads/docs", nil))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	rr = serveAPI(mux, httptest.NewRequest("PATCH", "/api/v1/files/uploads/a.txt", strings.NewReader(`{"path": "uploads/docs/a.txt"}`)))
+	var entry listEntry
+	if rr.Code != http.StatusOK || json.NewDecoder(rr.Body).Decode(&entry) != nil || entry.Path != "uploads/docs/a.txt" {
+		t.Fatalf("Unexpected move result (%d): %+v", rr.Code, entry)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "docs", "a.txt")); err != nil {
+		t.Errorf("Expected the file to be moved: %v", err)
+	}
+
+	// Existing items are not overwritten
+	rr = serveAPI(mux, httptest.NewRequest("PATCH", "/api/v1/files/uploads/b.txt", strings.NewReader(`{"path": "uploads/docs/a.txt"}`)))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, rr.Code)
+	}
+
+	// The mount itself cannot be renamed
+	rr = serveAPI(mux, httptest.NewRequest("PATCH", "/api/v1/files/uploads", strings.NewReader(`{"path": "uploads/other"}`)))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+// TestConfineSymlinkEscape tests that symbolic links cannot be used to change files outside a mount
+func TestConfineSymlinkEscape(t *testing.T) {
+	uploadsDir := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644)
+	if err := os.Symlink(outside, filepath.Join(uploadsDir, "link")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+	fm := newFileManager(Config{UploadsDir: uploadsDir})
+
+	if _, err := fm.remove("admin@test", "uploads/link/secret.txt"); err == nil {
+		t.Error("Expected a file behind a symbolic link to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Error("Expected the file outside the mount to be kept")
+	}
+}
+
+// TestManageFormRequiresCSRF tests that the forms of the web pages are protected against forged requests
+func TestManageFormRequiresCSRF(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "old.txt"), []byte("old"), 0o644)
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/manage", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	form := url.Values{"op": {"rename"}, "path": {"uploads/old.txt"}, "name": {"new.txt"}, "back": {"/preview/uploads/old.txt"}}
+
+	if rr := post(form); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d without a CSRF token, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	// csrfToken only looks at the key cookie of the request
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+	form.Set("csrf", csrfToken(req))
+	rr := post(form)
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), "/preview/uploads/new.txt?") {
+		t.Errorf("Expected a redirect to the renamed file, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "new.txt")); err != nil {
+		t.Errorf("Expected the file to be renamed: %v", err)
+	}
+
+	// The preview page of the uploads directory offers the forms
+	if body := servePreview(mux, "/preview/uploads/").Body.String(); !strings.Contains(body, `action="/manage"`) {
+		t.Error("Expected the preview page to offer file management")
+	}
+}
@@ -0,0 +1,88 @@
+package webserver
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/piotrszyma/goshare/internal/discovery"
+	"github.com/piotrszyma/goshare/internal/version"
+)
+
+// mdnsTXTRecords builds the DNS-SD TXT record advertising the server.
+// Everyone on the network can read it, so it must never contain the key.
+func mdnsTXTRecords(cfg Config) map[string]string {
+	caps := []string{"api", "upload"}
+	if cfg.SharePath != "" {
+		caps = append(caps, "share")
+	}
+	if cfg.WebDAV {
+		caps = append(caps, "webdav")
+	}
+
+	txt := map[string]string{
+		"version": version.Version,
+		"path":    "/",
+		"auth":    "key",
+	}
+	if cfg.SFTPAddr != "" {
+		caps = append(caps, "sftp")
+		if _, port, err := net.SplitHostPort(cfg.SFTPAddr); err == nil {
+			txt["sftp"] = port
+		}
+	}
+	if cfg.S3Addr != "" {
+		caps = append(caps, "s3")
+		if _, port, err := net.SplitHostPort(cfg.S3Addr); err == nil {
+			txt["s3"] = port
+		}
+	}
+	txt["caps"] = strings.Join(caps, ",")
+	return txt
+}
+
+// mdnsInstanceName returns the advertised instance name of the server
+func mdnsInstanceName(cfg Config) string {
+	if cfg.MDNSName != "" {
+		return cfg.MDNSName
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "GoShare"
+	}
+	return "GoShare on " + strings.TrimSuffix(hostname, ".local")
+}
+
+// startMDNS advertises the server on the local network and returns the function
+// withdrawing the announcement on shutdown. Failures are only logged and return
+// nil, the server stays reachable through the printed URL.
+func startMDNS(cfg Config, port int) func(context.Context) error {
+	name := mdnsInstanceName(cfg)
+
+	// Only announce on the chosen interface, if any
+	var ifaces []net.Interface
+	if cfg.Interface != "" {
+		iface, err := net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			log.Printf("Warning: Could not advertise the server over mDNS: %v", err)
+			return nil
+		}
+		ifaces = append(ifaces, *iface)
+	}
+
+	stop, err := discovery.Advertise(name, port, mdnsTXTRecords(cfg), ifaces)
+	if err != nil {
+		log.Printf("Warning: Could not advertise the server over mDNS: %v", err)
+		return nil
+	}
+	log.Printf("Advertising %q as %s on the local network", name, discovery.ServiceType)
+
+	// Withdrawing the announcement lets clients drop the server right away
+	// instead of showing it until the record expires
+	return func(context.Context) error {
+		stop()
+		return nil
+	}
+}
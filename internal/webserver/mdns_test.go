@@ -0,0 +1,43 @@
+package webserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestMDNSTXTRecords checks the advertised capabilities and that the key is never advertised
+func TestMDNSTXTRecords(t *testing.T) {
+	txt := mdnsTXTRecords(Config{SharePath: "shared", WebDAV: true, SFTPAddr: ":2022", S3Addr: "127.0.0.1:9000"})
+
+	if txt["caps"] != "api,upload,share,webdav,sftp,s3" {
+		t.Errorf("Expected all capabilities, got %q", txt["caps"])
+	}
+	if txt["sftp"] != "2022" || txt["s3"] != "9000" {
+		t.Errorf("Expected SFTP and S3 ports, got %q and %q", txt["sftp"], txt["s3"])
+	}
+	if txt["version"] == "" {
+		t.Error("Expected the version to be advertised")
+	}
+
+	for key, value := range txt {
+		if strings.Contains(key, secretKey) || strings.Contains(value, secretKey) {
+			t.Errorf("Expected the key not to be advertised, found it in %s=%s", key, value)
+		}
+	}
+}
+
+// TestStartMDNSShutdown tests that the announcement can be withdrawn and that failures leave nothing to stop
+func TestStartMDNSShutdown(t *testing.T) {
+	if shutdown := startMDNS(Config{Interface: "no-such-interface"}, 8080); shutdown != nil {
+		t.Error("Expected no shutdown function when advertising fails")
+	}
+
+	shutdown := startMDNS(Config{MDNSName: "GoShare test"}, 8080)
+	if shutdown == nil {
+		t.Skip("mDNS is not available here")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("Expected the announcement to be withdrawn, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package webserver
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// errOutsideMount is returned when a requested path does not belong to any mount
+var errOutsideMount = errors.New("path is outside of the shared tree")
+
+// mount is a named root of the virtual file tree exposed by the server
+type mount struct {
+	// Name is the first path segment under which the mount is exposed (e.g. "shared")
+	Name string
+	// Path is the location of the mount on the host filesystem, either a file or a directory
+	Path string
+	// Writable reports whether clients may store files in the mount
+	Writable bool
+}
+
+// getMounts returns the mounts for the shared path and the uploads directory
+func getMounts(sharePath, uploadsDir string) []mount {
+	var mounts []mount
+	// Standard input is streamed once and cannot be browsed like a mount
+	if sharePath != "" && sharePath != StdinSharePath {
+		mounts = append(mounts, mount{Name: "shared", Path: sharePath})
+	}
+	if uploadsDir != "" {
+		mounts = append(mounts, mount{Name: "uploads", Path: uploadsDir, Writable: true})
+	}
+	return mounts
+}
+
+// cleanVirtualPath normalizes a slash separated virtual path and strips leading and trailing slashes
+func cleanVirtualPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// splitVirtualPath splits a virtual path like "shared/docs/a.txt" into the mount name and the remainder
+func splitVirtualPath(p string) (string, string) {
+	p = cleanVirtualPath(p)
+	name, rest, _ := strings.Cut(p, "/")
+	return name, rest
+}
+
+// findMount looks up the mount with the given name
+func findMount(mounts []mount, name string) (mount, bool) {
+	for _, m := range mounts {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return mount{}, false
+}
+
+// resolve maps a path relative to the mount onto the host filesystem,
+// making sure the result cannot escape the mount
+func (m mount) resolve(rel string) (string, error) {
+	rel = cleanVirtualPath(rel)
+
+	info, err := os.Stat(m.Path)
+	if err == nil && !info.IsDir() {
+		// A single shared file is exposed under its own name only
+		if rel == "" || rel == info.Name() {
+			return m.Path, nil
+		}
+		return "", errOutsideMount
+	}
+
+	if rel == "" {
+		return m.Path, nil
+	}
+	return filepath.Join(m.Path, filepath.FromSlash(rel)), nil
+}
+
+// isFileMount reports whether the mount exposes a single file rather than a directory
+func (m mount) isFileMount() bool {
+	info, err := os.Stat(m.Path)
+	return err == nil && !info.IsDir()
+}
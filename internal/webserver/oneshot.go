@@ -0,0 +1,223 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInactivityTimeout is returned by Run when the server stopped because nobody used it
+var ErrInactivityTimeout = errors.New("stopped after a period without activity")
+
+// uploadObservers are called with the host path of every upload that has been stored completely
+var uploadObservers struct {
+	sync.Mutex
+	fns []func(hostPath string)
+}
+
+// observeUploads registers fn to be called after every finished upload
+func observeUploads(fn func(hostPath string)) {
+	uploadObservers.Lock()
+	defer uploadObservers.Unlock()
+	uploadObservers.fns = append(uploadObservers.fns, fn)
+}
+
+// notifyUploadFinished tells the observers that an upload has been stored completely
+func notifyUploadFinished(hostPath string) {
+	uploadObservers.Lock()
+	fns := append([]func(string){}, uploadObservers.fns...)
+	uploadObservers.Unlock()
+
+	for _, fn := range fns {
+		fn(hostPath)
+	}
+}
+
+// requestStop asks the server to shut down with the given result, unless a stop is already pending
+func requestStop(stop chan<- error, err error) {
+	select {
+	case stop <- err:
+	default:
+	}
+}
+
+// downloadWatcher reports when every shared file has been downloaded once.
+// The byte ranges served add up, so parallel and resumed downloads count too,
+// while fetching the same range again does not.
+type downloadWatcher struct {
+	mu        sync.Mutex
+	remaining map[string]*servedRanges
+	done      chan struct{}
+}
+
+// servedRanges keeps track of the parts of a file that were sent to clients
+type servedRanges struct {
+	size int64
+	// ranges are the sorted, non-overlapping [start, end) ranges sent so far
+	ranges [][2]int64
+}
+
+// add records that the bytes from start up to end were sent
+func (s *servedRanges) add(start, end int64) {
+	end = min(end, s.size)
+	if start >= end {
+		return
+	}
+	merged := make([][2]int64, 0, len(s.ranges)+1)
+	for _, r := range s.ranges {
+		if r[1] < start || r[0] > end {
+			merged = append(merged, r)
+			continue
+		}
+		start, end = min(start, r[0]), max(end, r[1])
+	}
+	merged = append(merged, [2]int64{start, end})
+	sort.Slice(merged, func(i, j int) bool { return merged[i][0] < merged[j][0] })
+	s.ranges = merged
+}
+
+// complete reports whether every byte of the file was sent
+func (s *servedRanges) complete() bool {
+	if s.size == 0 {
+		return true
+	}
+	return len(s.ranges) == 1 && s.ranges[0][0] == 0 && s.ranges[0][1] == s.size
+}
+
+// newDownloadWatcher collects the files below sharePath that have to be downloaded
+func newDownloadWatcher(sharePath string) (*downloadWatcher, error) {
+	info, err := os.Stat(sharePath)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &downloadWatcher{remaining: map[string]*servedRanges{}, done: make(chan struct{})}
+	if !info.IsDir() {
+		w.remaining[info.Name()] = &servedRanges{size: info.Size()}
+	} else {
+		err = filepath.WalkDir(sharePath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(sharePath, p)
+			w.remaining[filepath.ToSlash(rel)] = &servedRanges{size: info.Size()}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(w.remaining) == 0 {
+		return nil, fmt.Errorf("%s contains no files to serve", sharePath)
+	}
+	return w, nil
+}
+
+// sharedFile returns the shared file a download URL refers to, if any
+func sharedFile(urlPath string) (string, bool) {
+	urlPath = strings.TrimPrefix(urlPath, "/dav")
+	rel, ok := strings.CutPrefix(urlPath, "/shared/")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimPrefix(path.Clean("/"+rel), "/"), true
+}
+
+// middleware counts the bytes of shared files served to clients
+func (w *downloadWatcher) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		name, ok := sharedFile(r.URL.Path)
+		if !ok || r.Method != http.MethodGet {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		counter := &downloadResponseWriter{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(counter, r)
+		switch counter.status {
+		case http.StatusOK:
+			w.served(name, 0, counter.written)
+		case http.StatusPartialContent:
+			// Responses with several ranges are multipart and have no single
+			// Content-Range; clients asking for them are rare enough to ignore
+			var start, end int64
+			if _, err := fmt.Sscanf(counter.Header().Get("Content-Range"), "bytes %d-%d/", &start, &end); err == nil {
+				w.served(name, start, counter.written)
+			}
+		}
+	})
+}
+
+// served records that n bytes of a shared file, starting at offset start, were sent to a client
+func (w *downloadWatcher) served(name string, start, n int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ranges, ok := w.remaining[name]
+	if !ok {
+		return
+	}
+	ranges.add(start, start+n)
+	if !ranges.complete() {
+		return
+	}
+
+	delete(w.remaining, name)
+	log.Printf("Shared file %s was downloaded, %d left", name, len(w.remaining))
+	if len(w.remaining) == 0 {
+		close(w.done)
+	}
+}
+
+// downloadResponseWriter captures the status code and the number of bytes written
+type downloadResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (d *downloadResponseWriter) WriteHeader(code int) {
+	d.status = code
+	d.ResponseWriter.WriteHeader(code)
+}
+
+func (d *downloadResponseWriter) Write(p []byte) (int, error) {
+	n, err := d.ResponseWriter.Write(p)
+	d.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (d *downloadResponseWriter) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
+// watchInactivity requests a stop once no request has been served for timeout
+func watchInactivity(tracker *transferTracker, timeout time.Duration, stop chan<- error) {
+	// Very short timeouts would give a zero interval, which NewTicker rejects
+	interval := max(min(timeout/10, time.Second), time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if idle, ok := tracker.idleFor(); ok && idle >= timeout {
+			log.Printf("No activity for %s", timeout)
+			requestStop(stop, ErrInactivityTimeout)
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDownloadWatcher checks that the watcher fires after every shared file was served, also in ranges
+func TestDownloadWatcher(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("aaaa"), 0o644)
+	os.MkdirAll(filepath.Join(dir, "docs"), os.ModePerm)
+	os.WriteFile(filepath.Join(dir, "docs", "b.txt"), []byte("bbbbbbbb"), 0o644)
+
+	watcher, err := newDownloadWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := watcher.middleware(http.StripPrefix("/shared/", http.FileServer(http.Dir(dir))))
+
+	get := func(p, rangeHeader string) {
+		req := httptest.NewRequest(http.MethodGet, p, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	finished := func() bool {
+		select {
+		case <-watcher.done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	get("/shared/a.txt", "")
+	get("/shared/docs/b.txt", "bytes=0-3")
+	if finished() {
+		t.Fatal("Expected the watcher to wait for the rest of b.txt")
+	}
+
+	// Fetching the same part again does not make up for the rest
+	get("/shared/docs/b.txt", "bytes=0-3")
+	get("/shared/docs/b.txt", "bytes=2-5")
+	if finished() {
+		t.Fatal("Expected repeated ranges to be counted once")
+	}
+
+	get("/shared/docs/b.txt", "bytes=4-7")
+	if !finished() {
+		t.Error("Expected the watcher to fire after all files were downloaded")
+	}
+}
+
+// TestNewDownloadWatcherEmptyShare checks that an empty share is rejected
+func TestNewDownloadWatcherEmptyShare(t *testing.T) {
+	if _, err := newDownloadWatcher(t.TempDir()); err == nil {
+		t.Error("Expected an error for a share without files")
+	}
+}
+
+// TestSharedFile tests mapping of download URLs onto shared files
+func TestSharedFile(t *testing.T) {
+	tests := map[string]string{
+		"/shared/a.txt":         "a.txt",
+		"/dav/shared/docs/b.md": "docs/b.md",
+		"/shared/x/../a.txt":    "a.txt",
+	}
+	for urlPath, expected := range tests {
+		if name, ok := sharedFile(urlPath); !ok || name != expected {
+			t.Errorf("Expected %s for %s, got %q", expected, urlPath, name)
+		}
+	}
+	if _, ok := sharedFile("/uploads/a.txt"); ok {
+		t.Error("Expected uploads not to count as shared files")
+	}
+}
+
+// TestUploadObservers checks that finished uploads are reported
+func TestUploadObservers(t *testing.T) {
+	dir := t.TempDir()
+	received := make(chan string, 1)
+	observeUploads(func(hostPath string) {
+		if filepath.Dir(hostPath) == dir {
+			received <- hostPath
+		}
+	})
+
+	if _, err := saveUpload(dir, "a.txt", "admin@test", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case hostPath := <-received:
+		if filepath.Base(hostPath) != "a.txt" {
+			t.Errorf("Expected a.txt to be reported, got %s", hostPath)
+		}
+	default:
+		t.Error("Expected the upload to be reported")
+	}
+}
+
+// TestWatchInactivity checks that an idle server is stopped with ErrInactivityTimeout
+func TestWatchInactivity(t *testing.T) {
+	tracker := newTransferTracker()
+	stop := make(chan error, 1)
+	go watchInactivity(tracker, 50*time.Millisecond, stop)
+
+	select {
+	case err := <-stop:
+		if err != ErrInactivityTimeout {
+			t.Errorf("Expected ErrInactivityTimeout, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the idle server to be stopped")
+	}
+}
+
+// TestWatchInactivityTinyTimeout checks that timeouts too short for a tick interval still stop the server
+func TestWatchInactivityTinyTimeout(t *testing.T) {
+	tracker := newTransferTracker()
+	stop := make(chan error, 1)
+	go watchInactivity(tracker, time.Nanosecond, stop)
+
+	select {
+	case err := <-stop:
+		if err != ErrInactivityTimeout {
+			t.Errorf("Expected ErrInactivityTimeout, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the idle server to be stopped")
+	}
+}
+
+// TestTransferTrackerIgnoresEventStreams checks that open event streams do not keep the server from being idle
+func TestTransferTrackerIgnoresEventStreams(t *testing.T) {
+	tracker := newTransferTracker()
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	handler := tracker.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	for _, p := range []string{"/events", "/live/build.log"} {
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+		<-started
+	}
+	defer close(release)
+
+	if _, ok := tracker.idleFor(); !ok {
+		t.Error("Expected event streams not to count as running transfers")
+	}
+	if running := tracker.list(); len(running) != 0 {
+		t.Errorf("Expected no tracked transfers, got %v", running)
+	}
+}
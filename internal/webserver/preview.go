@@ -0,0 +1,293 @@
+package webserver
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+//go:embed templates/preview.html
+var previewHTML string
+
+// previewTemplate renders the preview page of a file or directory
+var previewTemplate = template.Must(template.New("preview.html").Parse(previewHTML))
+
+// previewMaxSize is the number of bytes of a text file shown in its preview
+const previewMaxSize = 1 << 20
+
+// previewCSP keeps previewed content from running scripts or loading anything
+// from other servers; PDFs are shown by the browser in a sandboxed frame
+const previewCSP = "default-src 'self'; script-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-src 'self'; object-src 'none'"
+
+// Kinds of previews
+const (
+	previewNone     = "none"
+	previewText     = "text"
+	previewMarkdown = "markdown"
+	previewImage    = "image"
+	previewPDF      = "pdf"
+	previewAudio    = "audio"
+	previewVideo    = "video"
+)
+
+// readmeNames are rendered below the entries of a directory, in order of preference
+var readmeNames = []string{"README.md", "readme.md", "Readme.md", "README.markdown", "README.txt", "README"}
+
+// markdown renders GitHub flavored Markdown. Raw HTML is left out and links
+// with dangerous schemes like javascript: are removed, so the output is safe
+// to embed into the page.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// highlighter renders source code with CSS classes and line numbers
+var highlighter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithLineNumbers(true))
+
+// highlightCSS styles the output of highlighter
+var highlightCSS = func() template.CSS {
+	var buf bytes.Buffer
+	highlighter.WriteCSS(&buf, styles.Get("github"))
+	return template.CSS(buf.String())
+}()
+
+// previewKind decides how a file is shown from its name and the first bytes of its content
+func previewKind(name string, head []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	mimeType := mimeTypeOf(name)
+	switch {
+	case ext == ".md" || ext == ".markdown":
+		return previewMarkdown
+	case isImageFile(name) || ext == ".svg":
+		return previewImage
+	case ext == ".pdf":
+		return previewPDF
+	case strings.HasPrefix(mimeType, "audio/"):
+		return previewAudio
+	case strings.HasPrefix(mimeType, "video/"):
+		return previewVideo
+	case isTextFile(name) || looksLikeText(head):
+		return previewText
+	}
+	return previewNone
+}
+
+// looksLikeText reports whether data is UTF-8 text without control characters
+// that only appear in binary files
+func looksLikeText(data []byte) bool {
+	if len(data) == 0 || bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	// The sample may end in the middle of a character
+	for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+	return utf8.Valid(data)
+}
+
+// highlightCode renders text as HTML with syntax highlighting chosen by the file name
+func highlightCode(name, text string) (template.HTML, error) {
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		lexer = lexers.Analyse(text)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := highlighter.Format(&buf, styles.Get("github"), iterator); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// renderMarkdown renders Markdown as sanitized HTML
+func renderMarkdown(source []byte) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert(source, &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// readHead reads up to n bytes of a file and reports whether there is more
+func readHead(hostPath string, n int64) ([]byte, bool, error) {
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, n+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > n {
+		return data[:n], true, nil
+	}
+	return data, false, nil
+}
+
+// previewData holds the data for the preview template
+type previewData struct {
+	Entry listEntry
+	// Parent is the preview page of the directory containing the entry
+	Parent string
+	// Base is the URL relative links in rendered Markdown point into
+	Base string
+	Kind string
+	// Content is highlighted text or rendered Markdown
+	Content   template.HTML
+	Truncated bool
+	CSS       template.CSS
+	// Entries and Readme are shown for directories
+	Entries []listEntry
+	Readme  template.HTML
+
+	Message     string
+	MessageType string
+	// Undo is the trash ID of an item that was just deleted
+	Undo string
+	// Manage shows the forms to change files; CanChange is false for the root of a mount
+	Manage    bool
+	CanChange bool
+	CSRF      string
+	// Back is the page the forms return to
+	Back string
+	// Folders are offered as destinations for moving the entry
+	Folders []string
+}
+
+// Lifetime returns how long the entry is kept, or an empty string if it is kept forever
+func (d previewData) Lifetime() string {
+	return formatLifetime(d.Entry.ExpiresAt)
+}
+
+// FormatSize returns a human-readable string representation of the size of the entry
+func (d previewData) FormatSize() string {
+	return formatSize(d.Entry.Size)
+}
+
+// renderPreview fills in the preview of a file
+func renderPreview(data *previewData, hostPath string) error {
+	head, truncated, err := readHead(hostPath, previewMaxSize)
+	if err != nil {
+		return err
+	}
+
+	data.Kind = previewKind(data.Entry.Name, head[:min(len(head), 512)])
+	switch data.Kind {
+	case previewText:
+		data.Truncated = truncated
+		data.Content, err = highlightCode(data.Entry.Name, strings.ToValidUTF8(string(head), "�"))
+	case previewMarkdown:
+		data.Truncated = truncated
+		data.Content, err = renderMarkdown(head)
+	}
+	return err
+}
+
+// previewHandler serves the preview pages of the files and directories in the mounts under /preview/
+func previewHandler(mounts []mount, fm *fileManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		p := cleanVirtualPath(strings.TrimPrefix(r.URL.Path, "/preview"))
+		result, err := listAll(mounts, p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := previewData{CSS: highlightCSS, Base: "/"}
+		if p != "" {
+			data.Base = "/" + p + "/"
+			data.Parent = "/preview/" + strings.TrimPrefix(path.Dir(p)+"/", "./")
+		}
+
+		if result.IsDir {
+			// Directories list their entries followed by their README, if any
+			data.Entry = listEntry{Name: path.Base("/" + p), Path: p, IsDir: true, URL: data.Base}
+			data.Entries = result.Entries
+			for _, name := range readmeNames {
+				hostPath, err := resolveVirtualFile(mounts, path.Join(p, name))
+				if err != nil || !hasEntry(result.Entries, name) {
+					continue
+				}
+				readme := previewData{Entry: listEntry{Name: name}}
+				if err := renderPreview(&readme, hostPath); err == nil {
+					data.Readme = readme.Content
+				}
+				break
+			}
+		} else {
+			data.Entry = result.Entries[0]
+			data.Base = "/" + path.Dir(p) + "/"
+			hostPath, err := resolveVirtualFile(mounts, p)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			// The checksum shows up once it is computed, e.g. on the next visit
+			if data.Entry.SHA256 == "" && !isPartialFile(hostPath) {
+				checksums.warm(hostPath)
+			}
+			if err := renderPreview(&data, hostPath); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Sessions that may change files get forms to do so
+		if role, _ := requestRole(r); canManage(role) && p != "" && fm.manages(p) {
+			data.Manage = true
+			data.CanChange = strings.Contains(p, "/")
+			data.CSRF = csrfToken(r)
+			data.Back = r.URL.Path
+			data.Folders = fm.folders()
+			data.Undo = r.URL.Query().Get("undo")
+		}
+		if message := r.URL.Query().Get("message"); message != "" {
+			data.Message = message
+			data.MessageType = r.URL.Query().Get("type")
+		}
+
+		w.Header().Set("Content-Security-Policy", previewCSP)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if err := previewTemplate.Execute(w, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// hasEntry reports whether entries contain a file with the given name
+func hasEntry(entries []listEntry, name string) bool {
+	for _, entry := range entries {
+		if entry.Name == name && !entry.IsDir {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPreviewKind tests choosing the preview of a file
+func TestPreviewKind(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"README.md", "# Title", previewMarkdown},
+		{"main.go", "package main", previewText},
+		{"Makefile", "all:\n\tgo build", previewText},
+		{"photo.JPG", "", previewImage},
+		{"manual.pdf", "%PDF-1.7", previewPDF},
+		{"song.mp3", "", previewAudio},
+		{"clip.mp4", "", previewVideo},
+		{"archive.bin", "\x00\x01\x02", previewNone},
+	}
+
+	for _, tt := range tests {
+		if got := previewKind(tt.name, []byte(tt.head)); got != tt.want {
+			t.Errorf("previewKind(%q): expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestLooksLikeText tests telling text from binary data
+func TestLooksLikeText(t *testing.T) {
+	if !looksLikeText([]byte("zażółć")[:7]) {
+		t.Error("Expected text cut in the middle of a character to be text")
+	}
+	if looksLikeText([]byte{0xff, 0xfe, 0x00, 0x41}) {
+		t.Error("Expected binary data not to be text")
+	}
+}
+
+// TestRenderMarkdownSanitizes tests that Markdown cannot inject scripts
+func TestRenderMarkdownSanitizes(t *testing.T) {
+	html, err := renderMarkdown([]byte("# Hi\n\n<script>alert(1)</script>\n\n[click](javascript:alert(1))\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "<h1>Hi</h1>") {
+		t.Errorf("Expected a heading, got %s", html)
+	}
+	if strings.Contains(string(html), "<script>") || strings.Contains(string(html), "javascript:") {
+		t.Errorf("Expected scripts to be removed, got %s", html)
+	}
+}
+
+// servePreview requests a preview page from mux
+func servePreview(mux *http.ServeMux, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestPreviewPages tests the preview pages of files and directories
+func TestPreviewPages(t *testing.T) {
+	sharedDir := t.TempDir()
+	os.WriteFile(filepath.Join(sharedDir, "README.md"), []byte("# Project\n\nSee [the code](main.go).\n"), 0o644)
+	os.WriteFile(filepath.Join(sharedDir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644)
+	os.WriteFile(filepath.Join(sharedDir, "talk.mp4"), []byte("video"), 0o644)
+	mux := newMux(Config{SharePath: sharedDir, UploadsDir: t.TempDir()})
+
+	// Directories list their files and show the README
+	rr := servePreview(mux, "/preview/shared/")
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, `href="/preview/shared/main.go"`) || !strings.Contains(body, "<h1>Project</h1>") {
+		t.Errorf("Unexpected directory page (%d): %s", rr.Code, body)
+	}
+	if !strings.Contains(body, `<base href="/shared/">`) {
+		t.Error("Expected relative links of the README to point into the shared directory")
+	}
+
+	// Code is highlighted and can be downloaded
+	rr = servePreview(mux, "/preview/shared/main.go")
+	body = rr.Body.String()
+	if !strings.Contains(body, `class="chroma"`) || !strings.Contains(body, `href="/shared/main.go" class="button" download`) {
+		t.Errorf("Expected highlighted code and a download button: %s", body)
+	}
+	if !strings.Contains(rr.Header().Get("Content-Security-Policy"), "script-src 'none'") {
+		t.Error("Expected a content security policy forbidding scripts")
+	}
+
+	// Videos are played by the browser
+	rr = servePreview(mux, "/preview/shared/talk.mp4")
+	if !strings.Contains(rr.Body.String(), `<video class="media" src="/shared/talk.mp4" controls`) {
+		t.Error("Expected a video player")
+	}
+
+	if rr = servePreview(mux, "/preview/shared/missing.txt"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
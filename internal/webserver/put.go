@@ -0,0 +1,69 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// putUploadResult is the JSON response body of a raw PUT upload
+type putUploadResult struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	URL    string `json:"url"`
+	// Duplicate is set when the same content was already uploaded under Name and nothing was stored
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// handlePutUpload stores the raw request body of PUT /uploads/<name> as a new file.
+// The name goes through the same sanitization and conflict handling as form uploads,
+// so the stored name is reported back to the client.
+func handlePutUpload(w http.ResponseWriter, r *http.Request, uploadsDir string) {
+	name := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	if name == "" {
+		http.Error(w, "Missing file name in URL", http.StatusBadRequest)
+		return
+	}
+
+	if err := uploadQuota.check(sessionName(r), r.ContentLength); err != nil {
+		http.Error(w, "Error: "+err.Error(), uploadErrorStatus(err))
+		return
+	}
+	expected, err := expectedChecksums(r, 1)
+	if err != nil {
+		http.Error(w, "Error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := saveVerifiedUpload(uploadsDir, name, sessionName(r), expected[0], r.Body)
+	if err != nil {
+		http.Error(w, "Error: "+err.Error(), uploadErrorStatus(err))
+		return
+	}
+
+	result := putUploadResult{
+		Name:   stored.Name,
+		Size:   stored.Size,
+		SHA256: stored.SHA256,
+		URL:    "/uploads/" + url.PathEscape(stored.Name),
+
+		Duplicate: stored.Duplicate,
+	}
+	w.Header().Set("Location", result.URL)
+
+	// A re-upload of a file that is already there creates nothing
+	status := http.StatusCreated
+	if result.Duplicate {
+		status = http.StatusOK
+	}
+
+	// Scripts asking for JSON get a structured response, everything else a sha256sum style line
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, status, result)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s  %s\n", result.SHA256, result.Name)
+}
@@ -0,0 +1,122 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestPutUpload tests storing a raw request body with Basic auth
+func TestPutUpload(t *testing.T) {
+	uploadsDir := t.TempDir()
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	req := httptest.NewRequest("PUT", "/uploads/hello.txt", strings.NewReader("hello"))
+	req.SetBasicAuth("", secretKey)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  hello.txt\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected body %q, got %q", expected, rr.Body.String())
+	}
+
+	content, err := os.ReadFile(filepath.Join(uploadsDir, "hello.txt"))
+	if err != nil || string(content) != "hello" {
+		t.Errorf("Expected the file to be stored, got %q (%v)", content, err)
+	}
+}
+
+// TestPutUploadJSON tests the JSON response and conflict handling of raw uploads
+func TestPutUploadJSON(t *testing.T) {
+	uploadsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(uploadsDir, "hello.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	req := httptest.NewRequest("PUT", "/uploads/hello.txt", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	var result putUploadResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "hello.0.txt" || result.Size != 5 || rr.Header().Get("Location") != "/uploads/hello.0.txt" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+// TestPutUploadRequiresKey tests that raw uploads are rejected without a key
+func TestPutUploadRequiresKey(t *testing.T) {
+	uploadsDir := t.TempDir()
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	req := httptest.NewRequest("PUT", "/uploads/hello.txt", strings.NewReader("hello"))
+	req.SetBasicAuth("", "wrong")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "hello.txt")); !os.IsNotExist(err) {
+		t.Error("Expected no file to be stored")
+	}
+}
+
+// TestSanitizeFilename tests stripping directories and control characters from names
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":          "report.pdf",
+		"../../etc/passwd":    "passwd",
+		`..\..\windows\x.ini`: "x.ini",
+		"evil\x1b[2Jname.txt": "evil[2Jname.txt",
+		"..":                  "upload",
+		"":                    "upload",
+	}
+	for input, expected := range tests {
+		if got := sanitizeFilename(input); got != expected {
+			t.Errorf("sanitizeFilename(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+// TestSaveUploadConcurrentNames tests that uploads of the same name at the same time never share a file
+func TestSaveUploadConcurrentNames(t *testing.T) {
+	uploadsDir := t.TempDir()
+
+	var wg sync.WaitGroup
+	names := make([]string, 20)
+	for i := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stored, err := saveUpload(uploadsDir, "photo.jpg", "admin@test", strings.NewReader("photo"))
+			if err != nil {
+				t.Error(err)
+			}
+			names[i] = stored.Name
+		}()
+	}
+	wg.Wait()
+
+	unique := map[string]bool{}
+	for _, name := range names {
+		unique[name] = true
+	}
+	if entries, _ := os.ReadDir(uploadsDir); len(unique) != len(names) || len(entries) != len(names) {
+		t.Errorf("Expected %d distinct files, got names %v and %d files", len(names), names, len(entries))
+	}
+}
@@ -0,0 +1,424 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minDiskFree is the space uploads always leave free on the disk of the uploads directory
+const minDiskFree = 100 << 20
+
+// quotaRecountInterval bounds how long the usage of the uploads directory is
+// kept up to date in memory; files changed outside of goshare are picked up
+// after at most this long
+const quotaRecountInterval = time.Minute
+
+// uploadQuota limits the uploads of the running server, nil if they are not limited
+var uploadQuota *quotaPolicy
+
+var (
+	// errQuotaExceeded is returned for uploads that do not fit into a quota
+	errQuotaExceeded = errors.New("upload quota exceeded")
+	// errDiskFull is returned for uploads that do not fit onto the disk
+	errDiskFull = errors.New("not enough free disk space")
+)
+
+// quotaUsage counts the bytes and files of uploads
+type quotaUsage struct {
+	Bytes int64
+	Files int
+}
+
+// quotaStatus tells a session how much it may still upload
+type quotaStatus struct {
+	// BytesLeft and FilesLeft are what the session may still upload, -1 if unlimited
+	BytesLeft int64 `json:"bytes_left"`
+	FilesLeft int   `json:"files_left"`
+	// DiskFree is the free space on the disk of the uploads directory, -1 if unknown
+	DiskFree int64 `json:"disk_free"`
+}
+
+// quotaPolicy limits the bytes and files in the uploads directory, overall and
+// per session, and keeps uploads from filling the disk
+type quotaPolicy struct {
+	uploadsDir string
+	// total limits all uploads, session the uploads of every session; 0 means unlimited
+	total, session quotaUsage
+
+	mu sync.Mutex
+	// owners maps the virtual paths of uploads to the sessions that uploaded them,
+	// kept in the state directory so quotas survive restarts
+	owners     map[string]string
+	ownersPath string
+	// writing counts the uploads in progress by session, "" counts all of them
+	writing map[string]quotaUsage
+	// sizes are the sizes of the finished uploads by virtual path and used adds
+	// them up by session like writing. Both are counted from disk once and then
+	// kept up to date as uploads finish; sizes is nil when they must be counted again.
+	sizes   map[string]int64
+	used    map[string]quotaUsage
+	counted time.Time
+}
+
+// newQuotaPolicy returns the quotas configured in cfg; free disk space is always checked
+func newQuotaPolicy(cfg Config) *quotaPolicy {
+	q := &quotaPolicy{
+		uploadsDir: cfg.UploadsDir,
+		total:      quotaUsage{Bytes: cfg.QuotaBytes, Files: cfg.QuotaFiles},
+		session:    quotaUsage{Bytes: cfg.SessionQuotaBytes, Files: cfg.SessionQuotaFiles},
+		owners:     map[string]string{},
+		ownersPath: filepath.Join(stateDir(cfg.UploadsDir), "owners.json"),
+		writing:    map[string]quotaUsage{},
+	}
+	if data, err := os.ReadFile(q.ownersPath); err == nil {
+		if err := json.Unmarshal(data, &q.owners); err != nil {
+			log.Printf("Error reading %s: %v", q.ownersPath, err)
+		}
+	}
+	return q
+}
+
+// virtualPath returns the virtual path of a file in the uploads directory
+func (q *quotaPolicy) virtualPath(hostPath string) string {
+	root, _ := filepath.Abs(q.uploadsDir)
+	abs, _ := filepath.Abs(hostPath)
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return ""
+	}
+	return path.Join("uploads", filepath.ToSlash(rel))
+}
+
+// limited reports whether any quota is set; free disk space is checked either way
+func (q *quotaPolicy) limited() bool {
+	return q.total != (quotaUsage{}) || q.session != (quotaUsage{})
+}
+
+// usageLocked returns the finished uploads of everybody and of session; q.mu must be held
+func (q *quotaPolicy) usageLocked(session string) (total, own quotaUsage) {
+	if q.sizes == nil || time.Since(q.counted) > quotaRecountInterval {
+		q.countLocked()
+	}
+	return q.used[""], q.used[session]
+}
+
+// countLocked counts the finished uploads from disk; q.mu must be held
+func (q *quotaPolicy) countLocked() {
+	q.sizes, q.used, q.counted = map[string]int64{}, map[string]quotaUsage{}, time.Now()
+	filepath.WalkDir(q.uploadsDir, func(hostPath string, d fs.DirEntry, err error) error {
+		// Uploads in progress are counted in q.writing
+		if err != nil || d.IsDir() || isPartialFile(hostPath) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		q.setSizeLocked(q.virtualPath(hostPath), info.Size())
+		return nil
+	})
+}
+
+// setSizeLocked records the size of the finished upload at the virtual path p,
+// replacing what was counted for it before; q.mu must be held
+func (q *quotaPolicy) setSizeLocked(p string, size int64) {
+	owner := q.owners[p]
+	if old, ok := q.sizes[p]; ok {
+		addUsage(q.used, owner, quotaUsage{Bytes: -old, Files: -1})
+	}
+	q.sizes[p] = size
+	addUsage(q.used, owner, quotaUsage{Bytes: size, Files: 1})
+}
+
+// changed makes the next check count the uploads from disk again, after files
+// were removed, moved or restored
+func (q *quotaPolicy) changed() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.sizes = nil
+	q.mu.Unlock()
+}
+
+// exceeds checks usage against limit, describing which quota would be exceeded
+func exceeds(usage, limit quotaUsage, whose string) error {
+	switch {
+	case limit.Files > 0 && usage.Files > limit.Files:
+		return fmt.Errorf("%w: %s at most %s", errQuotaExceeded, whose, countFiles(limit.Files))
+	case limit.Bytes > 0 && usage.Bytes > limit.Bytes:
+		return fmt.Errorf("%w: %s at most %s", errQuotaExceeded, whose, formatSize(limit.Bytes))
+	}
+	return nil
+}
+
+// checkLocked reports whether adding more to the uploads of session stays within the quotas; q.mu must be held
+func (q *quotaPolicy) checkLocked(session string, total, own, more quotaUsage) error {
+	all, mine := q.writing[""], q.writing[session]
+	total = quotaUsage{total.Bytes + all.Bytes + more.Bytes, total.Files + all.Files + more.Files}
+	own = quotaUsage{own.Bytes + mine.Bytes + more.Bytes, own.Files + mine.Files + more.Files}
+	if err := exceeds(total, q.total, "the server accepts"); err != nil {
+		return err
+	}
+	return exceeds(own, q.session, "every device may upload")
+}
+
+// checkDisk reports whether size more bytes fit onto the disk
+func (q *quotaPolicy) checkDisk(size int64) error {
+	free, ok := diskFree(q.uploadsDir)
+	if ok && free-size < minDiskFree {
+		return fmt.Errorf("%w: only %s are left on the server", errDiskFull, formatSize(max(free-minDiskFree, 0)))
+	}
+	return nil
+}
+
+// check reports whether session may start uploading files with size bytes in
+// total, -1 if the size is not known yet. The limits are enforced again while
+// the files are written, so a wrong size cannot get around them.
+func (q *quotaPolicy) check(session string, size int64) error {
+	if q == nil {
+		return nil
+	}
+	if err := q.checkDisk(max(size, 0)); err != nil {
+		return err
+	}
+	if !q.limited() {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	total, own := q.usageLocked(session)
+	return q.checkLocked(session, total, own, quotaUsage{Bytes: max(size, 0), Files: 1})
+}
+
+// status returns how much session may still upload
+func (q *quotaPolicy) status(session string) quotaStatus {
+	status := quotaStatus{BytesLeft: -1, FilesLeft: -1, DiskFree: -1}
+	if q == nil {
+		return status
+	}
+	if free, ok := diskFree(q.uploadsDir); ok {
+		status.DiskFree = free
+	}
+	if !q.limited() {
+		return status
+	}
+
+	q.mu.Lock()
+	total, own := q.usageLocked(session)
+	all, mine := q.writing[""], q.writing[session]
+	q.mu.Unlock()
+
+	// The tighter of the overall and the session quota counts
+	left := func(limit, used int64) {
+		if limit > 0 && (status.BytesLeft < 0 || limit-used < status.BytesLeft) {
+			status.BytesLeft = max(limit-used, 0)
+		}
+	}
+	left(q.total.Bytes, total.Bytes+all.Bytes)
+	left(q.session.Bytes, own.Bytes+mine.Bytes)
+	leftFiles := func(limit, used int) {
+		if limit > 0 && (status.FilesLeft < 0 || limit-used < status.FilesLeft) {
+			status.FilesLeft = max(limit-used, 0)
+		}
+	}
+	leftFiles(q.total.Files, total.Files+all.Files)
+	leftFiles(q.session.Files, own.Files+mine.Files)
+	return status
+}
+
+// countFiles returns e.g. "1 file" or "20 files"
+func countFiles(n int) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", n)
+}
+
+// describe sums up the status of session for the page, e.g. "1.5 GB and 20 files left, 80.2 GB free on disk"
+func (s quotaStatus) describe() string {
+	var parts []string
+	switch {
+	case s.BytesLeft >= 0 && s.FilesLeft >= 0:
+		parts = append(parts, fmt.Sprintf("%s and %s left", formatSize(s.BytesLeft), countFiles(s.FilesLeft)))
+	case s.BytesLeft >= 0:
+		parts = append(parts, formatSize(s.BytesLeft)+" left")
+	case s.FilesLeft >= 0:
+		parts = append(parts, countFiles(s.FilesLeft)+" left")
+	}
+	if s.DiskFree >= 0 {
+		parts = append(parts, formatSize(s.DiskFree)+" free on disk")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// quotaWriter counts the bytes of an upload in progress against the quotas
+type quotaWriter struct {
+	q       *quotaPolicy
+	session string
+	// total and own are the finished uploads when the upload started
+	total, own quotaUsage
+	// disk is the number of bytes that fit onto the disk when the upload started
+	disk    int64
+	written int64
+	// files is 1 for a new file and 0 when an existing file is written to
+	files int
+	// size is the size of the file written to as far as it was counted, see grow
+	size int64
+}
+
+// start registers an upload by session that is about to be written
+func (q *quotaPolicy) start(session string) (*quotaWriter, error) {
+	return q.startFile(session, "")
+}
+
+// startFile registers a write by session to the file at hostPath, which may
+// exist already; an empty hostPath is a new file
+func (q *quotaPolicy) startFile(session, hostPath string) (*quotaWriter, error) {
+	if q == nil {
+		return nil, nil
+	}
+	w := &quotaWriter{q: q, session: session, disk: -1, files: 1}
+	if free, ok := diskFree(q.uploadsDir); ok {
+		w.disk = free - minDiskFree
+	}
+	if hostPath != "" {
+		if info, err := os.Stat(hostPath); err == nil {
+			w.files, w.size = 0, info.Size()
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limited() {
+		w.total, w.own = q.usageLocked(session)
+	}
+	if err := q.checkLocked(session, w.total, w.own, quotaUsage{Files: w.files}); err != nil {
+		return nil, err
+	}
+	q.addLocked(session, quotaUsage{Files: w.files})
+	return w, nil
+}
+
+// addUsage adds usage to what session and everybody, counted under "", use in
+// m; files without a known session only count for everybody
+func addUsage(m map[string]quotaUsage, session string, usage quotaUsage) {
+	keys := []string{""}
+	if session != "" {
+		keys = append(keys, session)
+	}
+	for _, key := range keys {
+		current := m[key]
+		current.Bytes += usage.Bytes
+		current.Files += usage.Files
+		if current == (quotaUsage{}) {
+			delete(m, key)
+		} else {
+			m[key] = current
+		}
+	}
+}
+
+// addLocked counts usage as being written by session; q.mu must be held
+func (q *quotaPolicy) addLocked(session string, usage quotaUsage) {
+	addUsage(q.writing, session, usage)
+}
+
+// Write counts p against the quotas, failing once one of them is exceeded
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	if err := w.add(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// add counts n more bytes against the quotas
+func (w *quotaWriter) add(n int64) error {
+	q := w.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.addLocked(w.session, quotaUsage{Bytes: n})
+	w.written += n
+	if w.disk >= 0 && w.written > w.disk {
+		return fmt.Errorf("%w on the server", errDiskFull)
+	}
+	return q.checkLocked(w.session, w.total, w.own, quotaUsage{})
+}
+
+// grow counts a write to the file that ends at offset end; only what makes the
+// file larger is counted, overwriting its content takes no more space
+func (w *quotaWriter) grow(end int64) error {
+	if w == nil || end <= w.size {
+		return nil
+	}
+	n := end - w.size
+	w.size = end
+	return w.add(n)
+}
+
+// finish unregisters the upload and, if it was stored at hostPath, remembers who uploaded it
+func (w *quotaWriter) finish(hostPath string) {
+	if w == nil {
+		return
+	}
+	q := w.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.addLocked(w.session, quotaUsage{Bytes: -w.written, Files: -w.files})
+	if hostPath == "" {
+		return
+	}
+
+	// Count the finished file right away instead of counting everything from
+	// disk again; a file that was written to before now belongs to session
+	p := q.virtualPath(hostPath)
+	if old, ok := q.sizes[p]; ok {
+		addUsage(q.used, q.owners[p], quotaUsage{Bytes: -old, Files: -1})
+		delete(q.sizes, p)
+	}
+	q.owners[p] = w.session
+	if info, err := os.Stat(hostPath); err == nil && q.sizes != nil {
+		q.setSizeLocked(p, info.Size())
+	}
+	data, _ := json.Marshal(q.owners)
+	if err := os.MkdirAll(filepath.Dir(q.ownersPath), 0o700); err != nil {
+		log.Printf("Error saving upload owners: %v", err)
+		return
+	}
+	if err := os.WriteFile(q.ownersPath, data, 0o600); err != nil {
+		log.Printf("Error saving upload owners: %v", err)
+	}
+}
+
+// writer returns the writer to count written bytes with, io.Discard if there are no quotas
+func (w *quotaWriter) writer() io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
+
+// uploadErrorStatus returns the HTTP status code for an error storing an upload
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errDiskFull):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, errChecksumMismatch):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
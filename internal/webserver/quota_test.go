@@ -0,0 +1,188 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestSessionQuota tests that every session can only upload up to its quota
+func TestSessionQuota(t *testing.T) {
+	uploadsDir := t.TempDir()
+	uploadQuota = newQuotaPolicy(Config{UploadsDir: uploadsDir, SessionQuotaBytes: 10, SessionQuotaFiles: 2})
+	defer func() { uploadQuota = nil }()
+
+	if _, err := saveUpload(uploadsDir, "a.txt", "uploader@10.0.0.1", strings.NewReader("123456")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second file does not fit into what is left
+	_, err := saveUpload(uploadsDir, "b.txt", "uploader@10.0.0.1", strings.NewReader("123456"))
+	if !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("Expected errQuotaExceeded, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "b.txt")); !os.IsNotExist(err) {
+		t.Error("Expected the partial upload to be removed")
+	}
+
+	// Other devices have their own quota
+	if _, err := saveUpload(uploadsDir, "c.txt", "uploader@10.0.0.2", strings.NewReader("123456")); err != nil {
+		t.Errorf("Expected another device to upload, got %v", err)
+	}
+
+	status := uploadQuota.status("uploader@10.0.0.1")
+	if status.BytesLeft != 4 || status.FilesLeft != 1 {
+		t.Errorf("Expected 4 bytes and 1 file left, got %+v", status)
+	}
+	if !strings.HasPrefix(status.describe(), "4 bytes and 1 file left") {
+		t.Errorf("Unexpected quota description %q", status.describe())
+	}
+
+	// Owners are remembered across restarts
+	restarted := newQuotaPolicy(Config{UploadsDir: uploadsDir, SessionQuotaBytes: 10})
+	if err := restarted.check("uploader@10.0.0.1", 5); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("Expected errQuotaExceeded after a restart, got %v", err)
+	}
+}
+
+// TestTotalQuotaAPI tests that the API refuses uploads beyond the overall quota with a clear error
+func TestTotalQuotaAPI(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "existing.txt"), []byte("existing"), 0o644)
+	uploadQuota = newQuotaPolicy(Config{UploadsDir: uploadsDir, QuotaFiles: 1})
+	defer func() { uploadQuota = nil }()
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	req := httptest.NewRequest("PUT", "/uploads/new.txt", strings.NewReader("new"))
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "at most 1 file") {
+		t.Errorf("Expected status code %d with the limit, got %d: %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body.String())
+	}
+
+	// The info endpoint tells clients how much is left
+	rr = serveAPI(mux, httptest.NewRequest("GET", "/api/v1/info", nil))
+	var info serverInfo
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Quota.FilesLeft != 0 || info.Quota.BytesLeft != -1 {
+		t.Errorf("Unexpected quota status %+v", info.Quota)
+	}
+}
+
+// TestDiskFreeCheck tests that uploads larger than the free disk space are refused up front
+func TestDiskFreeCheck(t *testing.T) {
+	uploadsDir := t.TempDir()
+	free, ok := diskFree(uploadsDir)
+	if !ok {
+		t.Skip("free disk space is not known on this platform")
+	}
+	q := newQuotaPolicy(Config{UploadsDir: uploadsDir})
+
+	if err := q.check("admin@test", free); !errors.Is(err, errDiskFull) {
+		t.Errorf("Expected errDiskFull, got %v", err)
+	}
+	if err := q.check("admin@test", 1); err != nil {
+		t.Errorf("Expected a small upload to fit, got %v", err)
+	}
+}
+
+// TestQuotaOtherProtocols tests that WebDAV, SFTP and S3 uploads cannot get around the quotas
+func TestQuotaOtherProtocols(t *testing.T) {
+	uploadsDir := t.TempDir()
+	uploadQuota = newQuotaPolicy(Config{UploadsDir: uploadsDir, QuotaBytes: 10})
+	defer func() { uploadQuota = nil }()
+	tooLarge := strings.Repeat("x", 20)
+
+	// WebDAV refuses uploads that are announced too large and stops the others while they are written
+	dav := newDAVTestServer(t, "", uploadsDir, secretKey)
+	if err := dav.Write("/uploads/dav.txt", []byte(tooLarge), 0o644); err == nil {
+		t.Error("Expected a too large WebDAV upload to fail")
+	}
+	mux := newMux(Config{UploadsDir: uploadsDir, WebDAV: true})
+	req := httptest.NewRequest("PUT", "/dav/uploads/stream.txt", strings.NewReader(tooLarge))
+	req.ContentLength = -1
+	if rr := serveAPI(mux, req); rr.Code < 400 {
+		t.Errorf("Expected a streamed WebDAV upload beyond the quota to fail, got %d", rr.Code)
+	}
+	if err := dav.Write("/uploads/dav.txt", []byte("dav"), 0o644); err != nil {
+		t.Errorf("Expected a WebDAV upload within the quota, got %v", err)
+	}
+
+	// SFTP removes a new file that does not fit
+	client, err := dialSFTP(t, startSFTPTestServer(t, Config{UploadsDir: uploadsDir}), ssh.Password(secretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := client.Create("/uploads/sftp.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, writeErr := f.Write([]byte(tooLarge))
+	f.Close()
+	if writeErr == nil {
+		t.Error("Expected a too large SFTP upload to fail")
+	}
+
+	// S3 answers with an S3 error
+	accessKeyID, secretAccessKey := s3Credentials()
+	s3Client := newS3TestClient(t, Config{UploadsDir: uploadsDir}, accessKeyID, secretAccessKey)
+	_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String("uploads"), Key: aws.String("s3.txt"), Body: strings.NewReader(tooLarge)})
+	if err == nil || !strings.Contains(err.Error(), "EntityTooLarge") {
+		t.Errorf("Expected EntityTooLarge for a too large S3 upload, got %v", err)
+	}
+
+	for _, name := range []string{"stream.txt", "sftp.txt", "s3.txt"} {
+		if _, err := os.Stat(filepath.Join(uploadsDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected no trace of the refused upload %s", name)
+		}
+	}
+	if status := uploadQuota.status("admin@127.0.0.1"); status.BytesLeft != 7 {
+		t.Errorf("Expected 7 bytes left, got %+v", status)
+	}
+}
+
+// TestQuotaUsageKeptUpToDate tests that the usage is counted from disk once and then kept up to date
+func TestQuotaUsageKeptUpToDate(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "old.txt"), []byte("old"), 0o644)
+
+	// Without quotas nothing has to be counted
+	unlimited := newQuotaPolicy(Config{UploadsDir: uploadsDir})
+	unlimited.status("uploader@10.0.0.1")
+	if unlimited.sizes != nil {
+		t.Error("Expected no usage to be counted without quotas")
+	}
+
+	uploadQuota = newQuotaPolicy(Config{UploadsDir: uploadsDir, QuotaBytes: 100})
+	defer func() { uploadQuota = nil }()
+	if status := uploadQuota.status("uploader@10.0.0.1"); status.BytesLeft != 97 {
+		t.Errorf("Expected 97 bytes left, got %+v", status)
+	}
+
+	// Finished uploads are added without counting everything again
+	os.WriteFile(filepath.Join(uploadsDir, "outside.txt"), []byte("written outside of goshare"), 0o644)
+	saveUpload(uploadsDir, "new.txt", "uploader@10.0.0.1", strings.NewReader("new content"))
+	if status := uploadQuota.status("uploader@10.0.0.1"); status.BytesLeft != 86 {
+		t.Errorf("Expected 86 bytes left, got %+v", status)
+	}
+
+	// Changes are counted from disk again
+	os.Remove(filepath.Join(uploadsDir, "old.txt"))
+	uploadQuota.changed()
+	if status := uploadQuota.status("uploader@10.0.0.1"); status.BytesLeft != 63 {
+		t.Errorf("Expected 63 bytes left, got %+v", status)
+	}
+}
@@ -0,0 +1,253 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retentionInterval is how often the janitor enforces the retention rules
+const retentionInterval = time.Minute
+
+// uploadRetention is the retention policy of the running server, nil if uploads are kept forever
+var uploadRetention *retentionPolicy
+
+// retentionPolicy removes uploads that are too old, were downloaded long
+// enough ago or do not fit into the size limit anymore
+type retentionPolicy struct {
+	uploadsDir string
+	// maxAge removes uploads this long after they were stored
+	maxAge time.Duration
+	// maxSize evicts the oldest uploads while all uploads together are larger
+	maxSize int64
+	// afterDownload removes uploads this long after their first download
+	afterDownload time.Duration
+	audit         *auditLog
+
+	mu sync.Mutex
+	// downloads maps the virtual paths of uploads to their first download,
+	// kept in the state directory so expiry survives restarts
+	downloads     map[string]time.Time
+	downloadsPath string
+}
+
+// newRetentionPolicy returns the retention policy configured in cfg, or nil if there is none
+func newRetentionPolicy(cfg Config) *retentionPolicy {
+	if cfg.MaxUploadAge <= 0 && cfg.MaxUploadsSize <= 0 && cfg.ExpireAfterDownload <= 0 {
+		return nil
+	}
+	p := &retentionPolicy{
+		uploadsDir:    cfg.UploadsDir,
+		maxAge:        cfg.MaxUploadAge,
+		maxSize:       cfg.MaxUploadsSize,
+		afterDownload: cfg.ExpireAfterDownload,
+		audit:         newAuditLog(cfg.UploadsDir),
+		downloads:     map[string]time.Time{},
+		downloadsPath: filepath.Join(stateDir(cfg.UploadsDir), "downloads.json"),
+	}
+	if data, err := os.ReadFile(p.downloadsPath); err == nil {
+		if err := json.Unmarshal(data, &p.downloads); err != nil {
+			log.Printf("Error reading %s: %v", p.downloadsPath, err)
+		}
+	}
+	return p
+}
+
+// expiresAt returns when the upload at the virtual path p is removed by the
+// age and download rules, or the zero time if they never remove it
+func (p *retentionPolicy) expiresAt(virtualPath string, info os.FileInfo) time.Time {
+	if p == nil || info.IsDir() || !strings.HasPrefix(virtualPath, "uploads/") {
+		return time.Time{}
+	}
+	var expires time.Time
+	if p.maxAge > 0 {
+		expires = uploadedAt(virtualPath, info).Add(p.maxAge)
+	}
+	if p.afterDownload > 0 {
+		p.mu.Lock()
+		first, ok := p.downloads[virtualPath]
+		p.mu.Unlock()
+		if ok && (expires.IsZero() || first.Add(p.afterDownload).Before(expires)) {
+			expires = first.Add(p.afterDownload)
+		}
+	}
+	return expires.UTC()
+}
+
+// uploadedAt returns when the upload at the virtual path p was stored. With
+// deduplication its modification time is when the content was uploaded first.
+func uploadedAt(virtualPath string, info os.FileInfo) time.Time {
+	if stored, ok := uploadBlobs.storedAt(virtualPath); ok {
+		return stored
+	}
+	return info.ModTime()
+}
+
+// downloaded records that the upload at the virtual path p was downloaded
+func (p *retentionPolicy) downloaded(virtualPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.downloads[virtualPath]; ok {
+		return
+	}
+	p.downloads[virtualPath] = time.Now().UTC()
+	p.saveLocked()
+}
+
+// saveLocked writes the first downloads to the state directory; p.mu must be held
+func (p *retentionPolicy) saveLocked() {
+	data, _ := json.Marshal(p.downloads)
+	if err := os.MkdirAll(filepath.Dir(p.downloadsPath), 0o700); err != nil {
+		log.Printf("Error saving downloads: %v", err)
+		return
+	}
+	if err := os.WriteFile(p.downloadsPath, data, 0o600); err != nil {
+		log.Printf("Error saving downloads: %v", err)
+	}
+}
+
+// uploadFile returns the virtual path of the upload a download URL refers to, if any
+func uploadFile(urlPath string) (string, bool) {
+	urlPath = strings.TrimPrefix(urlPath, "/dav")
+	rel, ok := strings.CutPrefix(urlPath, "/uploads/")
+	if !ok {
+		return "", false
+	}
+	return path.Join("uploads", path.Clean("/"+rel)), true
+}
+
+// middleware records the first download of every upload
+func (p *retentionPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		virtualPath, ok := uploadFile(r.URL.Path)
+		if !ok || r.Method != http.MethodGet {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		counter := &downloadResponseWriter{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(counter, r)
+		if (counter.status == http.StatusOK || counter.status == http.StatusPartialContent) && counter.written > 0 {
+			p.downloaded(virtualPath)
+		}
+	})
+}
+
+// storedUploadFile is an upload considered by the janitor
+type storedUploadFile struct {
+	virtualPath string
+	hostPath    string
+	info        os.FileInfo
+}
+
+// enforce removes the uploads that break a retention rule and returns how many it removed
+func (p *retentionPolicy) enforce(now time.Time) int {
+	var files []storedUploadFile
+	var total int64
+	filepath.WalkDir(p.uploadsDir, func(hostPath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isPartialFile(hostPath) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(p.uploadsDir, hostPath)
+		files = append(files, storedUploadFile{path.Join("uploads", filepath.ToSlash(rel)), hostPath, info})
+		total += info.Size()
+		return nil
+	})
+
+	removed := 0
+	var kept []storedUploadFile
+	for _, f := range files {
+		expires := p.expiresAt(f.virtualPath, f.info)
+		if expires.IsZero() || now.Before(expires) {
+			kept = append(kept, f)
+			continue
+		}
+		if p.remove(f, "expired") {
+			removed++
+			total -= f.info.Size()
+		}
+	}
+
+	// Evict the oldest uploads until the rest fits
+	if p.maxSize > 0 && total > p.maxSize {
+		sort.Slice(kept, func(i, j int) bool {
+			return uploadedAt(kept[i].virtualPath, kept[i].info).Before(uploadedAt(kept[j].virtualPath, kept[j].info))
+		})
+		for _, f := range kept {
+			if total <= p.maxSize {
+				break
+			}
+			if p.remove(f, fmt.Sprintf("evicted to stay below %s", formatSize(p.maxSize))) {
+				removed++
+				total -= f.info.Size()
+			}
+		}
+	}
+	return removed
+}
+
+// remove deletes an upload for good, logging why; deleting to the trash would not free any space
+func (p *retentionPolicy) remove(f storedUploadFile, reason string) bool {
+	err := os.Remove(f.hostPath)
+	p.audit.record(auditEntry{Session: "janitor", Op: "expire", Path: f.virtualPath, Error: errString(err)})
+	if err != nil {
+		return false
+	}
+	log.Printf("Retention: removed %s (%s, %s), %s", f.virtualPath, formatSize(f.info.Size()), uploadedAt(f.virtualPath, f.info).Format(time.DateTime), reason)
+	dirCache.invalidate(filepath.Dir(f.hostPath))
+	uploadQuota.changed()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.downloads[f.virtualPath]; ok {
+		delete(p.downloads, f.virtualPath)
+		p.saveLocked()
+	}
+	return true
+}
+
+// runJanitor enforces the retention rules right away and then every
+// retentionInterval until stop is closed
+func runJanitor(p *retentionPolicy, stop <-chan struct{}) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		p.enforce(time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// formatLifetime describes how long is left until expires, e.g. "expires in 3 days"
+func formatLifetime(expires time.Time) string {
+	left := time.Until(expires)
+	switch {
+	case expires.IsZero():
+		return ""
+	case left >= 48*time.Hour:
+		return fmt.Sprintf("expires in %d days", int(left.Hours()/24))
+	case left >= 2*time.Hour:
+		return fmt.Sprintf("expires in %d hours", int(left.Hours()))
+	case left >= 2*time.Minute:
+		return fmt.Sprintf("expires in %d minutes", int(left.Minutes()))
+	default:
+		return "expires soon"
+	}
+}
@@ -0,0 +1,133 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeUpload creates an upload of the given size that was stored age ago
+func writeUpload(t *testing.T, dir, name string, size int, age time.Duration) {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stored := time.Now().Add(-age)
+	os.Chtimes(p, stored, stored)
+}
+
+// TestRetentionMaxAge tests that uploads are removed once they are too old
+func TestRetentionMaxAge(t *testing.T) {
+	uploadsDir := t.TempDir()
+	writeUpload(t, uploadsDir, "old.txt", 10, 8*24*time.Hour)
+	writeUpload(t, uploadsDir, "new.txt", 10, time.Hour)
+	p := newRetentionPolicy(Config{UploadsDir: uploadsDir, MaxUploadAge: 7 * 24 * time.Hour})
+
+	if removed := p.enforce(time.Now()); removed != 1 {
+		t.Errorf("Expected 1 removed upload, got %d", removed)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "old.txt")); !os.IsNotExist(err) {
+		t.Error("Expected old.txt to be removed")
+	}
+
+	info, err := os.Stat(filepath.Join(uploadsDir, "new.txt"))
+	if err != nil {
+		t.Fatal("Expected new.txt to be kept")
+	}
+	if left := time.Until(p.expiresAt("uploads/new.txt", info)); left < 6*24*time.Hour || left > 7*24*time.Hour {
+		t.Errorf("Expected new.txt to expire in about 7 days, got %s", left)
+	}
+}
+
+// TestRetentionMaxSize tests that the oldest uploads are evicted first
+func TestRetentionMaxSize(t *testing.T) {
+	uploadsDir := t.TempDir()
+	writeUpload(t, uploadsDir, "oldest.bin", 100, 3*time.Hour)
+	writeUpload(t, uploadsDir, "older.bin", 100, 2*time.Hour)
+	writeUpload(t, uploadsDir, "newest.bin", 100, time.Hour)
+	p := newRetentionPolicy(Config{UploadsDir: uploadsDir, MaxUploadsSize: 150})
+
+	if removed := p.enforce(time.Now()); removed != 2 {
+		t.Errorf("Expected 2 removed uploads, got %d", removed)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "newest.bin")); err != nil {
+		t.Error("Expected the newest upload to be kept")
+	}
+
+	// Removals are recorded in the audit log
+	if data, _ := os.ReadFile(filepath.Join(stateDir(uploadsDir), "audit.log")); len(data) == 0 {
+		t.Error("Expected the removals in the audit log")
+	}
+}
+
+// TestRetentionAfterDownload tests that uploads expire after their first download
+func TestRetentionAfterDownload(t *testing.T) {
+	uploadsDir := t.TempDir()
+	writeUpload(t, uploadsDir, "report.pdf", 10, time.Minute)
+	uploadRetention = newRetentionPolicy(Config{UploadsDir: uploadsDir, ExpireAfterDownload: 2 * time.Hour})
+	defer func() { uploadRetention = nil }()
+	handler := uploadRetention.middleware(newMux(Config{UploadsDir: uploadsDir}))
+
+	// Not downloaded yet, so it is kept forever
+	files, _ := getUploadsFiles(uploadsDir)
+	if len(files) != 1 || files[0].Lifetime() != "" {
+		t.Fatalf("Expected no lifetime before the first download, got %+v", files)
+	}
+
+	req := httptest.NewRequest("GET", "/uploads/report.pdf", nil)
+	req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	files, _ = getUploadsFiles(uploadsDir)
+	if files[0].Lifetime() != "expires in 119 minutes" {
+		t.Errorf("Expected the upload to expire 2 hours after the download, got %q", files[0].Lifetime())
+	}
+
+	// The first download is remembered across restarts
+	restarted := newRetentionPolicy(Config{UploadsDir: uploadsDir, ExpireAfterDownload: 2 * time.Hour})
+	if removed := restarted.enforce(time.Now().Add(3 * time.Hour)); removed != 1 {
+		t.Errorf("Expected the downloaded upload to be removed, got %d removals", removed)
+	}
+}
+
+// TestRetentionMaxAgeDedup tests that a re-upload of known content is kept as long as a new upload
+func TestRetentionMaxAgeDedup(t *testing.T) {
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	blobs, err := newBlobStore(uploadsDir)
+	if err != nil {
+		t.Skip(err)
+	}
+	uploadBlobs = blobs
+	defer func() { uploadBlobs = nil }()
+
+	// The first phone uploaded the photo 8 days ago, the second one right now
+	saveUpload(uploadsDir, "photo.jpg", "admin@test", strings.NewReader("photo"))
+	stored := time.Now().Add(-8 * 24 * time.Hour)
+	os.Chtimes(filepath.Join(uploadsDir, "photo.jpg"), stored, stored)
+	blobs.stored["uploads/photo.jpg"] = stored
+	saveUpload(filepath.Join(uploadsDir, "phone2"), "photo.jpg", "admin@test", strings.NewReader("photo"))
+
+	p := newRetentionPolicy(Config{UploadsDir: uploadsDir, MaxUploadAge: 7 * 24 * time.Hour})
+	if removed := p.enforce(time.Now()); removed != 1 {
+		t.Errorf("Expected 1 removed upload, got %d", removed)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "photo.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected the old upload to be removed")
+	}
+	info, err := os.Stat(filepath.Join(uploadsDir, "phone2", "photo.jpg"))
+	if err != nil {
+		t.Fatal("Expected the re-upload to be kept")
+	}
+	if left := time.Until(p.expiresAt("uploads/phone2/photo.jpg", info)); left < 6*24*time.Hour {
+		t.Errorf("Expected the re-upload to expire in about 7 days, got %s", left)
+	}
+}
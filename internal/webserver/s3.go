@@ -0,0 +1,998 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Namespace is the XML namespace of S3 responses
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3Server implements the subset of the S3 API used by rclone and aws-cli.
+// Every mount is exposed as a bucket; only writable mounts accept changes.
+type s3Server struct {
+	mounts []mount
+	// stagingDir holds the parts of multipart uploads until they are completed
+	stagingDir string
+	etags      etagCache
+	// files moves deleted objects to the trash
+	files *fileManager
+
+	mu        sync.Mutex
+	multipart map[string]*multipartUpload
+}
+
+// multipartUpload tracks an upload started with CreateMultipartUpload
+type multipartUpload struct {
+	bucket string
+	key    string
+	dir    string
+}
+
+// newS3Server creates the S3 handler for the mounts of the config
+func newS3Server(cfg Config) (*s3Server, error) {
+	stagingDir, err := os.MkdirTemp("", "goshare-s3-")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Server{
+		mounts:     getMounts(cfg.SharePath, cfg.UploadsDir),
+		stagingDir: stagingDir,
+		files:      newFileManager(cfg),
+		multipart:  map[string]*multipartUpload{},
+	}, nil
+}
+
+// handler returns the S3 handler with the transfer limits applied to object reads and writes
+func (s *s3Server) handler() http.Handler {
+	return transfers.limit(s, isS3Transfer)
+}
+
+// close removes the staging directory together with unfinished multipart uploads
+func (s *s3Server) close() error {
+	return os.RemoveAll(s.stagingDir)
+}
+
+// startS3 starts serving the S3 API on cfg.S3Addr in the background
+func startS3(cfg Config) func(context.Context) error {
+	server, err := newS3Server(cfg)
+	if err != nil {
+		log.Fatalf("Error setting up S3 server: %v", err)
+	}
+	listener, err := net.Listen("tcp", cfg.S3Addr)
+	if err != nil {
+		log.Fatalf("Error starting S3 listener: %v", err)
+	}
+
+	accessKeyID, secretAccessKey := s3Credentials()
+	log.Printf("Starting S3 server on %s", listener.Addr())
+	fmt.Fprintf(console, "S3: endpoint http://<host>:%d (path-style), access key %s, secret key %s\n",
+		listener.Addr().(*net.TCPAddr).Port, accessKeyID, secretAccessKey)
+
+	httpServer := &http.Server{Handler: loggingMiddleware(server.handler().ServeHTTP)}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("S3 server stopped: %v", err)
+		}
+	}()
+	return func(ctx context.Context) error {
+		err := httpServer.Shutdown(ctx)
+		if closeErr := server.close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
+
+// s3Error is the XML body of S3 error responses
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// writeXML writes v as an XML response with the given status code
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// writeS3Error writes an error in the S3 XML format
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeXML(w, status, s3Error{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+// writeS3PathError maps filesystem errors onto S3 errors
+func writeS3PathError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errS3ChunkSignatureMismatch):
+		writeS3Error(w, r, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+	case errors.Is(err, errS3SignatureMismatch):
+		writeS3Error(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed.")
+	case errors.Is(err, os.ErrNotExist):
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	case errors.Is(err, os.ErrPermission):
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "The bucket is read-only.")
+	case errors.Is(err, errQuotaExceeded):
+		writeS3Error(w, r, uploadErrorStatus(err), "EntityTooLarge", err.Error())
+	case errors.Is(err, errDiskFull):
+		writeS3Error(w, r, uploadErrorStatus(err), "InsufficientStorage", err.Error())
+	default:
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+// s3Session returns the session an S3 request belongs to, see sessionName
+func s3Session(r *http.Request) string {
+	return roleAdmin + "@" + clientHost(r)
+}
+
+// ServeHTTP routes S3 requests using path-style addressing (/bucket/key)
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sig, err := verifySigV4(r)
+	if err != nil {
+		status, code := http.StatusForbidden, "AccessDenied"
+		switch {
+		case errors.Is(err, errS3SignatureMismatch):
+			code = "SignatureDoesNotMatch"
+		case errors.Is(err, errS3RequestTimeTooSkewed):
+			code = "RequestTimeTooSkewed"
+		case errors.Is(err, errS3UnsupportedPayload):
+			status, code = http.StatusNotImplemented, "NotImplemented"
+		}
+		writeS3Error(w, r, status, code, err.Error())
+		return
+	}
+
+	bucket, key := splitVirtualPath(r.URL.Path)
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+			return
+		}
+		s.listBuckets(w)
+		return
+	}
+
+	m, ok := findMount(s.mounts, bucket)
+	if !ok {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+	// Keep a trailing slash, S3 keys ending in "/" are folder markers
+	if key != "" && strings.HasSuffix(r.URL.Path, "/") {
+		key += "/"
+	}
+
+	query := r.URL.Query()
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && query.Has("location"):
+			writeXML(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				Xmlns   string   `xml:"xmlns,attr"`
+			}{Xmlns: s3Namespace})
+		case r.Method == http.MethodGet:
+			s.listObjects(w, r, m)
+		case r.Method == http.MethodPost && query.Has("delete"):
+			s.deleteObjects(w, r, sig, m)
+		default:
+			writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "the operation is not supported")
+		}
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, r, m, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, sig, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, r, m, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.abortMultipartUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, m, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported")
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, sig, m, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, m, key)
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "the operation is not supported")
+	}
+}
+
+// listBuckets implements ListBuckets, one bucket per mount
+func (s *s3Server) listBuckets(w http.ResponseWriter) {
+	type bucket struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	}
+	result := struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Owner   struct {
+			ID          string `xml:"ID"`
+			DisplayName string `xml:"DisplayName"`
+		} `xml:"Owner"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{Xmlns: s3Namespace}
+	result.Owner.ID = "goshare"
+	result.Owner.DisplayName = "goshare"
+
+	for _, m := range s.mounts {
+		created := time.Now()
+		if info, err := os.Stat(m.Path); err == nil {
+			created = info.ModTime()
+		}
+		result.Buckets = append(result.Buckets, bucket{Name: m.Name, CreationDate: created.UTC().Format(time.RFC3339)})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// s3Object is a file of a bucket
+type s3Object struct {
+	key  string
+	path string
+	info fs.FileInfo
+}
+
+// walkObjects returns all files of the mount whose key starts with prefix, sorted by key
+func walkObjects(m mount, prefix string) ([]s3Object, error) {
+	info, err := os.Stat(m.Path)
+	if err != nil {
+		if os.IsNotExist(err) && m.Writable {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		if strings.HasPrefix(info.Name(), prefix) {
+			return []s3Object{{key: info.Name(), path: m.Path, info: info}}, nil
+		}
+		return nil, nil
+	}
+
+	// Keys never contain "..", so such a prefix matches nothing; walking it
+	// would list files outside of the bucket
+	if strings.Contains("/"+filepath.ToSlash(prefix)+"/", "/../") {
+		return nil, nil
+	}
+
+	// Start walking at the deepest directory covered by the prefix
+	start := filepath.Clean(m.Path)
+	if dir := path.Dir(prefix); strings.Contains(prefix, "/") && dir != "." {
+		start = filepath.Join(start, filepath.FromSlash(dir))
+		if !strings.HasPrefix(start, filepath.Clean(m.Path)+string(filepath.Separator)) {
+			return nil, nil
+		}
+	}
+
+	var objects []s3Object
+	err = filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == start {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(m.Path, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, s3Object{key: key, path: p, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].key < objects[j].key })
+	return objects, nil
+}
+
+// listObjects implements ListObjects (V1) and ListObjectsV2
+func (s *s3Server) listObjects(w http.ResponseWriter, r *http.Request, m mount) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	v2 := query.Get("list-type") == "2"
+
+	maxKeys := 1000
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+
+	// Listing resumes after the marker (V1) or the continuation token / start-after key (V2)
+	after := query.Get("marker")
+	if v2 {
+		after = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+				return
+			}
+			after = string(decoded)
+		}
+	}
+
+	objects, err := walkObjects(m, prefix)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+
+	type content struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	result := struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Xmlns                 string         `xml:"xmlns,attr"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		MaxKeys               int            `xml:"MaxKeys"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		Marker                string         `xml:"Marker,omitempty"`
+		NextMarker            string         `xml:"NextMarker,omitempty"`
+		KeyCount              *int           `xml:"KeyCount,omitempty"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{
+		Xmlns:     s3Namespace,
+		Name:      m.Name,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+
+	count := 0
+	last := ""
+	seenPrefixes := map[string]bool{}
+	for _, object := range objects {
+		if object.key <= after {
+			continue
+		}
+
+		// Keys sharing a segment after the prefix collapse into one common prefix
+		if delimiter != "" {
+			if i := strings.Index(object.key[len(prefix):], delimiter); i >= 0 {
+				common := object.key[:len(prefix)+i+len(delimiter)]
+				if seenPrefixes[common] || common <= after {
+					continue
+				}
+				if count == maxKeys {
+					result.IsTruncated = true
+					break
+				}
+				seenPrefixes[common] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: common})
+				count++
+				last = common
+				continue
+			}
+		}
+
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          object.key,
+			LastModified: object.info.ModTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"` + s.etags.get(object.path, object.info) + `"`,
+			Size:         object.info.Size(),
+			StorageClass: "STANDARD",
+		})
+		count++
+		last = object.key
+	}
+
+	if v2 {
+		result.KeyCount = &count
+		result.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter = query.Get("start-after")
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		result.Marker = query.Get("marker")
+		if result.IsTruncated {
+			result.NextMarker = last
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// resolveObject maps a bucket key onto the host filesystem
+func resolveObject(m mount, key string) (string, error) {
+	if key == "" || strings.Contains("/"+key+"/", "/../") {
+		return "", os.ErrNotExist
+	}
+	return m.resolve(key)
+}
+
+// getObject implements GetObject and HeadObject, including Range requests
+func (s *s3Server) getObject(w http.ResponseWriter, r *http.Request, m mount, key string) {
+	hostPath, err := resolveObject(m, key)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	f, err := os.Open(hostPath)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		writeS3PathError(w, r, os.ErrNotExist)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+s.etags.get(hostPath, info)+`"`)
+	w.Header().Set("Content-Type", mimeTypeOf(info.Name()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// resolveWritableObject maps a key of a writable bucket onto the host filesystem
+func resolveWritableObject(m mount, key string) (string, error) {
+	if !m.Writable {
+		return "", os.ErrPermission
+	}
+	return resolveObject(m, key)
+}
+
+// payloadReader returns the request body, decoding aws-chunked transfers
+func payloadReader(r *http.Request, sig *sigV4Request) io.Reader {
+	if strings.HasPrefix(sig.payloadHash, "STREAMING-") || strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return newAWSChunkedReader(r.Body, sig)
+	}
+	return r.Body
+}
+
+// putObject implements PutObject. Unlike web uploads S3 semantics apply, so an
+// existing key is replaced; the file is written to a temporary name first and
+// renamed over it, which never writes through to the twins of a deduplicated file.
+func (s *s3Server) putObject(w http.ResponseWriter, r *http.Request, sig *sigV4Request, m mount, key string) {
+	hostPath, err := resolveWritableObject(m, key)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+
+	// Keys ending in a slash are folder markers
+	if strings.HasSuffix(key, "/") {
+		if err := os.MkdirAll(hostPath, os.ModePerm); err != nil {
+			writeS3PathError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Replacing an object only counts what it grows by against the quotas
+	quota, err := uploadQuota.startFile(s3Session(r), hostPath)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	storedPath := ""
+	defer func() { quota.finish(storedPath) }()
+
+	etag, err := writeObject(hostPath, payloadReader(r, sig), sig.payloadHash, quota)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	storedPath = hostPath
+	notifyUploadFinished(hostPath)
+
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeObject atomically stores src at hostPath and returns the MD5 based ETag.
+// When payloadHash is a SHA-256 digest the content is verified against it.
+// What is written is counted against the upload quotas with quota.
+func writeObject(hostPath string, src io.Reader, payloadHash string, quota *quotaWriter) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(hostPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(hostPath), ".goshare-s3-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	defer trackPartialFile(tmp.Name())()
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(quota.writer(), tmp, md5Hash, sha256Hash), src); err != nil {
+		return "", err
+	}
+	if len(payloadHash) == 64 && payloadHash != hex.EncodeToString(sha256Hash.Sum(nil)) {
+		return "", errS3SignatureMismatch
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), hostPath); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(md5Hash.Sum(nil)), nil
+}
+
+// deleteObject implements DeleteObject, moving the object to the trash; deleting a missing key succeeds
+func (s *s3Server) deleteObject(w http.ResponseWriter, r *http.Request, m mount, key string) {
+	hostPath, err := resolveWritableObject(m, key)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	if err := s.removeObject(r, m, key, hostPath); err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeObject moves an object to the trash or deletes an empty folder marker, ignoring missing keys
+func (s *s3Server) removeObject(r *http.Request, m mount, key, hostPath string) error {
+	if strings.HasSuffix(key, "/") {
+		// Folder markers are only removed while empty, so there is nothing to keep
+		if err := os.Remove(hostPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	_, err := s.files.remove(s3Session(r), path.Join(m.Name, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// deleteObjects implements DeleteObjects (multi-object delete)
+func (s *s3Server) deleteObjects(w http.ResponseWriter, r *http.Request, sig *sigV4Request, m mount) {
+	var request struct {
+		Quiet   bool `xml:"Quiet"`
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(payloadReader(r, sig)).Decode(&request); err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	type deleted struct {
+		Key string `xml:"Key"`
+	}
+	type deleteError struct {
+		Key     string `xml:"Key"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	result := struct {
+		XMLName xml.Name      `xml:"DeleteResult"`
+		Xmlns   string        `xml:"xmlns,attr"`
+		Deleted []deleted     `xml:"Deleted"`
+		Errors  []deleteError `xml:"Error"`
+	}{Xmlns: s3Namespace}
+
+	for _, object := range request.Objects {
+		hostPath, err := resolveWritableObject(m, object.Key)
+		if err == nil {
+			err = s.removeObject(r, m, object.Key, hostPath)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, deleteError{Key: object.Key, Code: "AccessDenied", Message: err.Error()})
+			continue
+		}
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, deleted{Key: object.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// createMultipartUpload implements CreateMultipartUpload
+func (s *s3Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, m mount, key string) {
+	if _, err := resolveWritableObject(m, key); err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes)
+	uploadID := hex.EncodeToString(idBytes)
+
+	dir := filepath.Join(s.stagingDir, uploadID)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.multipart[uploadID] = &multipartUpload{bucket: m.Name, key: key, dir: dir}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{Xmlns: s3Namespace, Bucket: m.Name, Key: key, UploadID: uploadID})
+}
+
+// lookupMultipart returns the multipart upload with the given ID
+func (s *s3Server) lookupMultipart(uploadID string) (*multipartUpload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.multipart[uploadID]
+	return upload, ok
+}
+
+// uploadPart implements UploadPart, storing the part in the staging directory
+func (s *s3Server) uploadPart(w http.ResponseWriter, r *http.Request, sig *sigV4Request, uploadID, partNumber string) {
+	upload, ok := s.lookupMultipart(uploadID)
+	if !ok {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > 10000 {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+
+	// Parts are not uploads yet, but must not fill the disk either
+	quota, err := uploadQuota.start(s3Session(r))
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	defer quota.finish("")
+
+	etag, err := writeObject(filepath.Join(upload.dir, fmt.Sprintf("%05d", n)), payloadReader(r, sig), sig.payloadHash, quota)
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload implements CompleteMultipartUpload by concatenating the parts
+func (s *s3Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, m mount, key, uploadID string) {
+	upload, ok := s.lookupMultipart(uploadID)
+	if !ok || upload.bucket != m.Name || upload.key != key {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+
+	var request struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Parts) == 0 {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "invalid part list")
+		return
+	}
+
+	// Open the parts in the requested order and check them against their ETags
+	var readers []io.Reader
+	var partMD5s []byte
+	for i, part := range request.Parts {
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidPartOrder", "parts must be in ascending order")
+			return
+		}
+		f, err := os.Open(filepath.Join(upload.dir, fmt.Sprintf("%05d", part.PartNumber)))
+		if err != nil {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", part.PartNumber))
+			return
+		}
+		defer f.Close()
+
+		sum, err := md5Reader(f)
+		if err != nil || strings.Trim(part.ETag, `"`) != hex.EncodeToString(sum) {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d does not match its ETag", part.PartNumber))
+			return
+		}
+		f.Seek(0, io.SeekStart)
+		readers = append(readers, f)
+		partMD5s = append(partMD5s, sum...)
+	}
+
+	hostPath, err := resolveWritableObject(m, key)
+	var quota *quotaWriter
+	if err == nil {
+		quota, err = uploadQuota.startFile(s3Session(r), hostPath)
+	}
+	if err == nil {
+		_, err = writeObject(hostPath, io.MultiReader(readers...), unsignedPayload, quota)
+		if err != nil {
+			quota.finish("")
+		}
+	}
+	if err != nil {
+		writeS3PathError(w, r, err)
+		return
+	}
+	quota.finish(hostPath)
+	notifyUploadFinished(hostPath)
+
+	s.mu.Lock()
+	delete(s.multipart, uploadID)
+	s.mu.Unlock()
+	os.RemoveAll(upload.dir)
+
+	// Multipart ETags are the MD5 of the part MD5s followed by the number of parts
+	combined := md5.Sum(partMD5s)
+	etag := fmt.Sprintf("%s-%d", hex.EncodeToString(combined[:]), len(request.Parts))
+	if info, err := os.Stat(hostPath); err == nil {
+		s.etags.set(hostPath, info, etag)
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string   `xml:"Location"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		ETag     string   `xml:"ETag"`
+	}{Xmlns: s3Namespace, Location: "/" + m.Name + "/" + key, Bucket: m.Name, Key: key, ETag: `"` + etag + `"`})
+}
+
+// abortMultipartUpload implements AbortMultipartUpload
+func (s *s3Server) abortMultipartUpload(w http.ResponseWriter, uploadID string) {
+	s.mu.Lock()
+	upload, ok := s.multipart[uploadID]
+	delete(s.multipart, uploadID)
+	s.mu.Unlock()
+
+	if ok {
+		os.RemoveAll(upload.dir)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// md5Reader returns the MD5 digest of everything read from r
+func md5Reader(r io.Reader) ([]byte, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// etagCache remembers the ETags of files so listings do not hash unchanged files again
+type etagCache struct {
+	mu      sync.Mutex
+	entries map[string]etagEntry
+}
+
+// etagEntry is an ETag valid for a file with the given size and modification time
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// get returns the ETag of the file, computing its MD5 when the file changed
+func (c *etagCache) get(hostPath string, info fs.FileInfo) string {
+	c.mu.Lock()
+	entry, ok := c.entries[hostPath]
+	c.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.etag
+	}
+
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sum, err := md5Reader(f)
+	if err != nil {
+		return ""
+	}
+
+	etag := hex.EncodeToString(sum)
+	c.set(hostPath, info, etag)
+	return etag
+}
+
+// set stores the ETag of a file
+func (c *etagCache) set(hostPath string, info fs.FileInfo, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]etagEntry{}
+	}
+	c.entries[hostPath] = etagEntry{size: info.Size(), modTime: info.ModTime(), etag: etag}
+}
+
+// awsChunkedReader decodes the aws-chunked content encoding used for streaming
+// uploads. With a signed payload every chunk carries a signature chained to
+// the seed signature of the request, which is verified once the chunk is
+// read; trailing checksums are skipped.
+type awsChunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+
+	// sig is the request the chunks are signed for, nil if they are unsigned
+	sig *sigV4Request
+	// prevSignature is the signature of the previous chunk, or the seed signature
+	prevSignature string
+	// chunkSignature is the signature the current chunk has to match
+	chunkSignature string
+	chunkHash      hash.Hash
+}
+
+// newAWSChunkedReader returns a reader decoding body, verifying the chunk signatures if sig has signed chunks
+func newAWSChunkedReader(body io.Reader, sig *sigV4Request) *awsChunkedReader {
+	c := &awsChunkedReader{r: bufio.NewReader(body)}
+	if sig.payloadHash == streamingSignedPayload {
+		c.sig = sig
+		c.prevSignature = sig.signature
+		c.chunkHash = sha256.New()
+	}
+	return c
+}
+
+// Read implements io.Reader
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if c.sig != nil {
+		c.chunkHash.Write(p[:n])
+		if c.remaining == 0 && err == nil {
+			err = c.verifyChunk()
+		}
+	}
+	return n, err
+}
+
+// nextChunk reads the header of the next chunk, consuming the end of the previous one
+func (c *awsChunkedReader) nextChunk() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		// The CRLF that terminates the previous chunk's data
+		line, err = c.r.ReadString('\n')
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		line = strings.TrimSpace(line)
+	}
+
+	sizeField, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid aws-chunked chunk header %q", line)
+	}
+	if c.sig != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok {
+			return errS3ChunkSignatureMismatch
+		}
+		c.chunkSignature = signature
+	}
+	if size == 0 {
+		// The last chunk is signed as well, so a cut off upload is noticed;
+		// it may be followed by trailers, which are not needed
+		c.done = true
+		if c.sig != nil {
+			return c.verifyChunk()
+		}
+		return nil
+	}
+	c.remaining = size
+	return nil
+}
+
+// verifyChunk checks the signature of the chunk that was just read
+func (c *awsChunkedReader) verifyChunk() error {
+	emptyHash := sha256.Sum256(nil)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		c.sig.amzDate,
+		c.sig.scope,
+		c.prevSignature,
+		hex.EncodeToString(emptyHash[:]),
+		hex.EncodeToString(c.chunkHash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.sig.signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSignature)) {
+		return errS3ChunkSignatureMismatch
+	}
+	c.prevSignature = c.chunkSignature
+	c.chunkHash.Reset()
+	return nil
+}
@@ -0,0 +1,319 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// newS3TestClient starts an S3 server for the config and returns an AWS SDK client for it
+func newS3TestClient(t *testing.T, cfg Config, accessKeyID, secretAccessKey string) *s3.Client {
+	t.Helper()
+
+	server, err := newS3Server(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.close() })
+
+	srv := httptest.NewServer(server.handler())
+	t.Cleanup(srv.Close)
+
+	return s3.New(s3.Options{
+		BaseEndpoint: aws.String(srv.URL),
+		Region:       "us-east-1",
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, ""),
+	})
+}
+
+// TestS3 tests listing, reading, writing and deleting objects with the AWS SDK
+func TestS3(t *testing.T) {
+	ctx := context.Background()
+	sharedDir := t.TempDir()
+	uploadsDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(sharedDir, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "a", "docs/b.txt": "bb", "docs/c.txt": "ccc"} {
+		if err := os.WriteFile(filepath.Join(sharedDir, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	accessKeyID, secretAccessKey := s3Credentials()
+	client := newS3TestClient(t, Config{SharePath: sharedDir, UploadsDir: uploadsDir}, accessKeyID, secretAccessKey)
+
+	// Buckets map to mounts
+	buckets, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets.Buckets) != 2 || *buckets.Buckets[0].Name != "shared" || *buckets.Buckets[1].Name != "uploads" {
+		t.Errorf("Unexpected buckets: %+v", buckets.Buckets)
+	}
+
+	// Listing with a delimiter groups keys into common prefixes
+	list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("shared"), Delimiter: aws.String("/")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 1 || *list.Contents[0].Key != "a.txt" || len(list.CommonPrefixes) != 1 || *list.CommonPrefixes[0].Prefix != "docs/" {
+		t.Errorf("Unexpected listing: %+v %+v", list.Contents, list.CommonPrefixes)
+	}
+
+	// Listing is paginated
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String("shared"), MaxKeys: aws.Int32(2)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+	}
+	if strings.Join(keys, ",") != "a.txt,docs/b.txt,docs/c.txt" {
+		t.Errorf("Unexpected paginated keys: %v", keys)
+	}
+
+	// Objects can be read, including ranges
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("shared"), Key: aws.String("docs/c.txt"), Range: aws.String("bytes=1-")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(object.Body)
+	object.Body.Close()
+	if string(content) != "cc" {
+		t.Errorf("Unexpected ranged content: %q", content)
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("shared"), Key: aws.String("docs/b.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *head.ContentLength != 2 {
+		t.Errorf("Unexpected content length: %d", *head.ContentLength)
+	}
+
+	// Shared buckets are read-only
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("shared"), Key: aws.String("new.txt"), Body: strings.NewReader("x")})
+	if err == nil {
+		t.Error("Expected writing to the shared bucket to fail")
+	}
+
+	// Uploads accept new objects and deletes
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("uploads"), Key: aws.String("dir/new file.txt"), Body: strings.NewReader("new content")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(filepath.Join(uploadsDir, "dir", "new file.txt"))
+	if err != nil || string(stored) != "new content" {
+		t.Errorf("Expected the object to be stored, got %q (%v)", stored, err)
+	}
+
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("uploads"), Key: aws.String("dir/new file.txt")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("uploads"), Key: aws.String("dir/new file.txt")})
+	var notFound *types.NotFound
+	if !errors.As(err, &notFound) {
+		t.Errorf("Expected the object to be deleted, got %v", err)
+	}
+	if items, _ := ListTrash(uploadsDir); len(items) != 1 || items[0].Path != "uploads/dir/new file.txt" {
+		t.Errorf("Expected the object to be moved to the trash, got %+v", items)
+	}
+}
+
+// TestS3Multipart tests a multipart upload with the AWS SDK
+func TestS3Multipart(t *testing.T) {
+	ctx := context.Background()
+	uploadsDir := t.TempDir()
+
+	accessKeyID, secretAccessKey := s3Credentials()
+	client := newS3TestClient(t, Config{UploadsDir: uploadsDir}, accessKeyID, secretAccessKey)
+
+	created, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String("uploads"), Key: aws.String("big.bin")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := [][]byte{bytes.Repeat([]byte("a"), 5<<20), []byte("tail")}
+	var completed []types.CompletedPart
+	for i, part := range parts {
+		uploaded, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String("uploads"),
+			Key:        aws.String("big.bin"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       bytes.NewReader(part),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		completed = append(completed, types.CompletedPart{ETag: uploaded.ETag, PartNumber: aws.Int32(int32(i + 1))})
+	}
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("uploads"),
+		Key:             aws.String("big.bin"),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(uploadsDir, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, bytes.Join(parts, nil)) {
+		t.Error("Expected the parts to be concatenated")
+	}
+}
+
+// TestS3RejectsWrongCredentials tests that requests signed with other credentials fail
+func TestS3RejectsWrongCredentials(t *testing.T) {
+	accessKeyID, _ := s3Credentials()
+	client := newS3TestClient(t, Config{UploadsDir: t.TempDir()}, accessKeyID, "wrong-secret")
+
+	if _, err := client.ListBuckets(context.Background(), &s3.ListBucketsInput{}); err == nil {
+		t.Error("Expected a request with a wrong secret to fail")
+	}
+}
+
+// TestS3RequestTime tests that old requests and expired presigned URLs are rejected
+func TestS3RequestTime(t *testing.T) {
+	server, err := newS3Server(Config{UploadsDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.close()
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	accessKeyID, secretAccessKey := s3Credentials()
+	credentials := aws.Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}
+	emptyHash := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	signer := v4.NewSigner()
+	send := func(signed time.Time, presign bool, expires string) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/uploads", nil)
+		if presign {
+			if expires != "" {
+				req.URL.RawQuery = "X-Amz-Expires=" + expires
+			}
+			signedURL, _, err := signer.PresignHTTP(context.Background(), credentials, req, unsignedPayload, "s3", "us-east-1", signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, _ = http.NewRequest(http.MethodGet, signedURL, nil)
+		} else {
+			req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+			if err := signer.SignHTTP(context.Background(), credentials, req, emptyHash, "s3", "us-east-1", signed); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	now := time.Now()
+	for _, test := range []struct {
+		name    string
+		signed  time.Time
+		presign bool
+		expires string
+		status  int
+	}{
+		{"recent request", now, false, "", http.StatusOK},
+		{"old request", now.Add(-time.Hour), false, "", http.StatusForbidden},
+		{"request from the future", now.Add(time.Hour), false, "", http.StatusForbidden},
+		{"valid presigned URL", now.Add(-time.Hour), true, "7200", http.StatusOK},
+		{"expired presigned URL", now.Add(-time.Hour), true, "60", http.StatusForbidden},
+		{"presigned URL without expiry", now, true, "", http.StatusForbidden},
+		{"presigned URL valid too long", now, true, fmt.Sprint(8 * 24 * 3600), http.StatusForbidden},
+	} {
+		if status := send(test.signed, test.presign, test.expires); status != test.status {
+			t.Errorf("%s: expected status code %d, got %d", test.name, test.status, status)
+		}
+	}
+}
+
+// TestAWSChunkedReaderSignatures tests verifying chunk signatures with the example from the S3 documentation
+func TestAWSChunkedReaderSignatures(t *testing.T) {
+	sig := &sigV4Request{
+		amzDate:     "20130524T000000Z",
+		scope:       "20130524/us-east-1/s3/aws4_request",
+		signature:   "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9",
+		payloadHash: streamingSignedPayload,
+		signingKey:  deriveSigningKey("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", "20130524/us-east-1/s3/aws4_request"),
+	}
+	body := func(finalSignature string) string {
+		return "10000;chunk-signature=ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648\r\n" +
+			strings.Repeat("a", 65536) + "\r\n" +
+			"400;chunk-signature=0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497\r\n" +
+			strings.Repeat("a", 1024) + "\r\n" +
+			"0;chunk-signature=" + finalSignature + "\r\n\r\n"
+	}
+
+	data, err := io.ReadAll(newAWSChunkedReader(strings.NewReader(body("b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9")), sig))
+	if err != nil || len(data) != 66560 {
+		t.Errorf("Expected 66560 bytes, got %d (%v)", len(data), err)
+	}
+
+	// A changed chunk fails even when its size stays the same
+	tampered := strings.Replace(body("b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9"), "aaaa\r\n400", "aaab\r\n400", 1)
+	if _, err := io.ReadAll(newAWSChunkedReader(strings.NewReader(tampered), sig)); !errors.Is(err, errS3ChunkSignatureMismatch) {
+		t.Errorf("Expected a chunk signature mismatch for a changed chunk, got %v", err)
+	}
+
+	// The final chunk is signed too, so cutting an upload short is noticed
+	if _, err := io.ReadAll(newAWSChunkedReader(strings.NewReader(body(strings.Repeat("0", 64))), sig)); !errors.Is(err, errS3ChunkSignatureMismatch) {
+		t.Errorf("Expected a chunk signature mismatch for a wrong final chunk, got %v", err)
+	}
+}
+
+// TestS3ListTraversalPrefix tests that a listing prefix cannot reach outside of the bucket
+func TestS3ListTraversalPrefix(t *testing.T) {
+	root := t.TempDir()
+	sharedDir := filepath.Join(root, "shared")
+	for name, content := range map[string]string{"shared/a.txt": "a", "secret/passwords.txt": "hunter2"} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0o755)
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	accessKeyID, secretAccessKey := s3Credentials()
+	client := newS3TestClient(t, Config{SharePath: sharedDir, UploadsDir: t.TempDir()}, accessKeyID, secretAccessKey)
+	for _, prefix := range []string{"../secret/", "../secret/pass", "..", "x/../../secret/"} {
+		list, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("shared"), Prefix: aws.String(prefix)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Contents) != 0 || len(list.CommonPrefixes) != 0 {
+			t.Errorf("Expected nothing to be listed for prefix %q, got %+v %+v", prefix, list.Contents, list.CommonPrefixes)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// unsignedPayload is the payload hash used by clients that do not sign the body
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// streamingSignedPayload is the payload hash of aws-chunked uploads with a signature per chunk
+	streamingSignedPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// streamingUnsignedTrailer is the payload hash of aws-chunked uploads without chunk signatures
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// amzDateFormat is the layout of the X-Amz-Date header
+const amzDateFormat = "20060102T150405Z"
+
+// s3MaxClockSkew is how far the signing time of a request may be off from the server clock
+const s3MaxClockSkew = 15 * time.Minute
+
+// s3MaxPresignExpiry is the longest time a presigned URL can be valid, as with S3
+const s3MaxPresignExpiry = 7 * 24 * time.Hour
+
+var (
+	// errS3SignatureMismatch is returned when a request signature does not verify
+	errS3SignatureMismatch = errors.New("the request signature we calculated does not match the signature you provided")
+	// errS3ChunkSignatureMismatch is returned when a chunk of a streaming upload does not verify
+	errS3ChunkSignatureMismatch = fmt.Errorf("chunk signature mismatch: %w", errS3SignatureMismatch)
+	// errS3RequestTimeTooSkewed is returned when a request was signed too long ago or in the future
+	errS3RequestTimeTooSkewed = errors.New("the difference between the request time and the server's time is too large")
+	// errS3RequestExpired is returned for presigned URLs used after they expired
+	errS3RequestExpired = errors.New("request has expired")
+	// errS3UnsupportedPayload is returned for streaming uploads signed in a way that is not supported
+	errS3UnsupportedPayload = errors.New("unsupported x-amz-content-sha256 value")
+)
+
+// s3Credentials returns the S3 access key ID and secret access key. Both are
+// derived from the server key, so they change with every server start.
+func s3Credentials() (string, string) {
+	derive := func(label string) string {
+		mac := hmac.New(sha256.New, []byte(secretKey))
+		mac.Write([]byte(label))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	accessKeyID := "GOSHARE" + strings.ToUpper(derive("s3-access-key-id")[:13])
+	secretAccessKey := derive("s3-secret-access-key")[:40]
+	return accessKeyID, secretAccessKey
+}
+
+// sigV4Request holds the signature parameters of an AWS Signature Version 4 request
+type sigV4Request struct {
+	accessKeyID   string
+	date          string
+	scope         string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+	presigned     bool
+	// expires is how long a presigned URL is valid after amzDate
+	expires time.Duration
+	// signingKey is derived from the secret access key for the scope, it
+	// signs the chunks of streaming uploads
+	signingKey []byte
+}
+
+// parseSigV4 extracts the signature parameters from the Authorization header
+// or, for presigned URLs, from the query string
+func parseSigV4(r *http.Request) (*sigV4Request, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		if query.Get("X-Amz-Algorithm") != "AWS4-HMAC-SHA256" {
+			return nil, errors.New("unsupported signature algorithm")
+		}
+		sig := &sigV4Request{
+			signedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+			signature:     query.Get("X-Amz-Signature"),
+			amzDate:       query.Get("X-Amz-Date"),
+			payloadHash:   unsignedPayload,
+			presigned:     true,
+		}
+		if err := sig.setCredential(query.Get("X-Amz-Credential")); err != nil {
+			return nil, err
+		}
+		seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > s3MaxPresignExpiry {
+			return nil, errors.New("missing or invalid X-Amz-Expires")
+		}
+		sig.expires = time.Duration(seconds) * time.Second
+		return sig, nil
+	}
+
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return nil, errors.New("missing or unsupported authorization")
+	}
+
+	sig := &sigV4Request{
+		amzDate:     r.Header.Get("X-Amz-Date"),
+		payloadHash: r.Header.Get("X-Amz-Content-Sha256"),
+	}
+	for _, field := range strings.Split(auth, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch key {
+		case "Credential":
+			if err := sig.setCredential(value); err != nil {
+				return nil, err
+			}
+		case "SignedHeaders":
+			sig.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			sig.signature = value
+		}
+	}
+	if sig.accessKeyID == "" || sig.signature == "" || len(sig.signedHeaders) == 0 {
+		return nil, errors.New("malformed authorization header")
+	}
+	if sig.payloadHash == "" {
+		sig.payloadHash = unsignedPayload
+	}
+	// Trailing checksums and ECDSA signed chunks would go unchecked
+	if strings.HasPrefix(sig.payloadHash, "STREAMING-") && sig.payloadHash != streamingSignedPayload && sig.payloadHash != streamingUnsignedTrailer {
+		return nil, errS3UnsupportedPayload
+	}
+	return sig, nil
+}
+
+// setCredential parses a credential of the form AKID/date/region/service/aws4_request
+func (s *sigV4Request) setCredential(credential string) error {
+	accessKeyID, scope, ok := strings.Cut(credential, "/")
+	parts := strings.Split(scope, "/")
+	if !ok || len(parts) != 4 || parts[3] != "aws4_request" {
+		return errors.New("malformed credential")
+	}
+	s.accessKeyID = accessKeyID
+	s.date = parts[0]
+	s.scope = scope
+	return nil
+}
+
+// checkTime makes sure the request was signed recently and, for presigned
+// URLs, has not expired yet
+func (s *sigV4Request) checkTime(now time.Time) error {
+	signed, err := time.Parse(amzDateFormat, s.amzDate)
+	if err != nil {
+		return errors.New("missing or malformed X-Amz-Date")
+	}
+	if !strings.HasPrefix(s.amzDate, s.date) {
+		return errors.New("the date of the credential does not match X-Amz-Date")
+	}
+	if signed.After(now.Add(s3MaxClockSkew)) {
+		return errS3RequestTimeTooSkewed
+	}
+	if s.presigned {
+		if now.After(signed.Add(s.expires)) {
+			return errS3RequestExpired
+		}
+	} else if now.Sub(signed) > s3MaxClockSkew {
+		return errS3RequestTimeTooSkewed
+	}
+	return nil
+}
+
+// deriveSigningKey derives the key for the date, region and service of the scope
+func deriveSigningKey(secretAccessKey, scope string) []byte {
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+// verifySigV4 checks the AWS Signature Version 4 of the request against the derived credentials
+func verifySigV4(r *http.Request) (*sigV4Request, error) {
+	sig, err := parseSigV4(r)
+	if err != nil {
+		return nil, err
+	}
+
+	accessKeyID, secretAccessKey := s3Credentials()
+	if sig.accessKeyID != accessKeyID {
+		return nil, fmt.Errorf("unknown access key %q", sig.accessKeyID)
+	}
+	if err := sig.checkTime(time.Now()); err != nil {
+		return nil, err
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s3EncodePath(r.URL.Path),
+		canonicalQuery(r.URL.Query(), sig.presigned),
+		canonicalHeaders(r, sig.signedHeaders),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		sig.amzDate,
+		sig.scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	sig.signingKey = deriveSigningKey(secretAccessKey, sig.scope)
+	expected := hex.EncodeToString(hmacSHA256(sig.signingKey, stringToSign))
+
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, errS3SignatureMismatch
+	}
+	return sig, nil
+}
+
+// hmacSHA256 computes HMAC-SHA256 of data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Encode percent-encodes everything except the unreserved characters of RFC 3986
+func s3Encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3EncodePath encodes each segment of a path, keeping the slashes
+func s3EncodePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Encode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery builds the sorted, encoded query string of the canonical request
+func canonicalQuery(query url.Values, presigned bool) string {
+	type pair struct{ key, value string }
+	var pairs []pair
+	for key, values := range query {
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, pair{s3Encode(key), s3Encode(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.key + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+// canonicalHeaders builds the canonical header block for the signed headers
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	var b strings.Builder
+	for _, name := range signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = fmt.Sprint(r.ContentLength)
+		default:
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(strings.Fields(value), " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
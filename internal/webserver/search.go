@@ -0,0 +1,344 @@
+package webserver
+
+import (
+	"bufio"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//go:embed templates/search.html
+var searchHTML string
+
+// searchTemplate renders the search page
+var searchTemplate = template.Must(template.New("search.html").Parse(searchHTML))
+
+// searchMaxResults is the number of results returned when the client sets no limit
+const searchMaxResults = 200
+
+// textIndexMaxSize is the size above which files are left out of the full-text index
+const textIndexMaxSize = 4 << 20
+
+// searchMaxLines is the number of matching lines shown per file
+const searchMaxLines = 3
+
+// searchMaxLineLength is the length matching lines are shortened to
+const searchMaxLineLength = 200
+
+// textExtensions are indexed for full-text search in addition to text/* MIME types
+var textExtensions = map[string]bool{
+	".md": true, ".markdown": true, ".rst": true, ".txt": true, ".log": true,
+	".csv": true, ".tsv": true, ".json": true, ".yaml": true, ".yml": true,
+	".toml": true, ".ini": true, ".conf": true, ".cfg": true, ".env": true,
+	".xml": true, ".html": true, ".htm": true, ".css": true, ".svg": true,
+	".go": true, ".mod": true, ".py": true, ".rb": true, ".js": true, ".mjs": true,
+	".ts": true, ".tsx": true, ".jsx": true, ".java": true, ".kt": true,
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".hpp": true, ".cs": true,
+	".rs": true, ".swift": true, ".php": true, ".pl": true, ".lua": true,
+	".sh": true, ".bash": true, ".zsh": true, ".ps1": true, ".bat": true,
+	".sql": true, ".tex": true, ".vue": true, ".scss": true, ".dockerfile": true,
+}
+
+// isTextFile reports whether a file is indexed for full-text search
+func isTextFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if textExtensions[ext] {
+		return true
+	}
+	return strings.HasPrefix(mimeTypeOf(name), "text/")
+}
+
+// searchLine is a line of a file matching a full-text query
+type searchLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// searchHit is a file or directory found by a search
+type searchHit struct {
+	listEntry
+	Lines []searchLine `json:"lines,omitempty"`
+}
+
+// searchResults is the response body of the search endpoint
+type searchResults struct {
+	Query   string      `json:"query"`
+	Content bool        `json:"content"`
+	Results []searchHit `json:"results"`
+	// Total is the number of matches, which may be more than the returned results
+	Total int `json:"total"`
+}
+
+// FormatSize returns a human-readable string representation of the size of a hit
+func (h searchHit) FormatSize() string {
+	return formatSize(h.Size)
+}
+
+// indexedText is a file in the full-text index
+type indexedText struct {
+	size    int64
+	modTime time.Time
+	words   []string
+}
+
+// textIndex is an inverted index from words to the files containing them.
+// It is updated incrementally: only new and changed files are read again.
+type textIndex struct {
+	docs     map[string]indexedText
+	postings map[string]map[string]struct{}
+}
+
+// newTextIndex creates an empty index
+func newTextIndex() *textIndex {
+	return &textIndex{docs: map[string]indexedText{}, postings: map[string]map[string]struct{}{}}
+}
+
+// searchWords splits text into the lowercase words used by the index
+func searchWords(text string) []string {
+	seen := map[string]struct{}{}
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if _, ok := seen[word]; ok {
+			continue
+		}
+		seen[word] = struct{}{}
+		words = append(words, word)
+	}
+	return words
+}
+
+// update brings the index in line with a snapshot of the mounts. resolve maps
+// the virtual paths of the snapshot onto the host filesystem.
+func (x *textIndex) update(snapshot fileSnapshot, resolve func(string) (string, error)) {
+	for p := range x.docs {
+		entry, ok := snapshot[p]
+		if !ok || entry.IsDir || !isTextFile(entry.Name) || entry.Size > textIndexMaxSize {
+			x.remove(p)
+		}
+	}
+
+	for p, entry := range snapshot {
+		if entry.IsDir || !isTextFile(entry.Name) || entry.Size > textIndexMaxSize {
+			continue
+		}
+		if doc, ok := x.docs[p]; ok && doc.size == entry.Size && doc.modTime.Equal(entry.ModTime) {
+			continue
+		}
+
+		// New or changed file, index its words again
+		x.remove(p)
+		hostPath, err := resolve(p)
+		if err != nil {
+			continue
+		}
+		content, err := os.ReadFile(hostPath)
+		if err != nil {
+			continue
+		}
+		doc := indexedText{size: entry.Size, modTime: entry.ModTime, words: searchWords(string(content))}
+		for _, word := range doc.words {
+			if x.postings[word] == nil {
+				x.postings[word] = map[string]struct{}{}
+			}
+			x.postings[word][p] = struct{}{}
+		}
+		x.docs[p] = doc
+	}
+}
+
+// remove drops a file from the index
+func (x *textIndex) remove(p string) {
+	doc, ok := x.docs[p]
+	if !ok {
+		return
+	}
+	for _, word := range doc.words {
+		delete(x.postings[word], p)
+		if len(x.postings[word]) == 0 {
+			delete(x.postings, word)
+		}
+	}
+	delete(x.docs, p)
+}
+
+// lookup returns the paths of the files containing every word of the query,
+// where each query word may be the beginning of a longer word
+func (x *textIndex) lookup(query string) []string {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var matching map[string]struct{}
+	for _, queryWord := range words {
+		found := map[string]struct{}{}
+		for word, paths := range x.postings {
+			if !strings.HasPrefix(word, queryWord) {
+				continue
+			}
+			for p := range paths {
+				if _, ok := matching[p]; matching == nil || ok {
+					found[p] = struct{}{}
+				}
+			}
+		}
+		matching = found
+		if len(matching) == 0 {
+			return nil
+		}
+	}
+
+	paths := make([]string, 0, len(matching))
+	for p := range matching {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// matchingLines returns the first lines of a file containing one of the query words
+func matchingLines(hostPath, query string) []searchLine {
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	words := searchWords(query)
+	var lines []searchLine
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, textIndexMaxSize)
+	for n := 1; scanner.Scan() && len(lines) < searchMaxLines; n++ {
+		line := scanner.Text()
+		lower := strings.ToLower(line)
+		for _, word := range words {
+			if strings.Contains(lower, word) {
+				text := strings.TrimSpace(line)
+				if len(text) > searchMaxLineLength {
+					text = strings.ToValidUTF8(text[:searchMaxLineLength], "") + "…"
+				}
+				lines = append(lines, searchLine{Line: n, Text: text})
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// searcher finds files by name and, when enabled, by content across mounts
+type searcher struct {
+	mounts []mount
+	// mu serializes searches so the index is updated by one at a time
+	mu sync.Mutex
+	// content is the full-text index; nil when content search is disabled
+	content *textIndex
+}
+
+// newSearcher creates a searcher over mounts, with an index for full-text search if withContent is set
+func newSearcher(mounts []mount, withContent bool) *searcher {
+	s := &searcher{mounts: mounts}
+	if withContent {
+		s.content = newTextIndex()
+	}
+	return s
+}
+
+// search returns up to limit files and directories whose names match query,
+// see matchName, or with content set, text files containing all words of query
+func (s *searcher) search(query string, content bool, limit int) searchResults {
+	results := searchResults{Query: query, Content: content, Results: []searchHit{}}
+	if limit <= 0 {
+		limit = searchMaxResults
+	}
+	if strings.TrimSpace(query) == "" {
+		return results
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := snapshotMounts(s.mounts)
+
+	var paths []string
+	if content {
+		resolve := func(p string) (string, error) { return resolveVirtualFile(s.mounts, p) }
+		s.content.update(snapshot, resolve)
+		paths = s.content.lookup(query)
+	} else {
+		for p, entry := range snapshot {
+			if matchName(query, entry.Name) {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+	}
+
+	results.Total = len(paths)
+	for _, p := range paths[:min(limit, len(paths))] {
+		hit := searchHit{listEntry: snapshot[p]}
+		if content {
+			if hostPath, err := resolveVirtualFile(s.mounts, p); err == nil {
+				hit.Lines = matchingLines(hostPath, query)
+			}
+		}
+		results.Results = append(results.Results, hit)
+	}
+	return results
+}
+
+// handleAPISearch returns the files matching the q query parameter
+func handleAPISearch(w http.ResponseWriter, r *http.Request, s *searcher) {
+	query := r.URL.Query()
+	q := query.Get("q")
+	if strings.TrimSpace(q) == "" {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "q must not be empty")
+		return
+	}
+
+	content := query.Get("content") == "true"
+	if content && s.content == nil {
+		writeAPIError(w, http.StatusBadRequest, "content_search_disabled", "full-text search is disabled, start the server with --search-content")
+		return
+	}
+
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, s.search(q, content, limit))
+}
+
+// ServeHTTP renders the search page with the results for the q query parameter
+func (s *searcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	data := struct {
+		ContentEnabled bool
+		searchResults
+	}{
+		ContentEnabled: s.content != nil,
+		searchResults:  s.search(query.Get("q"), s.content != nil && query.Get("content") == "true", 0),
+	}
+	if err := searchTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,124 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createSearchTree creates a shared project tree and an uploads directory
+func createSearchTree(t *testing.T) (string, string) {
+	sharedDir := t.TempDir()
+	uploadsDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sharedDir, "finance", "2025"), 0o755)
+	os.WriteFile(filepath.Join(sharedDir, "finance", "2025", "invoice-2025-03.pdf"), []byte("%PDF"), 0o644)
+	os.WriteFile(filepath.Join(sharedDir, "finance", "notes.md"), []byte("# Notes\nPay the plumber invoice\nnothing here\n"), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, "Invoice-copy.PDF"), []byte("%PDF"), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, "main.go"), []byte("package main\n\nfunc main() { plumbing() }\n"), 0o644)
+	return sharedDir, uploadsDir
+}
+
+// TestSearchNames tests substring and glob searches across mounts
+func TestSearchNames(t *testing.T) {
+	sharedDir, uploadsDir := createSearchTree(t)
+	s := newSearcher(getMounts(sharedDir, uploadsDir), false)
+
+	results := s.search("invoice", false, 0)
+	if results.Total != 2 || results.Results[0].Path != "shared/finance/2025/invoice-2025-03.pdf" || results.Results[1].Path != "uploads/Invoice-copy.PDF" {
+		t.Errorf("Unexpected results: %+v", results.Results)
+	}
+
+	results = s.search("*.pdf", false, 1)
+	if results.Total != 2 || len(results.Results) != 1 {
+		t.Errorf("Expected 1 of 2 results, got %d of %d", len(results.Results), results.Total)
+	}
+
+	// Directories are found as well
+	results = s.search("2025", false, 0)
+	if results.Total != 2 || !results.Results[0].IsDir || results.Results[0].URL != "/shared/finance/2025/" {
+		t.Errorf("Unexpected results: %+v", results.Results)
+	}
+
+	if results = s.search(" ", false, 0); results.Total != 0 || results.Results == nil {
+		t.Errorf("Expected no results for an empty query, got %+v", results)
+	}
+}
+
+// TestSearchContent tests full-text search and incremental index updates
+func TestSearchContent(t *testing.T) {
+	sharedDir, uploadsDir := createSearchTree(t)
+	s := newSearcher(getMounts(sharedDir, uploadsDir), true)
+
+	// Query words match the beginning of words in the file
+	results := s.search("plumb", true, 0)
+	if results.Total != 2 {
+		t.Fatalf("Expected 2 results, got %+v", results.Results)
+	}
+	hit := results.Results[0]
+	if hit.Path != "shared/finance/notes.md" || len(hit.Lines) != 1 || hit.Lines[0].Line != 2 || hit.Lines[0].Text != "Pay the plumber invoice" {
+		t.Errorf("Unexpected hit: %+v", hit)
+	}
+
+	// All words must be found in a file
+	if results = s.search("plumber invoice", true, 0); results.Total != 1 {
+		t.Errorf("Expected 1 result, got %+v", results.Results)
+	}
+
+	// Changed and removed files are picked up by the next search
+	notes := filepath.Join(sharedDir, "finance", "notes.md")
+	os.WriteFile(notes, []byte("electrician\n"), 0o644)
+	os.Chtimes(notes, time.Time{}, time.Now().Add(time.Minute))
+	os.Remove(filepath.Join(uploadsDir, "main.go"))
+	if results = s.search("plumb", true, 0); results.Total != 0 {
+		t.Errorf("Expected no results after the change, got %+v", results.Results)
+	}
+	if results = s.search("electrician", true, 0); results.Total != 1 {
+		t.Errorf("Expected 1 result after the change, got %+v", results.Results)
+	}
+	if len(s.content.docs) != 1 {
+		t.Errorf("Expected 1 indexed file, got %d", len(s.content.docs))
+	}
+}
+
+// TestAPISearch tests the search endpoint of the JSON API
+func TestAPISearch(t *testing.T) {
+	sharedDir, uploadsDir := createSearchTree(t)
+	mux := newMux(Config{SharePath: sharedDir, UploadsDir: uploadsDir})
+
+	rr := serveAPI(mux, httptest.NewRequest("GET", "/api/v1/search?q=*.pdf", nil))
+	var results searchResults
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 2 || results.Results[0].Name != "invoice-2025-03.pdf" {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	// Content search needs to be enabled and queries must not be empty
+	for _, target := range []string{"/api/v1/search?q=plumber&content=true", "/api/v1/search"} {
+		if rr := serveAPI(mux, httptest.NewRequest("GET", target, nil)); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, got %d", target, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// TestSearchPage tests the rendered search page
+func TestSearchPage(t *testing.T) {
+	sharedDir, uploadsDir := createSearchTree(t)
+	s := newSearcher(getMounts(sharedDir, uploadsDir), true)
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest("GET", "/search?q=plumber&content=true", nil))
+	body := rr.Body.String()
+	if !strings.Contains(body, `href="/shared/finance/notes.md"`) || !strings.Contains(body, "Pay the plumber invoice") {
+		t.Error("Expected the matching file and line on the page")
+	}
+	if !strings.Contains(body, "1 match") {
+		t.Error("Expected the number of matches on the page")
+	}
+}
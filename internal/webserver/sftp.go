@@ -0,0 +1,489 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpServer serves the mounts over SFTP. Shared mounts are read-only, files
+// written to the uploads directory follow the same naming rules as HTTP uploads.
+type sftpServer struct {
+	config *ssh.ServerConfig
+	mounts []mount
+	// files records changes in the audit log and moves deleted files to the trash
+	files *fileManager
+	// fingerprint is the SHA-256 fingerprint of the host key, printed at startup
+	fingerprint string
+}
+
+// errDirNotEmpty is returned when removing a directory that still has entries
+var errDirNotEmpty = errors.New("directory not empty")
+
+// sftpRoleExtension is the SSH permission extension holding the role of a connection
+const sftpRoleExtension = "goshare-role"
+
+// newSFTPServer prepares the SSH configuration for the SFTP listener. Clients
+// authenticate with the key of a role as password, or with a key from the
+// authorized keys file, which grants the admin role.
+func newSFTPServer(cfg Config) (*sftpServer, error) {
+	authorizedKeys, err := loadAuthorizedKeys(cfg.SFTPAuthorizedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if role, ok := keyRole(string(password)); ok {
+				return &ssh.Permissions{Extensions: map[string]string{sftpRoleExtension: role}}, nil
+			}
+			log.Printf("%s sftp password rejected for %s", conn.RemoteAddr(), conn.User())
+			return nil, fmt.Errorf("invalid key")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, authorized := range authorizedKeys {
+				if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+					return &ssh.Permissions{Extensions: map[string]string{sftpRoleExtension: roleAdmin}}, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown public key")
+		},
+	}
+
+	hostKey, err := loadHostKey(cfg.SFTPHostKey)
+	if err != nil {
+		return nil, err
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	return &sftpServer{
+		config:      sshConfig,
+		mounts:      getMounts(cfg.SharePath, cfg.UploadsDir),
+		files:       newFileManager(cfg),
+		fingerprint: ssh.FingerprintSHA256(hostKey.PublicKey()),
+	}, nil
+}
+
+// startSFTP starts serving SFTP on cfg.SFTPAddr in the background
+func startSFTP(cfg Config) func(context.Context) error {
+	server, err := newSFTPServer(cfg)
+	if err != nil {
+		log.Fatalf("Error setting up SFTP server: %v", err)
+	}
+	listener, err := net.Listen("tcp", cfg.SFTPAddr)
+	if err != nil {
+		log.Fatalf("Error starting SFTP listener: %v", err)
+	}
+
+	log.Printf("Starting SFTP server on %s (host key %s)", listener.Addr(), server.fingerprint)
+	fmt.Fprintf(console, "SFTP: sftp -P %d goshare@<host> (password: %s, host key %s)\n",
+		listener.Addr().(*net.TCPAddr).Port, secretKey, server.fingerprint)
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("SFTP server stopped: %v", err)
+		}
+	}()
+
+	// Shutting down stops accepting sessions, running ones end with the process
+	return func(context.Context) error {
+		return listener.Close()
+	}
+}
+
+// loadAuthorizedKeys parses an OpenSSH authorized_keys file; an empty path allows no keys
+func loadAuthorizedKeys(filePath string) ([]ssh.PublicKey, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading authorized keys: %w", err)
+	}
+
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing authorized keys: %w", err)
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
+
+// loadHostKey reads a PEM encoded private host key, or generates a temporary
+// ed25519 key when no path is given
+func loadHostKey(filePath string) (ssh.Signer, error) {
+	if filePath != "" {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading host key: %w", err)
+		}
+		return ssh.ParsePrivateKey(data)
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(privateKey)
+}
+
+// Serve accepts SSH connections on the listener until it is closed
+func (s *sftpServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn runs the SSH handshake and serves SFTP sessions on the connection
+func (s *sftpServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		log.Printf("%s sftp handshake failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("%s sftp channel failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		// Only the sftp subsystem is offered, there is no shell
+		go func() {
+			for req := range channelRequests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+			}
+		}()
+
+		role := sshConn.Permissions.Extensions[sftpRoleExtension]
+		handler := &sftpHandler{mounts: s.mounts, files: s.files, remoteAddr: conn.RemoteAddr().String(), role: role, session: sftpSession(sshConn, role)}
+		server := sftp.NewRequestServer(channel, sftp.Handlers{
+			FileGet:  handler,
+			FilePut:  handler,
+			FileCmd:  handler,
+			FileList: handler,
+		})
+		go func() {
+			if err := server.Serve(); err != nil && err != io.EOF {
+				log.Printf("%s sftp session ended: %v", conn.RemoteAddr(), err)
+			}
+			server.Close()
+		}()
+	}
+}
+
+// sftpHandler implements the pkg/sftp request handlers on top of the mounts
+type sftpHandler struct {
+	mounts     []mount
+	files      *fileManager
+	remoteAddr string
+	// role is the role granted by the key the client logged in with
+	role string
+	// session identifies the client like sessionName does for HTTP requests
+	session string
+}
+
+// sftpSession returns the session name of an SSH connection logged in with role, see sessionName
+func sftpSession(conn ssh.ConnMetadata, role string) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+	return role + "@" + host
+}
+
+// sftpTarget is an SFTP path resolved against the mounts
+type sftpTarget struct {
+	root      bool
+	mount     mount
+	mountRoot bool
+	hostPath  string
+}
+
+// resolve maps an SFTP path onto the mounts
+func (h *sftpHandler) resolve(p string) (sftpTarget, error) {
+	mountName, rel := splitVirtualPath(p)
+	if mountName == "" {
+		return sftpTarget{root: true}, nil
+	}
+
+	m, ok := findMount(h.mounts, mountName)
+	if !ok {
+		return sftpTarget{}, os.ErrNotExist
+	}
+	hostPath, err := m.resolve(rel)
+	if err != nil {
+		return sftpTarget{}, os.ErrNotExist
+	}
+	return sftpTarget{mount: m, mountRoot: rel == "", hostPath: hostPath}, nil
+}
+
+// resolveWritable maps an SFTP path onto a writable mount, excluding the
+// mount root, for a session whose role is allowed by allowed
+func (h *sftpHandler) resolveWritable(p string, allowed func(string) bool) (sftpTarget, error) {
+	if !allowed(h.role) {
+		return sftpTarget{}, os.ErrPermission
+	}
+	target, err := h.resolve(p)
+	if err != nil {
+		return sftpTarget{}, err
+	}
+	if target.root || target.mountRoot || !target.mount.Writable {
+		return sftpTarget{}, os.ErrPermission
+	}
+	return target, nil
+}
+
+// audit records a change to the files in the audit log
+func (h *sftpHandler) audit(op, p string, err error) {
+	h.files.audit.record(auditEntry{Session: h.session, Op: op, Path: cleanVirtualPath(p), Error: errString(err)})
+}
+
+// Fileread implements sftp.FileReader
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	target, err := h.resolve(r.Filepath)
+	if err == nil && (target.root || target.mountRoot) {
+		err = os.ErrInvalid
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(target.hostPath)
+	}
+	if err != nil {
+		log.Printf("%s sftp read %s failed: %v", h.remoteAddr, r.Filepath, err)
+		return nil, err
+	}
+	throttled, err := h.throttle(r, f, false)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return throttled, nil
+}
+
+// throttle applies the transfer limits to a file opened by the request,
+// waiting for a transfer slot first
+func (h *sftpHandler) throttle(r *sftp.Request, f transferFile, upload bool) (*throttledFile, error) {
+	host, _, err := net.SplitHostPort(h.remoteAddr)
+	if err != nil {
+		host = h.remoteAddr
+	}
+	return transfers.throttleFile(r.Context(), host, f, upload)
+}
+
+// Filewrite implements sftp.FileWriter. Existing files are never overwritten,
+// a new upload gets a unique name just like HTTP uploads.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	target, err := h.resolveWritable(r.Filepath, canUpload)
+	var f *partialWriteFile
+	storedPath := r.Filepath
+	if err == nil {
+		f, err = createUploadFile(filepath.Dir(target.hostPath), path.Base(r.Filepath), h.session)
+	}
+	if err == nil {
+		storedPath = path.Join(path.Dir(r.Filepath), filepath.Base(f.Name()))
+	}
+	h.audit("upload", storedPath, err)
+	if err != nil {
+		return nil, err
+	}
+	throttled, err := h.throttle(r, f, true)
+	if err != nil {
+		// Nothing was written, so the new file is not kept
+		f.failed = true
+		f.Close()
+		return nil, err
+	}
+	return throttled, nil
+}
+
+// Filecmd implements sftp.FileCmder. Changes go through the file manager, so
+// they are recorded in the audit log and deleted items go to the trash.
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		// Permissions and times of uploads are managed by the server
+		return nil
+	case "Mkdir":
+		if _, err := h.resolveWritable(r.Filepath, canManage); err != nil {
+			return err
+		}
+		_, err := h.files.mkdir(h.session, r.Filepath)
+		return err
+	case "Remove", "Rmdir":
+		target, err := h.resolveWritable(r.Filepath, canManage)
+		if err == nil {
+			err = checkRemovable(target.hostPath, r.Method == "Rmdir")
+		}
+		if err != nil {
+			h.audit("delete", r.Filepath, err)
+			return err
+		}
+		_, err = h.files.remove(h.session, r.Filepath)
+		return err
+	case "Rename", "PosixRename":
+		source, err := h.resolveWritable(r.Filepath, canManage)
+		if err != nil {
+			return err
+		}
+		target, err := h.resolveWritable(r.Target, canManage)
+		if err != nil {
+			return err
+		}
+		if source.mount.Name != target.mount.Name {
+			return os.ErrPermission
+		}
+		_, err = h.files.move(h.session, "rename", r.Filepath, r.Target)
+		return err
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// checkRemovable makes sure Remove only deletes files and Rmdir only empty directories
+func checkRemovable(hostPath string, dir bool) error {
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() != dir {
+		return os.ErrInvalid
+	}
+	if dir {
+		entries, err := os.ReadDir(hostPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return errDirNotEmpty
+		}
+	}
+	return nil
+}
+
+// Filelist implements sftp.FileLister
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	target, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case "List":
+		infos, err := h.list(target)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(infos), nil
+	case "Stat", "Lstat":
+		info, err := h.stat(target)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// stat describes a resolved path, presenting mounts as directories
+func (h *sftpHandler) stat(target sftpTarget) (os.FileInfo, error) {
+	if target.root {
+		return virtualDirInfo{name: "/"}, nil
+	}
+	info, err := os.Stat(target.hostPath)
+	if target.mountRoot {
+		if err != nil && !target.mount.Writable {
+			return nil, err
+		}
+		dirInfo := virtualDirInfo{name: target.mount.Name}
+		if err == nil {
+			dirInfo.modTime = info.ModTime()
+		}
+		return dirInfo, nil
+	}
+	return info, err
+}
+
+// list returns the entries of a resolved directory
+func (h *sftpHandler) list(target sftpTarget) ([]os.FileInfo, error) {
+	if target.root {
+		var infos []os.FileInfo
+		for _, m := range h.mounts {
+			if info, err := h.stat(sftpTarget{mount: m, mountRoot: true, hostPath: m.Path}); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return infos, nil
+	}
+
+	info, err := os.Stat(target.hostPath)
+	if err != nil {
+		if target.mountRoot && target.mount.Writable && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		// Listing a file returns just that file, which also presents
+		// a single shared file as a directory holding it
+		return []os.FileInfo{info}, nil
+	}
+
+	entries, err := os.ReadDir(target.hostPath)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entryInfo, err := entry.Info(); err == nil {
+			infos = append(infos, entryInfo)
+		}
+	}
+	return infos, nil
+}
+
+// listerAt serves a fixed list of file infos to pkg/sftp
+type listerAt []os.FileInfo
+
+// ListAt implements sftp.ListerAt
+func (l listerAt) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
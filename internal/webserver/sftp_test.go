@@ -0,0 +1,222 @@
+package webserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPTestServer serves the config over SFTP on a random local port
+func startSFTPTestServer(t *testing.T, cfg Config) string {
+	t.Helper()
+
+	server, err := newSFTPServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener)
+
+	return listener.Addr().String()
+}
+
+// dialSFTP connects an SFTP client with the given authentication method
+func dialSFTP(t *testing.T, addr string, auth ssh.AuthMethod) (*sftp.Client, error) {
+	t.Helper()
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "goshare",
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return sftp.NewClient(conn)
+}
+
+// TestSFTP tests reading shares and writing uploads over SFTP
+func TestSFTP(t *testing.T) {
+	sharedDir := t.TempDir()
+	uploadsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sharedDir, "shared.txt"), []byte("shared content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(uploadsDir, "note.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startSFTPTestServer(t, Config{SharePath: sharedDir, UploadsDir: uploadsDir})
+	client, err := dialSFTP(t, addr, ssh.Password(secretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The root lists the mounts
+	infos, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Name() != "shared" || !infos[0].IsDir() {
+		t.Errorf("Unexpected root entries: %v", infos)
+	}
+
+	// Shared files can be read
+	f, err := client.Open("/shared/shared.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(content) != "shared content" {
+		t.Errorf("Unexpected shared content: %q (%v)", content, err)
+	}
+
+	// Shared files are read-only
+	if _, err := client.Create("/shared/new.txt"); err == nil {
+		t.Error("Expected writing to the shared directory to fail")
+	}
+	if err := client.Remove("/shared/shared.txt"); err == nil {
+		t.Error("Expected deleting a shared file to fail")
+	}
+
+	// Uploads never overwrite existing files
+	f, err = client.Create("/uploads/note.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("new"))
+	f.Close()
+
+	old, _ := os.ReadFile(filepath.Join(uploadsDir, "note.txt"))
+	stored, _ := os.ReadFile(filepath.Join(uploadsDir, "note.0.txt"))
+	if string(old) != "old" || string(stored) != "new" {
+		t.Errorf("Expected the upload to be stored under a new name, got %q and %q", old, stored)
+	}
+}
+
+// TestSFTPPublicKey tests logging in with an authorized public key and rejecting wrong passwords
+func TestSFTPPublicKey(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizedKeys := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := os.WriteFile(authorizedKeys, ssh.MarshalAuthorizedKey(sshPublicKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startSFTPTestServer(t, Config{UploadsDir: t.TempDir(), SFTPAuthorizedKeys: authorizedKeys})
+
+	if _, err := dialSFTP(t, addr, ssh.PublicKeys(signer)); err != nil {
+		t.Errorf("Expected public key login to succeed: %v", err)
+	}
+	if _, err := dialSFTP(t, addr, ssh.Password("wrong")); err == nil {
+		t.Error("Expected login with a wrong password to fail")
+	}
+}
+
+// TestSFTPRoles tests that the key a client logs in with limits what it may change
+func TestSFTPRoles(t *testing.T) {
+	uploadsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(uploadsDir, "note.txt"), []byte("note"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startSFTPTestServer(t, Config{UploadsDir: uploadsDir})
+
+	viewer, err := dialSFTP(t, addr, ssh.Password(roleKey(roleViewer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := viewer.Stat("/uploads/note.txt"); err != nil {
+		t.Errorf("Expected a viewer to see uploads, got %v", err)
+	}
+	if _, err := viewer.Create("/uploads/new.txt"); err == nil {
+		t.Error("Expected a viewer not to be allowed to upload")
+	}
+	if err := viewer.Remove("/uploads/note.txt"); err == nil {
+		t.Error("Expected a viewer not to be allowed to delete")
+	}
+	if err := viewer.Mkdir("/uploads/dir"); err == nil {
+		t.Error("Expected a viewer not to be allowed to create folders")
+	}
+
+	uploader, err := dialSFTP(t, addr, ssh.Password(roleKey(roleUploader)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := uploader.Create("/uploads/new.txt")
+	if err != nil {
+		t.Fatalf("Expected an uploader to be allowed to upload, got %v", err)
+	}
+	f.Write([]byte("new"))
+	f.Close()
+	if err := uploader.Rename("/uploads/new.txt", "/uploads/renamed.txt"); err != nil {
+		t.Errorf("Expected an uploader to be allowed to rename, got %v", err)
+	}
+}
+
+// TestSFTPRemoveToTrash tests that files and folders deleted over SFTP go to the trash and the audit log
+func TestSFTPRemoveToTrash(t *testing.T) {
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	if err := os.MkdirAll(filepath.Join(uploadsDir, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(uploadsDir, "dir", "note.txt"), []byte("note"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startSFTPTestServer(t, Config{UploadsDir: uploadsDir})
+	client, err := dialSFTP(t, addr, ssh.Password(secretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RemoveDirectory("/uploads/dir"); err == nil {
+		t.Error("Expected removing a folder that is not empty to fail")
+	}
+	if err := client.Remove("/uploads/dir/note.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveDirectory("/uploads/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := ListTrash(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || !strings.HasPrefix(items[0].DeletedBy, roleAdmin+"@") {
+		t.Errorf("Expected the file and the folder in the trash, got %+v", items)
+	}
+
+	audit, err := os.ReadFile(filepath.Join(stateDir(uploadsDir), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(audit), `"op":"delete"`) != 3 || !strings.Contains(string(audit), `"path":"uploads/dir/note.txt"`) {
+		t.Errorf("Expected the deletes in the audit log, got %s", audit)
+	}
+}
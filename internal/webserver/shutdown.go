@@ -0,0 +1,343 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long a shutdown waits for running transfers by default
+const DefaultShutdownTimeout = 30 * time.Second
+
+// transfer is a request that is still being served
+type transfer struct {
+	method     string
+	path       string
+	remoteAddr string
+	started    time.Time
+	bytes      atomic.Int64
+}
+
+// String describes the transfer for the console
+func (t *transfer) String() string {
+	return fmt.Sprintf("%s %s from %s (%s, %s)", t.method, t.path, t.remoteAddr,
+		formatSize(t.bytes.Load()), time.Since(t.started).Round(time.Second))
+}
+
+// transferTracker keeps track of the requests in progress
+type transferTracker struct {
+	mu         sync.Mutex
+	nextID     int
+	active     map[int]*transfer
+	lastActive time.Time
+}
+
+// newTransferTracker creates an empty transfer tracker
+func newTransferTracker() *transferTracker {
+	return &transferTracker{active: map[int]*transfer{}, lastActive: time.Now()}
+}
+
+// eventStream reports whether a request opens one of the streams that stay
+// open for as long as a page watches them, /events and /live
+func eventStream(r *http.Request) bool {
+	return r.URL.Path == "/events" || strings.HasPrefix(r.URL.Path, "/live/")
+}
+
+// middleware registers every request for as long as it is being served and
+// counts the bytes read from and written to the client. Event streams are
+// left out, an open page would otherwise keep the server busy forever.
+func (t *transferTracker) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if eventStream(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tr := &transfer{method: r.Method, path: r.URL.Path, remoteAddr: r.RemoteAddr, started: time.Now()}
+
+		t.mu.Lock()
+		id := t.nextID
+		t.nextID++
+		t.active[id] = tr
+		t.lastActive = time.Now()
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			delete(t.active, id)
+			t.lastActive = time.Now()
+			t.mu.Unlock()
+		}()
+
+		if r.Body != nil {
+			r.Body = &countingReadCloser{ReadCloser: r.Body, count: &tr.bytes}
+		}
+		next.ServeHTTP(&countingResponseWriter{ResponseWriter: w, count: &tr.bytes}, r)
+	})
+}
+
+// list returns the transfers in progress, oldest first
+func (t *transferTracker) list() []*transfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]*transfer, 0, len(t.active))
+	for _, tr := range t.active {
+		list = append(list, tr)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].started.Before(list[j].started) })
+	return list
+}
+
+// idleFor returns how long no request has been served; ok is false while requests are running
+func (t *transferTracker) idleFor() (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.active) > 0 {
+		return 0, false
+	}
+	return time.Since(t.lastActive), true
+}
+
+// printTransfers prints the transfers still in progress
+func (t *transferTracker) printTransfers() {
+	for _, tr := range t.list() {
+		fmt.Fprintf(console, "  %s\n", tr)
+	}
+}
+
+// countingReadCloser counts the bytes read from a request body
+type countingReadCloser struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// countingResponseWriter counts the bytes written to a response
+type countingResponseWriter struct {
+	http.ResponseWriter
+	count *atomic.Int64
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// partialFiles holds the files that are being written and would be left
+// half-written if the server stopped now
+var partialFiles = struct {
+	sync.Mutex
+	paths map[string]int
+}{paths: map[string]int{}}
+
+// trackPartialFile registers a file being written until the returned function is called
+func trackPartialFile(filePath string) func() {
+	partialFiles.Lock()
+	partialFiles.paths[filePath]++
+	partialFiles.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			partialFiles.Lock()
+			defer partialFiles.Unlock()
+			if partialFiles.paths[filePath]--; partialFiles.paths[filePath] <= 0 {
+				delete(partialFiles.paths, filePath)
+			}
+		})
+	}
+}
+
+// isPartialFile reports whether a file is still being written
+func isPartialFile(filePath string) bool {
+	partialFiles.Lock()
+	defer partialFiles.Unlock()
+	_, ok := partialFiles.paths[filePath]
+	return ok
+}
+
+// removePartialFiles deletes all files that are still being written
+func removePartialFiles() {
+	partialFiles.Lock()
+	defer partialFiles.Unlock()
+	for filePath := range partialFiles.paths {
+		if err := os.Remove(filePath); err == nil {
+			log.Printf("Removed partial file %s", filePath)
+		}
+		delete(partialFiles.paths, filePath)
+	}
+}
+
+// partialWriteFile is a file opened for writing that counts as partial until
+// it is closed. What the writes add to the file is counted against the upload quotas.
+type partialWriteFile struct {
+	*os.File
+	done  func()
+	quota *quotaWriter
+	// appending is set for files opened with os.O_APPEND, created for files that did not exist before
+	appending, created bool
+	// failed is set once a write did not fit into the quotas, or when the
+	// upload was given up before anything was written
+	failed bool
+}
+
+// Write implements io.Writer
+func (f *partialWriteFile) Write(p []byte) (int, error) {
+	if f.quota != nil {
+		// Appending writes at the end, everything else at the current offset
+		end := f.quota.size + int64(len(p))
+		if !f.appending {
+			offset, err := f.File.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return 0, err
+			}
+			end = offset + int64(len(p))
+		}
+		if err := f.quota.grow(end); err != nil {
+			f.failed = true
+			return 0, err
+		}
+	}
+	return f.File.Write(p)
+}
+
+// ReadFrom implements io.ReaderFrom; copying into the file must go through Write
+// instead of the ReadFrom of *os.File, which would not count anything
+func (f *partialWriteFile) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{f}, r)
+}
+
+// WriteString implements io.StringWriter
+func (f *partialWriteFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// WriteAt implements io.WriterAt
+func (f *partialWriteFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.quota.grow(off + int64(len(p))); err != nil {
+		f.failed = true
+		return 0, err
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *partialWriteFile) Close() error {
+	defer f.done()
+	err := f.File.Close()
+	// A new file that did not fit is not kept half written
+	if f.failed && f.created {
+		os.Remove(f.Name())
+		f.quota.finish("")
+		return err
+	}
+	if err != nil {
+		f.quota.finish("")
+		return err
+	}
+	f.quota.finish(f.Name())
+	notifyUploadFinished(f.Name())
+	return nil
+}
+
+// serveUntilSignal serves the listeners until SIGINT or SIGTERM arrives or a
+// stop is requested. It then stops accepting connections and waits up to
+// grace for running transfers; a second signal or an expired grace period
+// aborts them and removes the partially written files. The result of a
+// requested stop is returned once the server is down.
+func serveUntilSignal(server *http.Server, listeners []net.Listener, tracker *transferTracker, grace time.Duration, shutdowns []func(context.Context) error, stop <-chan error) error {
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		log.Printf("Starting server on %s", listener.Addr())
+		go func() {
+			errs <- server.Serve(listener)
+		}()
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var result error
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	case result = <-stop:
+		log.Printf("Shutting down")
+	}
+
+	// Stop accepting new connections and wait for the running transfers
+	if running := tracker.list(); len(running) > 0 {
+		fmt.Fprintf(console, "Waiting up to %s for %d running transfer(s), press Ctrl-C again to exit immediately:\n", grace, len(running))
+		tracker.printTransfers()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		var wg sync.WaitGroup
+		for _, shutdown := range shutdowns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				shutdown(ctx)
+			}()
+		}
+		err := server.Shutdown(ctx)
+		wg.Wait()
+		done <- err
+	}()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("Grace period of %s expired, aborting %d transfer(s)", grace, len(tracker.list()))
+				server.Close()
+				removePartialFiles()
+				os.Exit(1)
+			}
+			removePartialFiles()
+			checksums.flush()
+			log.Printf("Server stopped")
+			return result
+		case <-signals:
+			log.Printf("Forcing exit, aborting %d transfer(s)", len(tracker.list()))
+			server.Close()
+			removePartialFiles()
+			os.Exit(1)
+		case <-ticker.C:
+			if running := tracker.list(); len(running) > 0 {
+				fmt.Fprintf(console, "Still waiting for %d transfer(s):\n", len(running))
+				tracker.printTransfers()
+			}
+		}
+	}
+}
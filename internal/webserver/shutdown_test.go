@@ -0,0 +1,80 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTransferTracker checks that requests are listed while they are served
+func TestTransferTracker(t *testing.T) {
+	tracker := newTransferTracker()
+
+	var during []*transfer
+	handler := tracker.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello"))
+		during = tracker.list()
+	}))
+
+	req := httptest.NewRequest(http.MethodPut, "/uploads/a.txt", strings.NewReader("0123456789"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(during) != 1 || during[0].path != "/uploads/a.txt" {
+		t.Fatalf("Expected one running transfer, got %v", during)
+	}
+	if n := during[0].bytes.Load(); n != 15 {
+		t.Errorf("Expected 15 transferred bytes, got %d", n)
+	}
+	if running := tracker.list(); len(running) != 0 {
+		t.Errorf("Expected no running transfers after the request, got %v", running)
+	}
+}
+
+// failingReader returns some data and then an error, like an aborted upload
+type failingReader struct{ sent bool }
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.sent {
+		return 0, errors.New("connection reset")
+	}
+	f.sent = true
+	return copy(p, "partial"), nil
+}
+
+// TestSaveUploadRemovesPartialFile checks that an interrupted upload leaves no file behind
+func TestSaveUploadRemovesPartialFile(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := saveUpload(dir, "a.txt", "admin@test", &failingReader{}); err == nil {
+		t.Fatal("Expected an error for an interrupted upload")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial file to be removed, got %v", err)
+	}
+}
+
+// TestRemovePartialFiles checks that files still being written are removed
+func TestRemovePartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	writing := filepath.Join(dir, "writing.bin")
+	finished := filepath.Join(dir, "finished.bin")
+	os.WriteFile(writing, []byte("half"), 0o644)
+	os.WriteFile(finished, []byte("done"), 0o644)
+
+	trackPartialFile(writing)
+	trackPartialFile(finished)()
+	removePartialFiles()
+
+	if _, err := os.Stat(writing); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(finished); err != nil {
+		t.Errorf("Expected the finished file to be kept, got %v", err)
+	}
+}
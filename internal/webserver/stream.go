@@ -0,0 +1,171 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// StdinSharePath is the share path that serves standard input as a single streamed download
+const StdinSharePath = "-"
+
+// DefaultStreamName is the download name of a stream without an explicit name
+const DefaultStreamName = "stdin"
+
+// errStreamConsumed is returned when a stream is requested a second time
+var errStreamConsumed = errors.New("the stream has already been transferred")
+
+// streamName returns the sanitized download name of a streamed share
+func streamName(cfg Config) string {
+	if cfg.ShareName == "" {
+		return DefaultStreamName
+	}
+	return sanitizeFilename(cfg.ShareName)
+}
+
+// flushWriter flushes the response after every write, so clients see streamed data as it arrives
+type flushWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newFlushWriter(w http.ResponseWriter) flushWriter {
+	return flushWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		f.rc.Flush()
+	}
+	return n, err
+}
+
+// streamShare serves a reader as a single download. The reader cannot be
+// replayed, so only the first request gets the data.
+type streamShare struct {
+	name    string
+	src     io.Reader
+	claimed atomic.Bool
+	// done receives the result of the transfer once the stream was served
+	done chan error
+}
+
+// newStreamShare creates a one-time download of src under name
+func newStreamShare(name string, src io.Reader) *streamShare {
+	return &streamShare{name: name, src: src, done: make(chan error, 1)}
+}
+
+// url returns the download URL of the stream
+func (s *streamShare) url() string {
+	return "/shared/" + url.PathEscape(s.name)
+}
+
+// ServeHTTP streams the data to the first client with chunked transfer encoding
+func (s *streamShare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeTypeOf(s.name))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": s.name}))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if !s.claimed.CompareAndSwap(false, true) {
+		http.Error(w, errStreamConsumed.Error(), http.StatusGone)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	n, err := io.Copy(newFlushWriter(w), s.src)
+	if err != nil {
+		err = fmt.Errorf("streaming %s: %w", s.name, err)
+	} else {
+		log.Printf("Streamed %s (%s) to %s", s.name, formatSize(n), r.RemoteAddr)
+	}
+	s.done <- err
+}
+
+// streamReceiver writes the next upload to a writer instead of the uploads directory
+type streamReceiver struct {
+	dst     io.Writer
+	claimed atomic.Bool
+	// done receives the result of the transfer once an upload was received
+	done chan error
+}
+
+// newStreamReceiver creates a receiver writing the first upload to dst
+func newStreamReceiver(dst io.Writer) *streamReceiver {
+	return &streamReceiver{dst: dst, done: make(chan error, 1)}
+}
+
+// register routes the upload endpoints of mux to the receiver
+func (s *streamReceiver) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /upload", loggingMiddleware(requireKey(requireRole(canUpload, s.handleForm))))
+	mux.HandleFunc("PUT /uploads/", loggingMiddleware(requireKey(requireRole(canUpload, s.handlePut))))
+}
+
+// receive copies one upload to the destination, refusing every upload after the first
+func (s *streamReceiver) receive(name string, src io.Reader) (int64, error) {
+	if !s.claimed.CompareAndSwap(false, true) {
+		return 0, errStreamConsumed
+	}
+	n, err := io.Copy(s.dst, src)
+	if err != nil {
+		err = fmt.Errorf("receiving %s: %w", name, err)
+	} else {
+		log.Printf("Received %s (%s)", name, formatSize(n))
+	}
+	s.done <- err
+	return n, err
+}
+
+// handleForm receives the file of the upload form without buffering it on disk
+func (s *streamReceiver) handleForm(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Redirect(w, r, "/?message="+err.Error()+"&type=error", http.StatusSeeOther)
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			http.Redirect(w, r, "/?message=Error retrieving file: missing file&type=error", http.StatusSeeOther)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		if _, err := s.receive(part.FileName(), part); err != nil {
+			http.Redirect(w, r, "/?"+url.Values{"message": {"Error: " + err.Error()}, "type": {"error"}}.Encode(), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/?message=File sent successfully!&type=success", http.StatusSeeOther)
+		return
+	}
+}
+
+// handlePut receives the raw body of a PUT request
+func (s *streamReceiver) handlePut(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	n, err := s.receive(name, r.Body)
+	if errors.Is(err, errStreamConsumed) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "Received %d bytes\n", n)
+}
@@ -0,0 +1,70 @@
+package webserver
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestStreamShareOnce checks that a stream is served to the first client only
+func TestStreamShareOnce(t *testing.T) {
+	share := newStreamShare("db.sql", strings.NewReader("select 1;"))
+
+	rr := httptest.NewRecorder()
+	share.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, share.url(), nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "select 1;" {
+		t.Errorf("Expected the stream content, got %d %q", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Header().Get("Content-Disposition"), "db.sql") {
+		t.Errorf("Expected the download name in Content-Disposition, got %q", rr.Header().Get("Content-Disposition"))
+	}
+	if err := <-share.done; err != nil {
+		t.Errorf("Expected the stream to finish, got %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	share.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, share.url(), nil))
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected status code %d for a second download, got %d", http.StatusGone, rr.Code)
+	}
+}
+
+// TestStreamReceiver checks that the first upload is written to the destination
+func TestStreamReceiver(t *testing.T) {
+	var out bytes.Buffer
+	receiver := newStreamReceiver(&out)
+	mux := http.NewServeMux()
+	receiver.register(mux)
+
+	// Upload through the form
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "a.txt")
+	part.Write([]byte("form content"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if out.String() != "form content" {
+		t.Errorf("Expected the upload on the destination, got %q", out.String())
+	}
+	if err := <-receiver.done; err != nil {
+		t.Errorf("Expected the upload to finish, got %v", err)
+	}
+
+	// Further uploads are refused
+	req = httptest.NewRequest(http.MethodPut, "/uploads/b.txt", strings.NewReader("more"))
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected status code %d for a second upload, got %d", http.StatusGone, rr.Code)
+	}
+}
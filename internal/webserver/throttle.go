@@ -0,0 +1,429 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most a throttled transfer sends or receives at once,
+// which keeps the rate smooth instead of bursty
+const throttleChunk = 16 << 10
+
+// transfers limits the bandwidth and number of the transfers of the running server
+var transfers = newTransferLimiter()
+
+// transferLimits are the bandwidth and concurrency limits of transfers; 0 means unlimited
+type transferLimits struct {
+	// DownloadRate and UploadRate are the bytes per second of all clients together
+	DownloadRate int64 `json:"download_rate"`
+	UploadRate   int64 `json:"upload_rate"`
+	// ClientDownloadRate and ClientUploadRate are the bytes per second of every client
+	ClientDownloadRate int64 `json:"client_download_rate"`
+	ClientUploadRate   int64 `json:"client_upload_rate"`
+	// MaxTransfers is the number of transfers running at once; more wait in a queue
+	MaxTransfers int `json:"max_transfers"`
+}
+
+// formatRate returns e.g. "1.0 MB/s", or "unlimited" for 0
+func formatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return formatSize(rate) + "/s"
+}
+
+// String sums up the limits for the log, e.g. "downloads 1.0 MB/s (256.0 KB/s per client), uploads unlimited, 4 transfers at once"
+func (l transferLimits) String() string {
+	describe := func(total, client int64) string {
+		if client <= 0 {
+			return formatRate(total)
+		}
+		return fmt.Sprintf("%s (%s per client)", formatRate(total), formatRate(client))
+	}
+	concurrency := "no limit on concurrent transfers"
+	if l.MaxTransfers > 0 {
+		concurrency = fmt.Sprintf("%d transfers at once", l.MaxTransfers)
+	}
+	return fmt.Sprintf("downloads %s, uploads %s, %s",
+		describe(l.DownloadRate, l.ClientDownloadRate), describe(l.UploadRate, l.ClientUploadRate), concurrency)
+}
+
+// limitsStatus is the response body of the limits endpoint
+type limitsStatus struct {
+	transferLimits
+	// Active and Queued are the transfers running and waiting right now
+	Active int `json:"active"`
+	Queued int `json:"queued"`
+}
+
+// tokenBucket lets bytes pass at a steady rate, allowing short bursts
+type tokenBucket struct {
+	mu sync.Mutex
+	// rate is in bytes per second, 0 lets everything pass
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// setRate changes the rate of the bucket, taking effect for the next bytes
+func (b *tokenBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(rate)
+	b.tokens = min(b.tokens, b.burst())
+	b.last = time.Now()
+}
+
+// burst is the number of bytes that may pass at once after a pause; b.mu must be held
+func (b *tokenBucket) burst() float64 {
+	return max(b.rate/10, throttleChunk)
+}
+
+// wait blocks until n bytes may pass or ctx is done
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	b.tokens = min(b.burst(), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	// Taking the bytes right away puts later callers behind this one
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// clientBuckets are the buckets of one client, shared by all of its transfers
+type clientBuckets struct {
+	down, up tokenBucket
+	// transfers counts the running transfers, the buckets are dropped when it reaches 0
+	transfers int
+}
+
+// transferLimiter throttles transfers and caps how many run at once, queueing the rest in order
+type transferLimiter struct {
+	mu       sync.Mutex
+	limits   transferLimits
+	down, up tokenBucket
+	clients  map[string]*clientBuckets
+	active   int
+	// queue holds a channel for every waiting transfer, closed when it may start
+	queue []chan struct{}
+}
+
+// newTransferLimiter returns a limiter without any limits
+func newTransferLimiter() *transferLimiter {
+	return &transferLimiter{clients: map[string]*clientBuckets{}}
+}
+
+// get returns the current limits and the number of running and waiting transfers
+func (l *transferLimiter) get() limitsStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return limitsStatus{transferLimits: l.limits, Active: l.active, Queued: len(l.queue)}
+}
+
+// set changes the limits of running and future transfers
+func (l *transferLimiter) set(limits transferLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	l.down.setRate(limits.DownloadRate)
+	l.up.setRate(limits.UploadRate)
+	for _, c := range l.clients {
+		c.down.setRate(limits.ClientDownloadRate)
+		c.up.setRate(limits.ClientUploadRate)
+	}
+	// A higher cap lets waiting transfers start
+	l.dispatchLocked()
+}
+
+// acquire waits for a free transfer slot, in order of arrival
+func (l *transferLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.limits.MaxTransfers <= 0 || (l.active < l.limits.MaxTransfers && len(l.queue) == 0) {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	log.Printf("Transfer queued, %d waiting for one of %d slots", len(l.queue), l.limits.MaxTransfers)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, waiting := range l.queue {
+			if waiting == ready {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The slot was handed over while the client gave up
+		l.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+// release frees the slot of a finished transfer
+func (l *transferLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+// releaseLocked frees a slot; l.mu must be held
+func (l *transferLimiter) releaseLocked() {
+	l.active--
+	l.dispatchLocked()
+}
+
+// dispatchLocked starts waiting transfers while there are free slots; l.mu must be held
+func (l *transferLimiter) dispatchLocked() {
+	for len(l.queue) > 0 && (l.limits.MaxTransfers <= 0 || l.active < l.limits.MaxTransfers) {
+		close(l.queue[0])
+		l.queue = l.queue[1:]
+		l.active++
+	}
+}
+
+// client returns the buckets of the client at host for a new transfer
+func (l *transferLimiter) client(host string) *clientBuckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[host]
+	if !ok {
+		c = &clientBuckets{}
+		c.down.setRate(l.limits.ClientDownloadRate)
+		c.up.setRate(l.limits.ClientUploadRate)
+		l.clients[host] = c
+	}
+	c.transfers++
+	return c
+}
+
+// releaseClient drops the buckets of the client at host once its last transfer finished
+func (l *transferLimiter) releaseClient(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c := l.clients[host]; c != nil {
+		if c.transfers--; c.transfers <= 0 {
+			delete(l.clients, host)
+		}
+	}
+}
+
+// isTransfer reports whether a request downloads or uploads a file, as
+// opposed to pages, listings and live streams that never end
+func isTransfer(r *http.Request) bool {
+	p := r.URL.Path
+	switch r.Method {
+	case http.MethodGet:
+		return strings.HasPrefix(p, "/shared/") || strings.HasPrefix(p, "/uploads/") || strings.HasPrefix(p, "/dav/")
+	case http.MethodPost:
+		return p == "/upload" || strings.HasPrefix(p, "/api/v1/files/")
+	case http.MethodPut:
+		return strings.HasPrefix(p, "/uploads/") || strings.HasPrefix(p, "/dav/")
+	}
+	return false
+}
+
+// isS3Transfer reports whether an S3 request reads or writes an object
+func isS3Transfer(r *http.Request) bool {
+	if _, key := splitVirtualPath(r.URL.Path); key == "" {
+		return false
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodPut
+}
+
+// middleware throttles and queues file transfers
+func (l *transferLimiter) middleware(next http.Handler) http.Handler {
+	return l.limit(next, isTransfer)
+}
+
+// limit throttles and queues the requests to next that transfer reports as file transfers
+func (l *transferLimiter) limit(next http.Handler, transfer func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !transfer(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := l.acquire(r.Context()); err != nil {
+			return
+		}
+		defer l.release()
+
+		host := clientHost(r)
+		c := l.client(host)
+		defer l.releaseClient(host)
+
+		ctx := r.Context()
+		if r.Body != nil {
+			r.Body = &throttledBody{ReadCloser: r.Body, ctx: ctx, buckets: []*tokenBucket{&c.up, &l.up}}
+		}
+		next.ServeHTTP(&throttledWriter{ResponseWriter: w, ctx: ctx, buckets: []*tokenBucket{&c.down, &l.down}}, r)
+	})
+}
+
+// throttledWriter sends a response no faster than its buckets allow
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		for _, b := range t.buckets {
+			if err := b.wait(t.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+		n, err := t.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// throttledBody receives a request body no faster than its buckets allow
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p[:min(len(p), throttleChunk)])
+	for _, b := range t.buckets {
+		if waitErr := b.wait(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// transferFile is a file read or written outside of HTTP, e.g. over SFTP
+type transferFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// throttledFile reads or writes a file no faster than its buckets allow and
+// holds a transfer slot until it is closed
+type throttledFile struct {
+	transferFile
+	ctx     context.Context
+	buckets []*tokenBucket
+	release func()
+	once    sync.Once
+}
+
+// throttleFile waits for a transfer slot for a file transferred by the client
+// at host and throttles it like HTTP downloads or, with upload, uploads
+func (l *transferLimiter) throttleFile(ctx context.Context, host string, f transferFile, upload bool) (*throttledFile, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	c := l.client(host)
+	buckets := []*tokenBucket{&c.down, &l.down}
+	if upload {
+		buckets = []*tokenBucket{&c.up, &l.up}
+	}
+	return &throttledFile{
+		transferFile: f,
+		ctx:          ctx,
+		buckets:      buckets,
+		release: func() {
+			l.releaseClient(host)
+			l.release()
+		},
+	}, nil
+}
+
+// wait blocks until n bytes may pass all buckets
+func (t *throttledFile) wait(n int) error {
+	for _, b := range t.buckets {
+		if err := b.wait(t.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *throttledFile) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		if err := t.wait(len(chunk)); err != nil {
+			return read, err
+		}
+		n, err := t.transferFile.ReadAt(chunk, off)
+		read += n
+		off += int64(n)
+		if err != nil {
+			return read, err
+		}
+		p = p[n:]
+	}
+	return read, nil
+}
+
+func (t *throttledFile) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		if err := t.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := t.transferFile.WriteAt(chunk, off)
+		written += n
+		off += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the file and frees its transfer slot
+func (t *throttledFile) Close() error {
+	t.once.Do(t.release)
+	return t.transferFile.Close()
+}
@@ -0,0 +1,187 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestTokenBucketRate tests that a bucket lets bytes pass at its rate after the initial burst
+func TestTokenBucketRate(t *testing.T) {
+	var b tokenBucket
+	b.setRate(1 << 20)
+
+	start := time.Now()
+	// The burst of 100 KB passes right away, the remaining 200 KB take about 200ms
+	for range 300 {
+		if err := b.wait(context.Background(), 1<<10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected about 200ms for 300 KB at 1 MB/s, took %s", elapsed)
+	}
+
+	// Without a rate nothing is held back
+	b.setRate(0)
+	start = time.Now()
+	b.wait(context.Background(), 100<<20)
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("Expected an unlimited bucket not to wait, took %s", elapsed)
+	}
+}
+
+// TestTransferQueue tests that transfers beyond the cap wait in order and start when the cap is raised
+func TestTransferQueue(t *testing.T) {
+	l := newTransferLimiter()
+	l.set(transferLimits{MaxTransfers: 1})
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan int, 3)
+	for i := range 3 {
+		go func() {
+			l.acquire(context.Background())
+			started <- i
+		}()
+		// Let every transfer join the queue before the next one
+		for l.get().Queued != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A waiting client that gives up leaves the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.acquire(ctx); err == nil {
+		t.Error("Expected a cancelled transfer not to start")
+	}
+	if status := l.get(); status.Active != 1 || status.Queued != 3 {
+		t.Errorf("Expected 1 running and 3 queued transfers, got %+v", status)
+	}
+
+	l.release()
+	if first := <-started; first != 0 {
+		t.Errorf("Expected the first queued transfer to start first, got %d", first)
+	}
+
+	// Raising the cap starts the rest right away
+	l.set(transferLimits{MaxTransfers: 3})
+	<-started
+	<-started
+	if status := l.get(); status.Active != 3 || status.Queued != 0 {
+		t.Errorf("Expected 3 running transfers, got %+v", status)
+	}
+}
+
+// TestAPILimits tests that admins can change the limits of the running server
+func TestAPILimits(t *testing.T) {
+	defer transfers.set(transferLimits{})
+	mux := newMux(Config{UploadsDir: t.TempDir()})
+
+	// Viewers cannot see or change the limits
+	req := httptest.NewRequest("PATCH", "/api/v1/limits", strings.NewReader(`{"max_transfers": 1}`))
+	rr := serveAs(mux, roleViewer, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a viewer, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	transfers.set(transferLimits{DownloadRate: 1 << 20, MaxTransfers: 4})
+	req = httptest.NewRequest("PATCH", "/api/v1/limits", strings.NewReader(`{"client_upload_rate": 65536, "max_transfers": 2}`))
+	rr = serveAPI(mux, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var status limitsStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	// Limits missing from the body are kept
+	expected := transferLimits{DownloadRate: 1 << 20, ClientUploadRate: 65536, MaxTransfers: 2}
+	if status.transferLimits != expected || transfers.get().transferLimits != expected {
+		t.Errorf("Expected limits %+v, got %+v", expected, status.transferLimits)
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/v1/limits", strings.NewReader(`{"upload_rate": -1}`))
+	if rr := serveAPI(mux, req); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a negative rate, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestThrottleOtherProtocols tests that SFTP and S3 transfers are held to the rate limits too
+func TestThrottleOtherProtocols(t *testing.T) {
+	transfers.set(transferLimits{DownloadRate: 1 << 20, UploadRate: 1 << 20})
+	defer transfers.set(transferLimits{})
+
+	uploadsDir := t.TempDir()
+	content := bytes.Repeat([]byte("x"), 300<<10)
+	if err := os.WriteFile(filepath.Join(uploadsDir, "big.bin"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{UploadsDir: uploadsDir}
+
+	sftpClient, err := dialSFTP(t, startSFTPTestServer(t, cfg), ssh.Password(secretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessKeyID, secretAccessKey := s3Credentials()
+	s3Client := newS3TestClient(t, cfg, accessKeyID, secretAccessKey)
+	ctx := context.Background()
+
+	// The burst of 100 KB passes right away, the remaining 200 KB take about 200ms
+	for name, transfer := range map[string]func() error{
+		"SFTP download": func() error {
+			f, err := sftpClient.Open("/uploads/big.bin")
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(io.Discard, f)
+			return err
+		},
+		"SFTP upload": func() error {
+			f, err := sftpClient.Create("/uploads/sftp.bin")
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(content); err != nil {
+				return err
+			}
+			return f.Close()
+		},
+		"S3 download": func() error {
+			out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("uploads"), Key: aws.String("big.bin")})
+			if err != nil {
+				return err
+			}
+			defer out.Body.Close()
+			_, err = io.Copy(io.Discard, out.Body)
+			return err
+		},
+		"S3 upload": func() error {
+			_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("uploads"), Key: aws.String("s3.bin"), Body: bytes.NewReader(content)})
+			return err
+		},
+	} {
+		start := time.Now()
+		if err := transfer(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("%s: expected about 200ms for 300 KB at 1 MB/s, took %s", name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package webserver
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Thumbnail sizes, the longest side in pixels
+const (
+	// thumbSmall is used for the tiles of the gallery
+	thumbSmall = 256
+	// thumbLarge is used by the lightbox instead of downloading the original
+	thumbLarge = 1600
+)
+
+// thumbCacheSize is the number of bytes of thumbnails kept in memory
+const thumbCacheSize = 64 << 20
+
+// thumbMaxPixels protects the server from decoding huge images
+const thumbMaxPixels = 100_000_000
+
+// imageExtensions are the file types thumbnails are generated for
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+// isImageFile reports whether a thumbnail can be generated for a file
+func isImageFile(name string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// thumbnails caches generated thumbnails for all servers of the process
+var thumbnails = newThumbCache(thumbCacheSize)
+
+// thumbSlots limits how many images are decoded at the same time
+var thumbSlots = make(chan struct{}, runtime.NumCPU())
+
+// thumbKey identifies a thumbnail of a particular version of a file
+type thumbKey struct {
+	path    string
+	size    int
+	modTime time.Time
+	length  int64
+}
+
+// thumbCacheItem is a thumbnail in the cache
+type thumbCacheItem struct {
+	key  thumbKey
+	data []byte
+}
+
+// thumbCache is an in-memory LRU cache of thumbnails bounded by their total size
+type thumbCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	used     int
+	order    *list.List
+	items    map[thumbKey]*list.Element
+}
+
+// newThumbCache creates an empty cache holding up to maxBytes of thumbnails
+func newThumbCache(maxBytes int) *thumbCache {
+	return &thumbCache{maxBytes: maxBytes, order: list.New(), items: map[thumbKey]*list.Element{}}
+}
+
+// get returns a cached thumbnail
+func (c *thumbCache) get(key thumbKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*thumbCacheItem).data, true
+}
+
+// put adds a thumbnail, evicting the least recently used ones when the cache is full
+func (c *thumbCache) put(key thumbKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; ok || len(data) > c.maxBytes {
+		return
+	}
+	c.items[key] = c.order.PushFront(&thumbCacheItem{key: key, data: data})
+	c.used += len(data)
+	for c.used > c.maxBytes {
+		oldest := c.order.Back()
+		item := oldest.Value.(*thumbCacheItem)
+		c.order.Remove(oldest)
+		delete(c.items, item.key)
+		c.used -= len(item.data)
+	}
+}
+
+// makeThumbnail decodes an image and returns a JPEG whose longest side is at
+// most size pixels, turned upright according to its EXIF orientation
+func makeThumbnail(hostPath string, size int) ([]byte, error) {
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Check the dimensions before decoding the whole image
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > thumbMaxPixels {
+		return nil, fmt.Errorf("image is too large (%dx%d)", config.Width, config.Height)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		orientation = exifOrientation(f)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scale down to fit into a size x size box; smaller images keep their size
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	// Transparent parts of PNG, GIF and WebP images are shown on white
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, orient(dst, orientation), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exifOrientation returns the EXIF orientation (1-8) of a JPEG image, or 1
+// if the image has none
+func exifOrientation(r io.Reader) int {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || header != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+
+	// Walk the segments up to the image data looking for the Exif APP1 segment
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF || marker[1] == 0xDA {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return 1
+		}
+		if marker[1] != 0xE1 {
+			if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+				return 1
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+		if tiff, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+			return tiffOrientation(tiff)
+		}
+	}
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of TIFF data
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// orient transforms an image stored with the given EXIF orientation so it is upright
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	// Orientations 5 to 8 are rotated by 90 degrees and swap width and height
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := range height {
+		for x := range width {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = width-1-x, y
+			case 3: // Rotated by 180 degrees
+				dx, dy = width-1-x, height-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, height-1-y
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Rotated by 90 degrees clockwise
+				dx, dy = height-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = height-1-y, width-1-x
+			case 8: // Rotated by 90 degrees counterclockwise
+				dx, dy = y, width-1-x
+			}
+			dst.SetRGBA(dx, dy, img.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// thumbnailHandler serves thumbnails of the images in the mounts under /thumb/.
+// The size query parameter selects "small" (default) or "large" thumbnails.
+func thumbnailHandler(mounts []mount) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		p := cleanVirtualPath(strings.TrimPrefix(r.URL.Path, "/thumb"))
+		hostPath, err := resolveVirtualFile(mounts, p)
+		if err != nil || !isImageFile(p) {
+			http.NotFound(w, r)
+			return
+		}
+		info, err := os.Stat(hostPath)
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		size := thumbSmall
+		if r.URL.Query().Get("size") == "large" {
+			size = thumbLarge
+		}
+		key := thumbKey{path: hostPath, size: size, modTime: info.ModTime(), length: info.Size()}
+
+		// Browsers keep thumbnails until the image changes
+		etag := fmt.Sprintf(`"%x-%x-%d"`, info.ModTime().UnixNano(), info.Size(), size)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		data, ok := thumbnails.get(key)
+		if !ok {
+			thumbSlots <- struct{}{}
+			data, err = makeThumbnail(hostPath, size)
+			<-thumbSlots
+			if err != nil {
+				http.Error(w, "Cannot create thumbnail: "+err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+			thumbnails.put(key, data)
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package webserver
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// jpegWithOrientation encodes img as JPEG with an Exif segment holding the orientation
+func jpegWithOrientation(t *testing.T, img image.Image, orientation byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, 0, 0, 0, 0}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := append([]byte{0xFF, 0xE1, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}, segment...)
+	return append(append([]byte{0xFF, 0xD8}, app1...), buf.Bytes()[2:]...)
+}
+
+// TestExifOrientation tests reading the orientation of JPEG images
+func TestExifOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	if got := exifOrientation(bytes.NewReader(jpegWithOrientation(t, img, 6))); got != 6 {
+		t.Errorf("Expected orientation 6, got %d", got)
+	}
+
+	var plain bytes.Buffer
+	jpeg.Encode(&plain, img, nil)
+	if got := exifOrientation(&plain); got != 1 {
+		t.Errorf("Expected orientation 1 without Exif, got %d", got)
+	}
+	if got := exifOrientation(strings.NewReader("not a jpeg")); got != 1 {
+		t.Errorf("Expected orientation 1 for other data, got %d", got)
+	}
+}
+
+// TestOrient tests turning images upright
+func TestOrient(t *testing.T) {
+	// A 2x1 image with a red pixel on the left
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+
+	tests := []struct {
+		orientation int
+		bounds      image.Rectangle
+		red         image.Point
+	}{
+		{1, image.Rect(0, 0, 2, 1), image.Pt(0, 0)},
+		{2, image.Rect(0, 0, 2, 1), image.Pt(1, 0)},
+		{3, image.Rect(0, 0, 2, 1), image.Pt(1, 0)},
+		{6, image.Rect(0, 0, 1, 2), image.Pt(0, 0)},
+		{8, image.Rect(0, 0, 1, 2), image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		got := orient(img, tt.orientation)
+		if got.Bounds() != tt.bounds {
+			t.Errorf("Orientation %d: expected bounds %v, got %v", tt.orientation, tt.bounds, got.Bounds())
+			continue
+		}
+		if got.RGBAAt(tt.red.X, tt.red.Y).R != 255 {
+			t.Errorf("Orientation %d: expected the red pixel at %v", tt.orientation, tt.red)
+		}
+	}
+}
+
+// TestMakeThumbnail tests scaling PNG and rotated JPEG images
+func TestMakeThumbnail(t *testing.T) {
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1000, 500)))
+	os.WriteFile(filepath.Join(dir, "wide.png"), buf.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(dir, "rotated.jpg"), jpegWithOrientation(t, image.NewRGBA(image.Rect(0, 0, 400, 200)), 6), 0o644)
+	os.WriteFile(filepath.Join(dir, "broken.png"), []byte("not an image"), 0o644)
+
+	tests := []struct {
+		name          string
+		size          int
+		width, height int
+	}{
+		{"wide.png", thumbSmall, 256, 128},
+		{"rotated.jpg", thumbSmall, 128, 256},
+		{"rotated.jpg", thumbLarge, 200, 400},
+	}
+	for _, tt := range tests {
+		data, err := makeThumbnail(filepath.Join(dir, tt.name), tt.size)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || format != "jpeg" {
+			t.Fatalf("%s: expected a JPEG thumbnail, got %s (%v)", tt.name, format, err)
+		}
+		if config.Width != tt.width || config.Height != tt.height {
+			t.Errorf("%s: expected %dx%d, got %dx%d", tt.name, tt.width, tt.height, config.Width, config.Height)
+		}
+	}
+
+	if _, err := makeThumbnail(filepath.Join(dir, "broken.png"), thumbSmall); err == nil {
+		t.Error("Expected an error for a broken image")
+	}
+}
+
+// TestThumbCache tests that the least recently used thumbnails are evicted
+func TestThumbCache(t *testing.T) {
+	cache := newThumbCache(10)
+	cache.put(thumbKey{path: "a"}, make([]byte, 4))
+	cache.put(thumbKey{path: "b"}, make([]byte, 4))
+	cache.get(thumbKey{path: "a"})
+	cache.put(thumbKey{path: "c"}, make([]byte, 4))
+
+	if _, ok := cache.get(thumbKey{path: "b"}); ok {
+		t.Error("Expected b to be evicted")
+	}
+	if _, ok := cache.get(thumbKey{path: "a"}); !ok {
+		t.Error("Expected a to be kept")
+	}
+	if cache.used != 8 {
+		t.Errorf("Expected 8 bytes in use, got %d", cache.used)
+	}
+}
+
+// TestGalleryAndThumbnails tests the gallery page and the thumbnails it links to
+func TestGalleryAndThumbnails(t *testing.T) {
+	uploadsDir := t.TempDir()
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 300, 300)))
+	os.WriteFile(filepath.Join(uploadsDir, "cat.png"), buf.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, "notes.txt"), []byte("notes"), 0o644)
+	os.Mkdir(filepath.Join(uploadsDir, "holiday"), 0o755)
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	req := httptest.NewRequest("GET", "/gallery/uploads/", nil)
+	req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(body, `src="/thumb/uploads/cat.png"`) || !strings.Contains(body, `loading="lazy"`) {
+		t.Error("Expected a lazily loaded thumbnail")
+	}
+	if !strings.Contains(body, `href="/gallery/uploads/holiday/"`) || !strings.Contains(body, "1 other file not shown") {
+		t.Error("Expected the folder link and the number of other files")
+	}
+
+	req = httptest.NewRequest("GET", "/thumb/uploads/cat.png", nil)
+	req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Expected a JPEG thumbnail, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	// Unchanged images are not sent again
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotModified, rr.Code)
+	}
+
+	// Only images get thumbnails
+	req = httptest.NewRequest("GET", "/thumb/uploads/notes.txt", nil)
+	req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
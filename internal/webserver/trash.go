@@ -0,0 +1,238 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTrashRetention is how long deleted items are kept in the trash by default
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// trashPurgeInterval is how often the trash is checked for expired items
+const trashPurgeInterval = time.Hour
+
+// errNotInTrash is returned for trash IDs that do not exist
+var errNotInTrash = errors.New("no such item in the trash")
+
+// stateDir returns the hidden directory next to the uploads directory that
+// holds the trash and the audit log, e.g. ".uploads-goshare" for "uploads"
+func stateDir(uploadsDir string) string {
+	if abs, err := filepath.Abs(uploadsDir); err == nil {
+		uploadsDir = abs
+	}
+	return filepath.Join(filepath.Dir(uploadsDir), "."+filepath.Base(uploadsDir)+"-goshare")
+}
+
+// TrashItem describes a deleted file or directory kept in the trash
+type TrashItem struct {
+	ID string `json:"id"`
+	// Path is the virtual path the item was deleted from
+	Path string `json:"path"`
+	// HostPath is the location the item was deleted from on the host
+	HostPath string `json:"host_path"`
+	IsDir    bool   `json:"is_dir"`
+	Size     int64  `json:"size"`
+	// DeletedBy is the session that deleted the item, see sessionName
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// trash keeps deleted items so they can be restored. Every item is moved to
+// <dir>/<id>/ together with a <dir>/<id>.json file describing it.
+type trash struct {
+	dir string
+}
+
+// newTrash returns the trash of an uploads directory
+func newTrash(uploadsDir string) *trash {
+	return &trash{dir: filepath.Join(stateDir(uploadsDir), "trash")}
+}
+
+// newTrashID returns a unique ID that sorts by deletion time
+func newTrashID() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(random))
+}
+
+// add moves the item at hostPath, exposed as the virtual path p, into the trash
+func (t *trash) add(hostPath, p, deletedBy string) (TrashItem, error) {
+	// Restoring must work no matter which directory goshare is started in
+	if abs, err := filepath.Abs(hostPath); err == nil {
+		hostPath = abs
+	}
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return TrashItem{}, err
+	}
+
+	item := TrashItem{
+		ID:        newTrashID(),
+		Path:      p,
+		HostPath:  hostPath,
+		IsDir:     info.IsDir(),
+		Size:      info.Size(),
+		DeletedBy: deletedBy,
+		DeletedAt: time.Now().UTC(),
+	}
+	if info.IsDir() {
+		item.Size = 0
+	}
+
+	itemDir := filepath.Join(t.dir, item.ID)
+	if err := os.MkdirAll(itemDir, 0o700); err != nil {
+		return TrashItem{}, fmt.Errorf("creating trash: %w", err)
+	}
+	metadata, _ := json.MarshalIndent(item, "", "  ")
+	if err := os.WriteFile(itemDir+".json", metadata, 0o600); err != nil {
+		os.Remove(itemDir)
+		return TrashItem{}, fmt.Errorf("writing trash metadata: %w", err)
+	}
+
+	// Renaming keeps the item on the same filesystem, so even large files are moved instantly
+	if err := os.Rename(hostPath, filepath.Join(itemDir, info.Name())); err != nil {
+		os.Remove(itemDir + ".json")
+		os.Remove(itemDir)
+		return TrashItem{}, fmt.Errorf("moving to trash: %w", err)
+	}
+	return item, nil
+}
+
+// get returns the metadata of the item with the given ID
+func (t *trash) get(id string) (TrashItem, error) {
+	// IDs are file names in the trash directory, anything else would escape it
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return TrashItem{}, errNotInTrash
+	}
+	data, err := os.ReadFile(filepath.Join(t.dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return TrashItem{}, errNotInTrash
+	}
+	if err != nil {
+		return TrashItem{}, err
+	}
+	var item TrashItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return TrashItem{}, fmt.Errorf("reading trash metadata: %w", err)
+	}
+	return item, nil
+}
+
+// list returns the items in the trash, most recently deleted first
+func (t *trash) list() ([]TrashItem, error) {
+	metadata, err := filepath.Glob(filepath.Join(t.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var items []TrashItem
+	for _, file := range metadata {
+		item, err := t.get(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			log.Printf("Skipping trash item %s: %v", file, err)
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+// restore moves the item with the given ID back to where it was deleted from.
+// If another item took its place in the meantime, it is restored under a new
+// name like uploads/notes.0.txt, which the returned item reports.
+func (t *trash) restore(id string) (TrashItem, error) {
+	item, err := t.get(id)
+	if err != nil {
+		return TrashItem{}, err
+	}
+
+	dir, name := filepath.Split(item.HostPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return TrashItem{}, err
+	}
+	newName := getUniqueFilename(dir, name)
+	if err := os.Rename(filepath.Join(t.dir, id, name), filepath.Join(dir, newName)); err != nil {
+		return TrashItem{}, fmt.Errorf("restoring from trash: %w", err)
+	}
+	item.HostPath = filepath.Join(dir, newName)
+	item.Path = path.Join(path.Dir(item.Path), newName)
+
+	t.remove(id)
+	return item, nil
+}
+
+// remove deletes the item with the given ID and its metadata for good
+func (t *trash) remove(id string) error {
+	if err := os.RemoveAll(filepath.Join(t.dir, id)); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(t.dir, id+".json"))
+}
+
+// purge deletes the items deleted before the cutoff for good and returns how many there were
+func (t *trash) purge(cutoff time.Time) (int, error) {
+	items, err := t.list()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, item := range items {
+		if item.DeletedAt.Before(cutoff) {
+			if err := t.remove(item.ID); err != nil {
+				return purged, fmt.Errorf("purging %s: %w", item.Path, err)
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// runTrashPurger purges items older than the retention period right away and
+// then every trashPurgeInterval until stop is closed
+func runTrashPurger(t *trash, retention time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if purged, err := t.purge(time.Now().Add(-retention)); err != nil {
+			log.Printf("Error purging the trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d items deleted more than %s ago from the trash", purged, retention)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ListTrash returns the items in the trash of an uploads directory, most recently deleted first
+func ListTrash(uploadsDir string) ([]TrashItem, error) {
+	return newTrash(uploadsDir).list()
+}
+
+// RestoreTrash moves the item with the given ID back into the uploads directory
+func RestoreTrash(uploadsDir, id string) (TrashItem, error) {
+	item, err := newTrash(uploadsDir).restore(id)
+	newAuditLog(uploadsDir).record(auditEntry{Session: "terminal", Op: "restore", Path: item.Path, TrashID: id, Error: errString(err)})
+	return item, err
+}
+
+// EmptyTrash deletes all items in the trash of an uploads directory for good
+// and returns how many there were
+func EmptyTrash(uploadsDir string) (int, error) {
+	purged, err := newTrash(uploadsDir).purge(time.Now().Add(time.Hour))
+	newAuditLog(uploadsDir).record(auditEntry{Session: "terminal", Op: "empty-trash", Error: errString(err)})
+	return purged, err
+}
@@ -0,0 +1,113 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTrashRestore tests restoring deleted files, also when the name was taken in the meantime
+func TestTrashRestore(t *testing.T) {
+	uploadsDir := t.TempDir()
+	notes := filepath.Join(uploadsDir, "notes.txt")
+	os.WriteFile(notes, []byte("old"), 0o644)
+	tr := newTrash(uploadsDir)
+
+	item, err := tr.add(notes, "uploads/notes.txt", "admin@test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := tr.list()
+	if err != nil || len(items) != 1 || items[0].ID != item.ID || items[0].Size != 3 {
+		t.Fatalf("Unexpected trash listing: %+v, %v", items, err)
+	}
+
+	// A new file took the name of the deleted one
+	os.WriteFile(notes, []byte("new"), 0o644)
+	restored, err := tr.restore(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Path != "uploads/notes.0.txt" {
+		t.Errorf("Expected the file to be restored as uploads/notes.0.txt, got %s", restored.Path)
+	}
+	if data, _ := os.ReadFile(filepath.Join(uploadsDir, "notes.0.txt")); string(data) != "old" {
+		t.Errorf("Expected the restored file to contain old, got %q", data)
+	}
+	if items, _ := tr.list(); len(items) != 0 {
+		t.Errorf("Expected the trash to be empty, got %d items", len(items))
+	}
+
+	if _, err := tr.restore("../audit"); err != errNotInTrash {
+		t.Errorf("Expected errNotInTrash for an invalid ID, got %v", err)
+	}
+}
+
+// TestTrashPurge tests that only items older than the cutoff are purged
+func TestTrashPurge(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, "b.txt"), []byte("b"), 0o644)
+	tr := newTrash(uploadsDir)
+	old, _ := tr.add(filepath.Join(uploadsDir, "a.txt"), "uploads/a.txt", "admin@test")
+	cutoff := time.Now()
+	tr.add(filepath.Join(uploadsDir, "b.txt"), "uploads/b.txt", "admin@test")
+
+	purged, err := tr.purge(cutoff)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected one purged item, got %d, %v", purged, err)
+	}
+	if _, err := os.Stat(filepath.Join(tr.dir, old.ID)); !os.IsNotExist(err) {
+		t.Error("Expected the old item to be removed from the trash")
+	}
+	if items, _ := ListTrash(uploadsDir); len(items) != 1 || items[0].Path != "uploads/b.txt" {
+		t.Errorf("Expected uploads/b.txt to be kept, got %+v", items)
+	}
+
+	if purged, _ := EmptyTrash(uploadsDir); purged != 1 {
+		t.Errorf("Expected emptying the trash to purge 1 item, got %d", purged)
+	}
+}
+
+// TestUndoDelete tests undoing a deletion from the index page
+func TestUndoDelete(t *testing.T) {
+	uploadsDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "photo.jpg"), []byte("photo"), 0o644)
+	mux := newMux(Config{UploadsDir: uploadsDir})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+	csrf := csrfToken(req)
+	post := func(form url.Values) string {
+		form.Set("csrf", csrf)
+		form.Set("back", "/")
+		req := httptest.NewRequest("POST", "/manage", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "key", Value: secretKey})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Header().Get("Location")
+	}
+
+	location, _ := url.Parse(post(url.Values{"op": {"delete"}, "path": {"uploads/photo.jpg"}}))
+	id := location.Query().Get("undo")
+	if id == "" {
+		t.Fatalf("Expected the redirect to offer an undo, got %s", location)
+	}
+
+	// The index page shows the undo banner
+	rr := servePreview(mux, location.String())
+	if !strings.Contains(rr.Body.String(), `name="id" value="`+id+`"`) {
+		t.Error("Expected an undo button on the page")
+	}
+
+	post(url.Values{"op": {"restore"}, "id": {id}})
+	if _, err := os.Stat(filepath.Join(uploadsDir, "photo.jpg")); err != nil {
+		t.Errorf("Expected the file to be restored: %v", err)
+	}
+}
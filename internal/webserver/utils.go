@@ -2,17 +2,44 @@ package webserver
 
 import (
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"unicode"
 
 	qrcode "github.com/skip2/go-qrcode"
 )
 
-// getUniqueFilename generates a unique filename by appending a suffix (.0, .1, etc.)
-// if a file with the same name already exists in the specified directory
+// sanitizeFilename reduces a client supplied name to a safe base name without
+// directory components or control characters
+func sanitizeFilename(filename string) string {
+	// Treat both kinds of separators as directory separators
+	filename = strings.ReplaceAll(filename, "\\", "/")
+	filename = path.Base(filename)
+
+	// Drop control characters that could confuse terminals and logs
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimSpace(filename)
+
+	if filename == "" || filename == "." || filename == ".." || filename == "/" {
+		return "upload"
+	}
+	return filename
+}
+
+// getUniqueFilename sanitizes filename and generates a unique filename by appending
+// a suffix (.0, .1, etc.) if a file with the same name already exists in the specified directory
 func getUniqueFilename(dir, filename string) string {
+	filename = sanitizeFilename(filename)
+
 	// Check if the original file exists
 	fullPath := filepath.Join(dir, filename)
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
@@ -36,22 +63,22 @@ func getUniqueFilename(dir, filename string) string {
 	}
 }
 
-// getLocalIP returns the non-loopback local IP of the host
-func getLocalIP() (string, error) {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return "", err
-	}
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String(), nil
-			}
+// createUniqueFile creates a new file in dir under a unique variant of filename.
+// The name is only taken when the file is created, so when another upload
+// takes it in the meantime the next free name is tried.
+func createUniqueFile(dir, filename string, flag int, perm os.FileMode) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(filepath.Join(dir, getUniqueFilename(dir, filename)), flag|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			return f, err
 		}
 	}
-	return "", fmt.Errorf("no local IP address found")
 }
 
+// console receives the messages for the user running the server. Output goes
+// to stderr instead when stdout carries data, like with receive --stdout.
+var console io.Writer = os.Stdout
+
 // printQRCode prints a QR code to the console for the given URL
 func printQRCode(url string) {
 	qr, err := qrcode.New(url, qrcode.Medium)
@@ -60,6 +87,6 @@ func printQRCode(url string) {
 		return
 	}
 
-	fmt.Println("\nScan this QR code with your mobile device to access the file sharing server:")
-	fmt.Println(qr.ToSmallString(false))
+	fmt.Fprintln(console, "\nScan this QR code with your mobile device to access the file sharing server:")
+	fmt.Fprintln(console, qr.ToSmallString(false))
 }
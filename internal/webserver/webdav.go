@@ -0,0 +1,243 @@
+package webserver
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// davFileSystem exposes the mounts as a webdav.FileSystem. The root lists the
+// mounts, read-only mounts reject every modification and writable mounts map
+// straight onto the host filesystem.
+type davFileSystem struct {
+	mounts []mount
+	// files records deletes and moves in the audit log and moves deleted items to the trash
+	files *fileManager
+}
+
+// davSessionKey is the context key of the session a WebDAV request belongs to, see sessionName
+type davSessionKey struct{}
+
+// withDAVSession passes the session of a WebDAV request on to the file system
+func withDAVSession(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), davSessionKey{}, sessionName(r)))
+}
+
+// davSession returns the session of the WebDAV request ctx belongs to
+func davSession(ctx context.Context) string {
+	session, _ := ctx.Value(davSessionKey{}).(string)
+	return session
+}
+
+// newDAVHandler returns a WebDAV handler for the mounts served under prefix,
+// changing files through files
+func newDAVHandler(prefix string, mounts []mount, files *fileManager) *webdav.Handler {
+	return &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: davFileSystem{mounts: mounts, files: files},
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+// davTarget is a WebDAV name resolved against the mounts
+type davTarget struct {
+	// root is set for the virtual root directory listing the mounts
+	root bool
+	// mount is the mount the name belongs to
+	mount mount
+	// mountRoot is set when the name refers to the mount itself
+	mountRoot bool
+	// hostPath is the location on the host filesystem
+	hostPath string
+}
+
+// resolve maps a WebDAV name onto the mounts
+func (d davFileSystem) resolve(name string) (davTarget, error) {
+	mountName, rel := splitVirtualPath(name)
+	if mountName == "" {
+		return davTarget{root: true}, nil
+	}
+
+	m, ok := findMount(d.mounts, mountName)
+	if !ok {
+		return davTarget{}, os.ErrNotExist
+	}
+	hostPath, err := m.resolve(rel)
+	if err != nil {
+		return davTarget{}, os.ErrNotExist
+	}
+	return davTarget{mount: m, mountRoot: rel == "", hostPath: hostPath}, nil
+}
+
+// Mkdir implements webdav.FileSystem
+func (d davFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	target, err := d.resolve(name)
+	if err != nil {
+		return err
+	}
+	if target.root || target.mountRoot {
+		return os.ErrExist
+	}
+	if !target.mount.Writable {
+		return os.ErrPermission
+	}
+	return os.Mkdir(target.hostPath, perm)
+}
+
+// OpenFile implements webdav.FileSystem
+func (d davFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	target, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	if target.root {
+		if writing {
+			return nil, os.ErrPermission
+		}
+		return d.rootDir(), nil
+	}
+	if writing && (!target.mount.Writable || target.mountRoot) {
+		return nil, os.ErrPermission
+	}
+
+	// A single shared file is presented as a directory holding just that file
+	if target.mountRoot && target.mount.isFileMount() {
+		info, err := os.Stat(target.hostPath)
+		if err != nil {
+			return nil, err
+		}
+		return &davVirtualDir{info: virtualDirInfo{name: target.mount.Name, modTime: info.ModTime()}, children: []fs.FileInfo{info}}, nil
+	}
+
+	// The uploads directory may not exist before the first upload
+	if target.mountRoot && target.mount.Writable {
+		if err := os.MkdirAll(target.hostPath, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	if writing {
+		return openUploadFile(target.hostPath, flag, perm, davSession(ctx))
+	}
+	return os.OpenFile(target.hostPath, flag, perm)
+}
+
+// RemoveAll implements webdav.FileSystem, moving the item to the trash
+func (d davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	target, err := d.resolve(name)
+	if err != nil {
+		return err
+	}
+	if target.root || target.mountRoot || !target.mount.Writable {
+		return os.ErrPermission
+	}
+	_, err = d.files.remove(davSession(ctx), name)
+	return err
+}
+
+// Rename implements webdav.FileSystem. Files can only be moved within a writable mount.
+func (d davFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldTarget, err := d.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	newTarget, err := d.resolve(newName)
+	if err != nil {
+		return err
+	}
+	if oldTarget.root || newTarget.root || oldTarget.mountRoot || newTarget.mountRoot {
+		return os.ErrPermission
+	}
+	if !oldTarget.mount.Writable || oldTarget.mount.Name != newTarget.mount.Name {
+		return os.ErrPermission
+	}
+	op := "move"
+	if path.Dir(path.Clean(oldName)) == path.Dir(path.Clean(newName)) {
+		op = "rename"
+	}
+	_, err = d.files.move(davSession(ctx), op, oldName, newName)
+	return err
+}
+
+// Stat implements webdav.FileSystem
+func (d davFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	target, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if target.root {
+		return virtualDirInfo{name: "/"}, nil
+	}
+
+	info, err := os.Stat(target.hostPath)
+	if target.mountRoot {
+		// Mounts always show up as directories named after the mount
+		modTime := time.Time{}
+		if err == nil {
+			modTime = info.ModTime()
+		} else if !target.mount.Writable {
+			return nil, err
+		}
+		return virtualDirInfo{name: target.mount.Name, modTime: modTime}, nil
+	}
+	return info, err
+}
+
+// rootDir returns the virtual directory listing the mounts
+func (d davFileSystem) rootDir() *davVirtualDir {
+	dir := &davVirtualDir{info: virtualDirInfo{name: "/"}}
+	for _, m := range d.mounts {
+		info, err := d.Stat(context.Background(), m.Name)
+		if err != nil {
+			continue
+		}
+		dir.children = append(dir.children, info)
+	}
+	return dir
+}
+
+// virtualDirInfo is the os.FileInfo of a directory that does not exist on disk
+type virtualDirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (v virtualDirInfo) Name() string       { return v.name }
+func (v virtualDirInfo) Size() int64        { return 0 }
+func (v virtualDirInfo) Mode() os.FileMode  { return os.ModeDir | 0o555 }
+func (v virtualDirInfo) ModTime() time.Time { return v.modTime }
+func (v virtualDirInfo) IsDir() bool        { return true }
+func (v virtualDirInfo) Sys() any           { return nil }
+
+// davVirtualDir is a read-only webdav.File for a directory that does not exist on disk
+type davVirtualDir struct {
+	info     virtualDirInfo
+	children []fs.FileInfo
+	read     bool
+}
+
+func (v *davVirtualDir) Close() error                   { return nil }
+func (v *davVirtualDir) Read(p []byte) (int, error)     { return 0, io.EOF }
+func (v *davVirtualDir) Write(p []byte) (int, error)    { return 0, os.ErrPermission }
+func (v *davVirtualDir) Seek(int64, int) (int64, error) { return 0, nil }
+func (v *davVirtualDir) Stat() (os.FileInfo, error)     { return v.info, nil }
+
+// Readdir implements webdav.File
+func (v *davVirtualDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if v.read {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	v.read = true
+	return v.children, nil
+}
@@ -0,0 +1,124 @@
+package webserver
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// newDAVTestServer starts a server with WebDAV enabled and returns a client for it
+func newDAVTestServer(t *testing.T, sharedDir, uploadsDir, password string) *gowebdav.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(newMux(Config{SharePath: sharedDir, UploadsDir: uploadsDir, WebDAV: true}))
+	t.Cleanup(srv.Close)
+
+	return gowebdav.NewClient(srv.URL+"/dav/", "goshare", password)
+}
+
+// TestWebDAV tests browsing, reading and writing through a WebDAV client
+func TestWebDAV(t *testing.T) {
+	sharedDir := t.TempDir()
+	uploadsDir := filepath.Join(t.TempDir(), "uploads")
+	if err := os.Mkdir(uploadsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, "shared.txt"), []byte("shared content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newDAVTestServer(t, sharedDir, uploadsDir, secretKey)
+
+	// The root lists the mounts
+	infos, err := c.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "shared" || names[1] != "uploads" {
+		t.Errorf("Unexpected root entries: %v", names)
+	}
+
+	// Shared files can be read
+	content, err := c.Read("/shared/shared.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "shared content" {
+		t.Errorf("Unexpected shared content: %q", content)
+	}
+
+	// Shared files are read-only
+	if err := c.Write("/shared/new.txt", []byte("nope"), 0o644); err == nil {
+		t.Error("Expected writing to the shared directory to fail")
+	}
+	if err := c.Remove("/shared/shared.txt"); err == nil {
+		t.Error("Expected deleting a shared file to fail")
+	}
+	if _, err := os.Stat(filepath.Join(sharedDir, "shared.txt")); err != nil {
+		t.Error("Expected the shared file to be kept")
+	}
+
+	// Uploads are writable
+	if err := c.Write("/uploads/new.txt", []byte("uploaded"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(filepath.Join(uploadsDir, "new.txt"))
+	if err != nil || string(stored) != "uploaded" {
+		t.Errorf("Expected the upload to be stored, got %q (%v)", stored, err)
+	}
+	if err := c.Mkdir("/uploads/folder", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rename("/uploads/new.txt", "/uploads/folder/new.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "folder", "new.txt")); err != nil {
+		t.Error("Expected the upload to be moved")
+	}
+
+	// Deleted uploads go to the trash and the audit log
+	if err := c.Remove("/uploads/folder/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "folder", "new.txt")); !os.IsNotExist(err) {
+		t.Error("Expected the upload to be deleted")
+	}
+	items, err := ListTrash(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Path != "uploads/folder/new.txt" {
+		t.Errorf("Expected the deleted upload in the trash, got %+v", items)
+	}
+	audit, err := os.ReadFile(filepath.Join(stateDir(uploadsDir), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(audit), `"op":"delete"`) || !strings.Contains(string(audit), `"op":"move"`) {
+		t.Errorf("Expected the move and delete in the audit log, got %s", audit)
+	}
+
+	// Paths cannot escape the mounts
+	if _, err := c.Read("/uploads/../../etc/passwd"); err == nil {
+		t.Error("Expected reading outside the mounts to fail")
+	}
+}
+
+// TestWebDAVRequiresKey tests that WebDAV clients must authenticate with the key
+func TestWebDAVRequiresKey(t *testing.T) {
+	c := newDAVTestServer(t, "", t.TempDir(), "wrong")
+
+	if _, err := c.ReadDir("/"); err == nil {
+		t.Error("Expected listing with a wrong key to fail")
+	}
+}
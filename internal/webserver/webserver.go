@@ -1,39 +1,113 @@
 package webserver
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
 )
 
 //go:embed templates/index.html
 var indexHTML string
 
+// indexTemplate is parsed once at startup instead of on every request
+var indexTemplate = template.Must(template.New("index.html").Parse(indexHTML))
+
+// indexPageSize is the number of files per list on the index page
+const indexPageSize = 100
+
 // templateData holds the data for the index template
 type templateData struct {
-	Message      string
-	MessageType  string
+	Message     string
+	MessageType string
+	// Undo is the trash ID of an item that was just deleted
+	Undo string
+	// Quota sums up how much the visitor may still upload
+	Quota        string
 	Key          string
+	Role         string
+	CSRF         string
+	Filter       string
 	SharedFiles  []fileInfo
+	SharedPage   pageInfo
 	UploadsFiles []fileInfo
+	UploadsPage  pageInfo
 }
 
-// renderIndexTemplate renders the index.html template with the provided data
-func renderIndexTemplate(w http.ResponseWriter, r *http.Request, uploadsDir, sharePath string) error {
-	// Parse the embedded template
-	tmpl, err := template.New("index.html").Parse(indexHTML)
-	if err != nil {
-		return err
+// CanManage reports whether the visitor may delete uploaded files
+func (d templateData) CanManage() bool {
+	return canManage(d.Role)
+}
+
+// CanUpload reports whether the upload form is shown, which viewers do not get
+func (d templateData) CanUpload() bool {
+	return d.Role != roleViewer
+}
+
+// pageInfo describes the shown page of a paginated file list
+type pageInfo struct {
+	Page    int
+	Pages   int
+	Total   int
+	PrevURL string
+	NextURL string
+}
+
+// paginateFiles filters files by name and returns the page selected by the
+// query parameter param along with links to the neighbouring pages
+func paginateFiles(r *http.Request, files []fileInfo, filter, param string) ([]fileInfo, pageInfo) {
+	var matching []fileInfo
+	for _, f := range files {
+		if matchName(filter, f.Name) {
+			matching = append(matching, f)
+		}
+	}
+
+	info := pageInfo{Page: 1, Total: len(matching), Pages: max(1, (len(matching)+indexPageSize-1)/indexPageSize)}
+	if page, err := strconv.Atoi(r.URL.Query().Get(param)); err == nil {
+		info.Page = min(max(page, 1), info.Pages)
+	}
+
+	pageURL := func(page int) string {
+		query := r.URL.Query()
+		query.Set(param, strconv.Itoa(page))
+		query.Del("message")
+		query.Del("type")
+		return "/?" + query.Encode()
+	}
+	if info.Page > 1 {
+		info.PrevURL = pageURL(info.Page - 1)
+	}
+	if info.Page < info.Pages {
+		info.NextURL = pageURL(info.Page + 1)
 	}
 
+	start, end := pageBounds(len(matching), (info.Page-1)*indexPageSize, indexPageSize)
+	return matching[start:end], info
+}
+
+// renderIndexTemplate renders the index.html template with the provided data
+func renderIndexTemplate(w http.ResponseWriter, r *http.Request, uploadsDir, sharePath string, streams ...fileInfo) error {
 	// Prepare template data
 	data := templateData{
-		Key: secretKey,
+		Filter: r.URL.Query().Get("filter"),
+		CSRF:   csrfToken(r),
+	}
+	if cookie, err := r.Cookie("key"); err == nil {
+		data.Key = cookie.Value
+	}
+	data.Role, _ = requestRole(r)
+	data.Quota = uploadQuota.status(sessionName(r)).describe()
+	if data.CanManage() {
+		data.Undo = r.URL.Query().Get("undo")
 	}
 
 	// Get files from uploads directory
@@ -42,7 +116,7 @@ func renderIndexTemplate(w http.ResponseWriter, r *http.Request, uploadsDir, sha
 		data.Message = "Error accessing uploads directory: " + err.Error()
 		data.MessageType = "error"
 	} else {
-		data.UploadsFiles = uploadsFileInfoList
+		data.UploadsFiles, data.UploadsPage = paginateFiles(r, uploadsFileInfoList, data.Filter, "uploads_page")
 	}
 
 	// If sharePath is provided, get file info to display
@@ -53,10 +127,13 @@ func renderIndexTemplate(w http.ResponseWriter, r *http.Request, uploadsDir, sha
 			data.Message = "Error accessing shared path: " + err.Error()
 			data.MessageType = "error"
 		} else {
-			data.SharedFiles = fileInfoList
+			data.SharedFiles, data.SharedPage = paginateFiles(r, fileInfoList, data.Filter, "shared_page")
 		}
 	}
 
+	// Streams are always listed first, next to the shared files
+	data.SharedFiles = append(streams, data.SharedFiles...)
+
 	// Get any message from query parameters
 	if message := r.URL.Query().Get("message"); message != "" {
 		data.Message = message
@@ -68,28 +145,14 @@ func renderIndexTemplate(w http.ResponseWriter, r *http.Request, uploadsDir, sha
 	}
 
 	// Execute the template
-	return tmpl.Execute(w, data)
+	return indexTemplate.Execute(w, data)
 }
 
-// Run starts an HTTP server on the specified port that responds with a file upload form on the root path
-// and handles file uploads on the /upload path
-func Run(sharePath string, uploadsDir string, port int) {
-	// Set default uploads directory if not provided
-	defaultUploadsDir := "uploads"
-	if uploadsDir == "" {
-		uploadsDir = defaultUploadsDir
-	} else {
-		// Check if specified uploads directory already exists
-		if _, err := os.Stat(uploadsDir); err == nil {
-			log.Fatalf("Error: uploads directory '%s' already exists in current working directory", uploadsDir)
-		}
+// newMux registers all routes of the file sharing server on a new ServeMux
+func newMux(cfg Config) *http.ServeMux {
+	sharePath, uploadsDir := cfg.SharePath, cfg.UploadsDir
+	mux := http.NewServeMux()
 
-		// Create specified uploads directory
-		err := os.MkdirAll(uploadsDir, os.ModePerm)
-		if err != nil {
-			log.Fatalf("Error creating uploads directory: %v", err)
-		}
-	}
 	// If sharePath is provided, set up file serving
 	if sharePath != "" {
 		// Check if the path exists
@@ -102,35 +165,79 @@ func Run(sharePath string, uploadsDir string, port int) {
 			if info.IsDir() {
 				// Serve files from the directory
 				fileServer := http.StripPrefix("/shared/", http.FileServer(http.Dir(sharePath)))
-				http.Handle("/shared/", loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
-					// Apply authentication check
-					if !validateKeyCookie(r) {
-						http.Error(w, "Unauthorized: invalid or missing key cookie", http.StatusUnauthorized)
-						return
-					}
-					fileServer.ServeHTTP(w, r)
-				}))
+				mux.Handle("/shared/", loggingMiddleware(requireKey(checksumHandler(getMounts(sharePath, uploadsDir), fileServer.ServeHTTP))))
 			} else {
 				// Serve the single file
-				http.HandleFunc("/shared/"+info.Name(), loggingMiddleware(requireKey(func(w http.ResponseWriter, r *http.Request) {
+				serveFile := checksumHandler(getMounts(sharePath, uploadsDir), func(w http.ResponseWriter, r *http.Request) {
 					http.ServeFile(w, r, sharePath)
-				})))
+				})
+				mux.HandleFunc("/shared/"+info.Name(), loggingMiddleware(requireKey(serveFile)))
+				mux.HandleFunc("/shared/"+sumsFileName, loggingMiddleware(requireKey(serveFile)))
 			}
 		}
 	}
 	// Set up file serving for uploads directory
+	// PUT stores the raw request body as a new upload
 	fileServer := http.StripPrefix("/uploads/", http.FileServer(http.Dir(uploadsDir)))
-	http.Handle("/uploads/", loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		// Apply authentication check
-		if !validateKeyCookie(r) {
-			http.Error(w, "Unauthorized: invalid or missing key cookie", http.StatusUnauthorized)
+	mux.Handle("/uploads/", loggingMiddleware(requireKey(checksumHandler(getMounts(sharePath, uploadsDir), func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			requireRole(canUpload, func(w http.ResponseWriter, r *http.Request) {
+				handlePutUpload(w, r, uploadsDir)
+			})(w, r)
 			return
 		}
 		fileServer.ServeHTTP(w, r)
-	}))
+	}))))
+
+	// Delete, rename and move files and create folders from the web pages and over WebDAV
+	fm := newFileManager(cfg)
+
+	// Serve shared files (read-only) and uploads (writable) over WebDAV
+	if cfg.WebDAV {
+		davHandler := newDAVHandler("/dav", getMounts(sharePath, uploadsDir), fm)
+		mux.HandleFunc("/dav/", loggingMiddleware(requireKey(func(w http.ResponseWriter, r *http.Request) {
+			// Files written over WebDAV count against the upload quotas of the session
+			r = withDAVSession(r)
+			// Viewers can browse and download, but not change anything
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+				davHandler.ServeHTTP(w, r)
+			case http.MethodPut:
+				requireRole(canUpload, func(w http.ResponseWriter, r *http.Request) {
+					// Refuse uploads that cannot fit before receiving them
+					if err := uploadQuota.check(sessionName(r), r.ContentLength); err != nil {
+						http.Error(w, "Error: "+err.Error(), uploadErrorStatus(err))
+						return
+					}
+					davHandler.ServeHTTP(w, r)
+				})(w, r)
+			default:
+				requireRole(canUpload, davHandler.ServeHTTP)(w, r)
+			}
+		})))
+	}
+
+	if fm != nil {
+		mux.HandleFunc("/manage", loggingMiddleware(requireKey(requireRole(canManage, fm.ServeHTTP))))
+	}
+
+	// Preview files and browse directories with their README
+	mux.HandleFunc("/preview/", loggingMiddleware(requireKey(previewHandler(getMounts(sharePath, uploadsDir), fm))))
+
+	// Show image directories as a gallery of thumbnails
+	mux.HandleFunc("/gallery/", loggingMiddleware(requireKey(galleryHandler(getMounts(sharePath, uploadsDir)))))
+	mux.HandleFunc("/thumb/", loggingMiddleware(requireKey(thumbnailHandler(getMounts(sharePath, uploadsDir)))))
+
+	// Search file names and, if enabled, contents on a page and through the API
+	search := newSearcher(getMounts(sharePath, uploadsDir), cfg.SearchContent)
+	mux.HandleFunc("/search", loggingMiddleware(requireKey(search.ServeHTTP)))
+
+	// Serve the JSON API and its OpenAPI description
+	mux.HandleFunc("/api/v1/", loggingMiddleware(requireAPIKey(apiHandler(sharePath, uploadsDir, search, fm))))
+	mux.HandleFunc("/api/v1/openapi.json", loggingMiddleware(openAPIHandler))
 
 	// Handle root path - serve HTML with file upload form and shared files
-	http.HandleFunc("/", loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
 
 		if !validateKeyCookie((r)) {
 			if validateKey(r) {
@@ -138,7 +245,7 @@ func Run(sharePath string, uploadsDir string, port int) {
 				// Set the key as a cookie with enhanced security
 				http.SetCookie(w, &http.Cookie{
 					Name:     "key",
-					Value:    secretKey,
+					Value:    r.URL.Query().Get("key"),
 					Path:     "/",
 					HttpOnly: true,
 					// Secure: true,
@@ -154,8 +261,22 @@ func Run(sharePath string, uploadsDir string, port int) {
 			}
 		}
 
+		// Streams are listed next to the shared files
+		indexSharePath := sharePath
+		var streams []fileInfo
+		if sharePath == StdinSharePath {
+			indexSharePath = ""
+			streams = append(streams, fileInfo{Name: streamName(cfg), Size: -1, URL: "/shared/" + url.PathEscape(streamName(cfg))})
+		}
+		if cfg.Follow != "" {
+			streams = append(streams, fileInfo{Name: followStreamName(cfg), Size: -1, URL: "/live/" + url.PathEscape(followStreamName(cfg))})
+		}
+		if len(cfg.Exec) > 0 {
+			streams = append(streams, fileInfo{Name: execStreamName(cfg), Size: -1, URL: "/live/" + url.PathEscape(execStreamName(cfg))})
+		}
+
 		// Render the template with the appropriate data
-		err := renderIndexTemplate(w, r, uploadsDir, sharePath)
+		err := renderIndexTemplate(w, r, uploadsDir, indexSharePath, streams...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -163,13 +284,19 @@ func Run(sharePath string, uploadsDir string, port int) {
 	}))
 
 	// Handle file upload
-	http.HandleFunc("/upload", loggingMiddleware(requireKey(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/upload", loggingMiddleware(requireKey(requireRole(canUpload, func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		// Refuse uploads that cannot fit before receiving them
+		if err := uploadQuota.check(sessionName(r), r.ContentLength); err != nil {
+			http.Redirect(w, r, "/?"+url.Values{"message": {"Error: " + err.Error()}, "type": {"error"}}.Encode(), http.StatusSeeOther)
+			return
+		}
+
 		// Parse multipart form with max memory of 32MB
 		err := r.ParseMultipartForm(32 << 20)
 		if err != nil {
@@ -185,76 +312,268 @@ func Run(sharePath string, uploadsDir string, port int) {
 		}
 		defer file.Close()
 
-		// Create uploads directory if it doesn't exist
-		err = os.MkdirAll(uploadsDir, os.ModePerm)
+		// Store the file under a unique name in the uploads directory, checking it
+		// against the checksum from the form, if any
+		expected, err := expectedChecksums(r, 1)
 		if err != nil {
-			http.Redirect(w, r, "/?message=Error creating uploads directory: "+err.Error()+"&type=error", http.StatusSeeOther)
+			http.Redirect(w, r, "/?"+url.Values{"message": {"Error: " + err.Error()}, "type": {"error"}}.Encode(), http.StatusSeeOther)
 			return
 		}
-
-		// Generate a unique filename if file already exists
-		uniqueFilename := getUniqueFilename(uploadsDir, handler.Filename)
-
-		// Create destination file with unique name
-		dst, err := os.Create(fmt.Sprintf("%s/%s", uploadsDir, uniqueFilename))
+		stored, err := saveVerifiedUpload(uploadsDir, handler.Filename, sessionName(r), expected[0], file)
 		if err != nil {
-			http.Redirect(w, r, "/?message=Error creating file: "+err.Error()+"&type=error", http.StatusSeeOther)
+			http.Redirect(w, r, "/?"+url.Values{"message": {"Error: " + err.Error()}, "type": {"error"}}.Encode(), http.StatusSeeOther)
 			return
 		}
-		defer dst.Close()
-
-		// Copy uploaded file to destination
-		_, err = io.Copy(dst, file)
-		if err != nil {
-			http.Redirect(w, r, "/?message=Error saving file: "+err.Error()+"&type=error", http.StatusSeeOther)
+		if stored.Duplicate {
+			message := "This file was already uploaded as " + stored.Name + ", it was not stored again"
+			http.Redirect(w, r, "/?"+url.Values{"message": {message}, "type": {"success"}}.Encode(), http.StatusSeeOther)
 			return
 		}
 
 		// Redirect back to home page with success message
 		http.Redirect(w, r, "/?message=File uploaded successfully!&type=success", http.StatusSeeOther)
-	})))
-
-	// Determine the port to use
-	var actualPort int
-	if port > 0 {
-		// Validate port range
-		if port < 1 || port > 65535 {
-			log.Fatalf("Invalid port number: %d. Port must be between 1 and 65535.", port)
-		}
-		actualPort = port
+	}))))
+
+	return mux
+}
+
+// Run starts an HTTP server on the specified port that responds with a file upload form on the root path
+// and handles file uploads on the /upload path
+func Run(cfg Config) error {
+	// Set default uploads directory if not provided
+	defaultUploadsDir := "uploads"
+	if cfg.UploadsDir == "" {
+		cfg.UploadsDir = defaultUploadsDir
 	} else {
-		// Find an available port
-		listener, err := net.Listen("tcp", "0.0.0.0:0")
+		// Check if specified uploads directory already exists
+		if _, err := os.Stat(cfg.UploadsDir); err == nil {
+			log.Fatalf("Error: uploads directory '%s' already exists in current working directory", cfg.UploadsDir)
+		}
+
+		// Create specified uploads directory
+		err := os.MkdirAll(cfg.UploadsDir, os.ModePerm)
+		if err != nil {
+			log.Fatalf("Error creating uploads directory: %v", err)
+		}
+	}
+
+	if cfg.Once && cfg.SharePath == "" {
+		log.Fatalf("Error: --once needs a file or directory to share")
+	}
+
+	// Stdout carries the received data, so messages for the user go to stderr
+	if cfg.ReceiveStdout {
+		console = os.Stderr
+	}
+
+	mux := newMux(cfg)
+
+	// Streams are transferred once, the server exits afterwards
+	var streamDone chan error
+	if cfg.SharePath == StdinSharePath {
+		share := newStreamShare(streamName(cfg), os.Stdin)
+		mux.HandleFunc(share.url(), loggingMiddleware(requireKey(share.ServeHTTP)))
+		streamDone = share.done
+	}
+	if cfg.ReceiveStdout {
+		receiver := newStreamReceiver(os.Stdout)
+		receiver.register(mux)
+		streamDone = receiver.done
+	}
+
+	// Live streams never end on their own, they are closed when the server shuts down
+	shuttingDown := make(chan struct{})
+	if cfg.Follow != "" {
+		live := &liveStream{name: followStreamName(cfg), src: followedFile{path: cfg.Follow}, stop: shuttingDown}
+		live.register(mux)
+	}
+	var execOutput *liveBuffer
+	if len(cfg.Exec) > 0 {
+		if cfg.Follow != "" && followStreamName(cfg) == execStreamName(cfg) {
+			log.Fatalf("Error: the followed file and the command output are both named %s", execStreamName(cfg))
+		}
+		execOutput = newLiveBuffer()
+		live := &liveStream{name: execStreamName(cfg), src: execOutput, stop: shuttingDown}
+		live.register(mux)
+	}
+
+	// Deleted items only stay in the trash for a while
+	if cfg.TrashRetention > 0 {
+		go runTrashPurger(newTrash(cfg.UploadsDir), cfg.TrashRetention, shuttingDown)
+	}
+
+	// Remember the checksums of uploads and downloads across restarts
+	checksums = newChecksumStore(filepath.Join(stateDir(cfg.UploadsDir), "checksums.json"))
+
+	// Store identical uploads only once
+	if cfg.Dedup {
+		blobs, err := newBlobStore(cfg.UploadsDir)
 		if err != nil {
-			log.Fatal("Failed to find available port:", err)
+			log.Fatalf("Error: --dedup: %v", err)
 		}
+		count, saved := blobs.stats()
+		log.Printf("Deduplicating uploads, %d distinct file(s) stored, %s saved", count, formatSize(saved))
+		uploadBlobs = blobs
+		go runBlobCollector(uploadBlobs, shuttingDown)
+	}
+
+	// Limit the space uploads may take
+	uploadQuota = newQuotaPolicy(cfg)
+
+	// Throttle transfers, the limits can be changed while the server runs
+	transfers.set(transferLimits{
+		DownloadRate:       cfg.DownloadRate,
+		UploadRate:         cfg.UploadRate,
+		ClientDownloadRate: cfg.ClientDownloadRate,
+		ClientUploadRate:   cfg.ClientUploadRate,
+		MaxTransfers:       cfg.MaxTransfers,
+	})
+	if limits := transfers.get().transferLimits; limits != (transferLimits{}) {
+		log.Printf("Transfer limits: %s", limits)
+	}
 
-		// Get the actual port that was assigned
-		actualPort = listener.Addr().(*net.TCPAddr).Port
+	// Remove uploads according to the retention rules
+	if uploadRetention = newRetentionPolicy(cfg); uploadRetention != nil {
+		go runJanitor(uploadRetention, shuttingDown)
+	}
+
+	// Publish file changes to the open pages
+	hub := newEventHub(shuttingDown)
+	watcher := newFileWatcher(getMounts(cfg.SharePath, cfg.UploadsDir), hub)
+	observeUploads(func(string) { watcher.rescan() })
+	go watcher.run(shuttingDown)
+	hub.register(mux)
 
-		// Close the listener temporarily - we'll recreate it with the http server
-		listener.Close()
+	if cfg.Bind != "" && cfg.Interface != "" {
+		log.Fatalf("Error: --bind and --interface cannot be used together")
+	}
+	if cfg.Bind != "" && net.ParseIP(cfg.Bind) == nil {
+		log.Fatalf("Error: invalid bind address %q", cfg.Bind)
 	}
 
-	// Get local IP address
-	localIP, err := getLocalIP()
+	// Collect the addresses the server can be reached on, best first
+	addrs, err := serverAddrs(cfg)
 	if err != nil {
-		log.Printf("Warning: Could not determine local IP address: %v", err)
-		log.Printf("Starting server on 0.0.0.0:%d", actualPort)
-		fmt.Printf("Server URL: http://localhost:%d\n", actualPort)
-	} else {
-		serverURL := fmt.Sprintf("http://%s:%d", localIP, actualPort)
-		serverURLWithKey := fmt.Sprintf("%s?key=%s", serverURL, secretKey)
-		log.Printf("Starting server on 0.0.0.0:%d (accessible from: %s)", actualPort, serverURLWithKey)
-		fmt.Printf("Server URL: %s\n", serverURLWithKey)
+		log.Fatalf("Error: %v", err)
+	}
+	hosts := listenHosts(cfg, addrs)
+	if len(hosts) == 0 {
+		log.Fatalf("Error: network interface %q has no usable address", cfg.Interface)
+	}
 
-		// Print QR code for easy mobile access
-		printQRCode(serverURLWithKey)
+	if cfg.Port != 0 && cfg.PortRange != "" {
+		log.Fatalf("Error: --port and --port-range cannot be used together")
 	}
 
-	address := fmt.Sprintf("0.0.0.0:%d", actualPort)
-	err = http.ListenAndServe(address, nil)
+	// Open the listeners up front and serve them directly, so the port cannot
+	// be taken by another process in between
+	listeners, actualPort, err := openListeners(cfg, hosts)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Error starting server: %v", err)
+	}
+
+	if actualPort > 0 {
+		printServerURLs(addrs, actualPort)
+	} else {
+		fmt.Fprintf(console, "Server key: %s\n", secretKey)
+	}
+
+	// Run the command once the URLs are printed, so they do not get lost in its output
+	if execOutput != nil {
+		if err := startCommand(cfg.Exec, execOutput); err != nil {
+			log.Fatalf("Error running %s: %v", cfg.Exec[0], err)
+		}
+	}
+
+	// Start the SFTP and S3 listeners and the mDNS announcement next to the HTTP
+	// server; all of them are stopped together with it
+	var shutdowns []func(context.Context) error
+	if cfg.SFTPAddr != "" {
+		shutdowns = append(shutdowns, startSFTP(cfg))
+	}
+	if cfg.S3Addr != "" {
+		shutdowns = append(shutdowns, startS3(cfg))
+	}
+	if cfg.MDNS && actualPort > 0 {
+		if shutdown := startMDNS(cfg, actualPort); shutdown != nil {
+			shutdowns = append(shutdowns, shutdown)
+		}
+	}
+
+	grace := cfg.ShutdownTimeout
+	if grace <= 0 {
+		grace = DefaultShutdownTimeout
+	}
+	tracker := newTransferTracker()
+	handler := tracker.middleware(transfers.middleware(mux))
+	if cfg.ExpireAfterDownload > 0 {
+		handler = uploadRetention.middleware(handler)
+	}
+
+	// One-shot modes stop the server once their job is done
+	stop := make(chan error, 1)
+	if streamDone != nil {
+		go func() {
+			requestStop(stop, <-streamDone)
+		}()
+	}
+	if cfg.Once && cfg.SharePath != StdinSharePath {
+		watcher, err := newDownloadWatcher(cfg.SharePath)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		handler = watcher.middleware(handler)
+		go func() {
+			<-watcher.done
+			log.Printf("All shared files were downloaded")
+			requestStop(stop, nil)
+		}()
+	}
+	if cfg.ReceiveOnce {
+		observeUploads(func(hostPath string) {
+			log.Printf("Received %s", hostPath)
+			requestStop(stop, nil)
+		})
 	}
+	if cfg.Timeout > 0 {
+		go watchInactivity(tracker, cfg.Timeout, stop)
+	}
+
+	server := &http.Server{Handler: handler}
+	server.RegisterOnShutdown(func() { close(shuttingDown) })
+	return serveUntilSignal(server, listeners, tracker, grace, shutdowns, stop)
+}
+
+// printRoleURLs prints links for sessions with fewer rights than the admin key
+func printRoleURLs(serverURL string) {
+	w := tabwriter.NewWriter(console, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  Uploader link:\t%s?key=%s\n", serverURL, roleKey(roleUploader))
+	fmt.Fprintf(w, "  Viewer link:\t%s?key=%s\n", serverURL, roleKey(roleViewer))
+	w.Flush()
+}
+
+// printServerURLs prints a URL with the key for every address and a QR code for the best one
+func printServerURLs(addrs []interfaceAddr, port int) {
+	if len(addrs) == 0 {
+		log.Printf("Warning: Could not determine a local IP address")
+		fmt.Fprintf(console, "Server URL: %s?key=%s\n", addrURL(nil, port), secretKey)
+		printRoleURLs(addrURL(nil, port))
+		return
+	}
+
+	best := fmt.Sprintf("%s?key=%s", addrURL(addrs[0].IP, port), secretKey)
+	fmt.Fprintf(console, "Server URL: %s\n", best)
+	printRoleURLs(addrURL(addrs[0].IP, port))
+
+	if len(addrs) > 1 {
+		fmt.Fprintln(console, "Also reachable at:")
+		w := tabwriter.NewWriter(console, 0, 0, 2, ' ', 0)
+		for _, addr := range addrs[1:] {
+			fmt.Fprintf(w, "  %s\t%s?key=%s\n", addr.Interface, addrURL(addr.IP, port), secretKey)
+		}
+		w.Flush()
+	}
+
+	// Print QR code for easy mobile access
+	printQRCode(best)
 }